@@ -0,0 +1,136 @@
+package main
+
+import (
+	"broker-service/auth"
+	broker "broker-service/server"
+	"common/logging"
+	"common/memnet"
+	"common/tracing"
+	"context"
+	"flag"
+	"log/slog"
+	medapi "med-api-service/server"
+	scraper "med-scraper-service/server"
+	"net"
+	"net/http"
+	"os"
+	search "search-service/server"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	serviceName = "all-in-one"
+	ctxTimeOut  = 15 * time.Second
+)
+
+func main() {
+	slog.SetDefault(logging.New(serviceName))
+
+	// Service flags
+	addr := flag.String("addr", envOr("ADDR", ":8080"), "Address the broker listens on")
+	mongoURI := flag.String("mongoURI", os.Getenv("MONGO_URI"), "MongoDB connection string (the collections are kept in memory when empty)")
+	keyStorePath := flag.String("keyStore", envOr("BROKER_KEY_STORE", "keys.json"), "Path to the file that stores the API keys")
+	adminKey := flag.String("adminKey", os.Getenv("BROKER_ADMIN_KEY"), "Key that can always access the admin endpoints")
+	accountScopes := flag.String("accountScopes", os.Getenv("BROKER_ACCOUNT_SCOPES"), "Comma separated scopes of the tokens issued to accounts (built-in scopes are used when empty)")
+	tokenSecret := flag.String("tokenSecret", os.Getenv("BROKER_TOKEN_SECRET"), "Secret that signs client tokens (token auth is disabled when empty)")
+	routesPath := flag.String("routes", "", "Path to the JSON routing config of the broker (built-in routes are used when empty)")
+	limitsPath := flag.String("limits", "", "Path to the JSON rate limit config of the broker (built-in limits are used when empty)")
+	webhookSecret := flag.String("webhookSecret", os.Getenv("WEBHOOK_SECRET"), "Secret that signs webhook notifications (webhooks are disabled when empty)")
+	traceFile := flag.String("traceFile", os.Getenv("TRACE_FILE"), "File that spans get written to when no OTLP endpoint is set (tracing is disabled when neither is given)")
+
+	flag.Parse()
+
+	shutdownTracing, err := tracing.Setup(context.Background(), serviceName, *traceFile)
+	if err != nil {
+		logging.Fatal("Could not set up tracing", err)
+	}
+
+	// The services call each other by their host names, which the network connects in memory,
+	// while the calls to any other host, such as the sites that get scraped, go over the real network
+	network := memnet.New()
+	http.DefaultTransport = network.Transport()
+
+	var client *mongo.Client
+	if *mongoURI != "" {
+		client, err = mongo.Connect(context.Background(), options.Client().ApplyURI(*mongoURI))
+		if err != nil {
+			logging.Fatal("Could not connect to mongo", err)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeOut)
+	defer cancel()
+
+	searchHandler, err := search.New(ctx, search.Config{
+		Mongo:             client,
+		JobWorkers:        4,
+		JobQueue:          100,
+		JobTimeout:        5 * time.Minute,
+		WebhookSecret:     *webhookSecret,
+		WebhookAttempts:   5,
+		WebhookRetryDelay: 2 * time.Second,
+	})
+	if err != nil {
+		logging.Fatal("Could not set up the search service", err)
+	}
+
+	brokerHandler, err := broker.New(broker.Config{
+		RoutesPath:       *routesPath,
+		BreakerThreshold: 5,
+		BreakerTimeout:   30 * time.Second,
+		LimitsPath:       *limitsPath,
+		KeyStorePath:     *keyStorePath,
+		TokenSecret:      *tokenSecret,
+		AdminKey:         *adminKey,
+		AccountScopes:    auth.ParseScopes(*accountScopes),
+		CacheSize:        64 << 20,
+	})
+	if err != nil {
+		logging.Fatal("Could not set up the broker", err)
+	}
+
+	services := map[string]http.Handler{
+		"search-service":      searchHandler,
+		"med-scraper-service": scraper.New(scraper.Config{}),
+		"med-api-service":     medapi.New(medapi.Config{}),
+	}
+
+	for host, handler := range services {
+		go serve(host, handler, network.Listen(host))
+	}
+
+	srv := &http.Server{
+		Addr:    *addr,
+		Handler: brokerHandler,
+	}
+
+	slog.Info("Starting the services in one process", "port", *addr, "in_memory_store", client == nil)
+
+	err = srv.ListenAndServe()
+
+	// logging.Fatal exits without running deferred calls, so the spans left get flushed first
+	shutdownTracing(context.Background())
+
+	logging.Fatal("Broker stopped", err)
+}
+
+// serve serves the handler of a service on the in-memory listener of its host
+func serve(host string, handler http.Handler, listener net.Listener) {
+	srv := &http.Server{Handler: handler}
+
+	err := srv.Serve(listener)
+
+	logging.Fatal("Service "+host+" stopped", err)
+}
+
+// envOr returns the value of the provided environment variable or fallback if it is not set
+func envOr(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
+
+	return fallback
+}
@@ -0,0 +1,57 @@
+package auth
+
+import (
+	"context"
+	"strings"
+)
+
+const (
+	// ScopeAll allows a client to call every action
+	ScopeAll = "*"
+	// ScopeAdmin allows a client to manage the broker (keys, tokens)
+	ScopeAdmin = "admin"
+)
+
+type contextKey struct{}
+
+// Identity is the authenticated client of a request, either an API key or a signed token
+type Identity struct {
+	ID     string   `json:"id"`
+	Scopes []string `json:"scopes"`
+}
+
+// Allows reports whether the identity is allowed to use the provided scope (an action name or admin)
+func (i *Identity) Allows(scope string) bool {
+	for _, s := range i.Scopes {
+		if s == scope || (s == ScopeAll && scope != ScopeAdmin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ParseScopes returns the scopes of a comma separated list, e.g. "search,history"
+func ParseScopes(list string) []string {
+	var scopes []string
+
+	for _, scope := range strings.Split(list, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return scopes
+}
+
+// NewContext returns a copy of ctx that carries the provided identity
+func NewContext(ctx context.Context, identity *Identity) context.Context {
+	return context.WithValue(ctx, contextKey{}, identity)
+}
+
+// FromContext returns the identity stored in ctx and whether there was one
+func FromContext(ctx context.Context) (*Identity, bool) {
+	identity, ok := ctx.Value(contextKey{}).(*Identity)
+
+	return identity, ok
+}
@@ -0,0 +1,296 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// keyPrefix is prepended to every generated API key so that keys are recognizable
+const keyPrefix = "mex_"
+
+// ErrNotFound is returned when a credential with the provided id does not exist
+var ErrNotFound = errors.New("credential not found")
+
+// Credential is an API key issued to a client. Only the hash of the key is kept.
+// The version counts the rotations of the key, which revoke the tokens issued for the previous ones
+type Credential struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	KeyHash   string     `json:"key_hash,omitempty"`
+	Scopes    []string   `json:"scopes"`
+	Version   int        `json:"version"`
+	CreatedAt time.Time  `json:"created_at"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty"`
+}
+
+// Store keeps the API key credentials in memory and persists them in a JSON file
+type Store struct {
+	mu          sync.RWMutex
+	path        string
+	credentials map[string]*Credential
+}
+
+// NewStore creates a Store and loads the credentials saved in the file in the provided path.
+// An empty path keeps the credentials only in memory
+func NewStore(path string) (*Store, error) {
+	s := &Store{
+		path:        path,
+		credentials: make(map[string]*Credential),
+	}
+
+	if path == "" {
+		return s, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return s, nil
+		}
+
+		return nil, err
+	}
+
+	var credentials []*Credential
+
+	err = json.Unmarshal(data, &credentials)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, c := range credentials {
+		s.credentials[c.ID] = c
+	}
+
+	return s, nil
+}
+
+// Create creates a new credential with the provided name and scopes
+// and returns it along with its API key, which can not be retrieved again
+func (s *Store) Create(name string, scopes []string) (*Credential, string, error) {
+	id, err := randomHex(8)
+	if err != nil {
+		return nil, "", err
+	}
+
+	key, err := newKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	c := &Credential{
+		ID:        id,
+		Name:      name,
+		KeyHash:   hashKey(key),
+		Scopes:    scopes,
+		CreatedAt: time.Now(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.credentials[id] = c
+
+	err = s.save()
+	if err != nil {
+		delete(s.credentials, id)
+		return nil, "", err
+	}
+
+	return c.public(), key, nil
+}
+
+// Rotate replaces the API key of the credential with the provided id
+// and returns the new key. The old key and the tokens issued for it stop working immediately
+func (s *Store) Rotate(id string) (*Credential, string, error) {
+	key, err := newKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.credentials[id]
+	if !ok {
+		return nil, "", ErrNotFound
+	}
+
+	oldHash, oldVersion, oldRotatedAt := c.KeyHash, c.Version, c.RotatedAt
+
+	now := time.Now()
+
+	c.KeyHash = hashKey(key)
+	c.Version++
+	c.RotatedAt = &now
+
+	err = s.save()
+	if err != nil {
+		c.KeyHash, c.Version, c.RotatedAt = oldHash, oldVersion, oldRotatedAt
+		return nil, "", err
+	}
+
+	return c.public(), key, nil
+}
+
+// Delete removes the credential with the provided id
+func (s *Store) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.credentials[id]
+	if !ok {
+		return ErrNotFound
+	}
+
+	delete(s.credentials, id)
+
+	err := s.save()
+	if err != nil {
+		s.credentials[id] = c
+	}
+
+	return err
+}
+
+// Get returns the credential with the provided id
+func (s *Store) Get(id string) (*Credential, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.credentials[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	return c.public(), nil
+}
+
+// List returns all credentials sorted by creation time, without their key hashes
+func (s *Store) List() []*Credential {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	credentials := make([]*Credential, 0, len(s.credentials))
+
+	for _, c := range s.credentials {
+		credentials = append(credentials, c.public())
+	}
+
+	sort.Slice(credentials, func(i, j int) bool {
+		return credentials[i].CreatedAt.Before(credentials[j].CreatedAt)
+	})
+
+	return credentials
+}
+
+// Authenticate returns the identity of the credential that the provided API key belongs to
+func (s *Store) Authenticate(key string) (*Identity, bool) {
+	hash := hashKey(key)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, c := range s.credentials {
+		if subtle.ConstantTimeCompare([]byte(c.KeyHash), []byte(hash)) == 1 {
+			return &Identity{ID: c.ID, Scopes: c.Scopes}, true
+		}
+	}
+
+	return nil, false
+}
+
+// IsCurrent reports whether the credential with the provided id exists and is at the provided version,
+// which the tokens issued for it need to stay valid
+func (s *Store) IsCurrent(id string, version int) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	c, ok := s.credentials[id]
+
+	return ok && c.Version == version
+}
+
+// save writes the credentials to the store's file. The caller must hold the write lock
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	credentials := make([]*Credential, 0, len(s.credentials))
+
+	for _, c := range s.credentials {
+		credentials = append(credentials, c)
+	}
+
+	data, err := json.MarshalIndent(credentials, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	// writing to a temporary file first so that a failed write never corrupts the store
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+"*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.Write(data)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+
+	err = tmp.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// public returns a copy of the credential without the key hash
+func (c *Credential) public() *Credential {
+	public := *c
+	public.KeyHash = ""
+
+	return &public
+}
+
+// newKey generates a new random API key
+func newKey() (string, error) {
+	key, err := randomHex(32)
+	if err != nil {
+		return "", err
+	}
+
+	return keyPrefix + key, nil
+}
+
+// hashKey returns the hex encoded sha256 hash of the provided key
+func hashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// randomHex returns n random bytes encoded as hex
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
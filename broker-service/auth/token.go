@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidToken is returned when a token is malformed or its signature does not match
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrExpiredToken is returned when a token is past its expiration time
+	ErrExpiredToken = errors.New("token has expired")
+	// ErrRevokedToken is returned when the API key a token was issued for has been rotated or deleted since
+	ErrRevokedToken = errors.New("token has been revoked")
+)
+
+// tokenHeader is the JWT header of every token, since only HS256 is supported
+var tokenHeader = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"HS256","typ":"JWT"}`))
+
+// Claims are the claims carried by the tokens that the broker signs. The tokens of API keys
+// also carry the id and the version of their key, so they can be checked against the key store
+type Claims struct {
+	Subject    string   `json:"sub"`
+	Scopes     []string `json:"scopes"`
+	IssuedAt   int64    `json:"iat"`
+	ExpiresAt  int64    `json:"exp"`
+	KeyID      string   `json:"kid,omitempty"`
+	KeyVersion int      `json:"kver,omitempty"`
+}
+
+// Signer issues and verifies HMAC-SHA256 signed JSON Web Tokens
+type Signer struct {
+	secret []byte
+}
+
+// NewSigner returns a Signer that signs tokens with the provided secret
+func NewSigner(secret string) *Signer {
+	return &Signer{secret: []byte(secret)}
+}
+
+// Issue returns a token for the provided subject and scopes that is valid for ttl
+func (s *Signer) Issue(subject string, scopes []string, ttl time.Duration) (string, error) {
+	return s.issue(Claims{Subject: subject, Scopes: scopes}, ttl)
+}
+
+// IssueForKey returns a token carrying the scopes of the API key credential that is valid for ttl,
+// as long as the credential isn't rotated or deleted before
+func (s *Signer) IssueForKey(credential *Credential, ttl time.Duration) (string, error) {
+	return s.issue(Claims{
+		Subject:    credential.ID,
+		Scopes:     credential.Scopes,
+		KeyID:      credential.ID,
+		KeyVersion: credential.Version,
+	}, ttl)
+}
+
+// issue returns a token for the provided claims that is valid for ttl from now
+func (s *Signer) issue(claims Claims, ttl time.Duration) (string, error) {
+	now := time.Now()
+
+	claims.IssuedAt = now.Unix()
+	claims.ExpiresAt = now.Add(ttl).Unix()
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := tokenHeader + "." + base64.RawURLEncoding.EncodeToString(payload)
+
+	return unsigned + "." + s.sign(unsigned), nil
+}
+
+// Verify checks the signature and the expiration of the provided token and returns its claims.
+// Whether the key of the token is still current is left to the key store
+func (s *Signer) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 || parts[0] != tokenHeader {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	expected, _ := base64.RawURLEncoding.DecodeString(s.sign(parts[0] + "." + parts[1]))
+
+	if !hmac.Equal(signature, expected) {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	claims := new(Claims)
+
+	err = json.Unmarshal(payload, claims)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrExpiredToken
+	}
+
+	return claims, nil
+}
+
+// Identity returns the identity of the token's subject
+func (c *Claims) Identity() *Identity {
+	return &Identity{ID: c.Subject, Scopes: c.Scopes}
+}
+
+// IsToken reports whether the provided credential looks like a token rather than an API key
+func IsToken(credential string) bool {
+	return strings.Count(credential, ".") == 2
+}
+
+// sign returns the base64url encoded HMAC-SHA256 signature of the provided string
+func (s *Signer) sign(unsigned string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(unsigned))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
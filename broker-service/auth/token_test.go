@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestTokensOfKeysFollowTheKeyStore(t *testing.T) {
+	tests := []struct {
+		name    string
+		change  func(s *Store, id string) error
+		current bool
+	}{
+		{name: "unchanged", change: func(*Store, string) error { return nil }, current: true},
+		{name: "rotated", change: func(s *Store, id string) error { _, _, err := s.Rotate(id); return err }, current: false},
+		{name: "deleted", change: func(s *Store, id string) error { return s.Delete(id) }, current: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := NewStore("")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			credential, _, err := store.Create("client", []string{"search"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			signer := NewSigner("secret")
+
+			token, err := signer.IssueForKey(credential, time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err = tt.change(store, credential.ID); err != nil {
+				t.Fatal(err)
+			}
+
+			claims, err := signer.Verify(token)
+			if err != nil {
+				t.Fatalf("could not verify token: %v", err)
+			}
+
+			if claims.KeyID != credential.ID {
+				t.Fatalf("got key id %q, want %q", claims.KeyID, credential.ID)
+			}
+
+			if current := store.IsCurrent(claims.KeyID, claims.KeyVersion); current != tt.current {
+				t.Errorf("got current %t, want %t", current, tt.current)
+			}
+		})
+	}
+}
+
+func TestVerify(t *testing.T) {
+	signer := NewSigner("secret")
+
+	valid, _ := signer.Issue("user", []string{"search"}, time.Hour)
+	expired, _ := signer.Issue("user", []string{"search"}, -time.Second)
+	foreign, _ := NewSigner("other secret").Issue("user", []string{"search"}, time.Hour)
+
+	tests := []struct {
+		name  string
+		token string
+		err   error
+	}{
+		{name: "valid", token: valid},
+		{name: "expired", token: expired, err: ErrExpiredToken},
+		{name: "signed with another secret", token: foreign, err: ErrInvalidToken},
+		{name: "malformed", token: "a.b.c", err: ErrInvalidToken},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := signer.Verify(tt.token)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("got error %v, want %v", err, tt.err)
+			}
+
+			if err == nil && (claims.Subject != "user" || claims.KeyID != "") {
+				t.Errorf("got claims %+v, want the ones of an account token of user", claims)
+			}
+		})
+	}
+}
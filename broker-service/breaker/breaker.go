@@ -0,0 +1,256 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrOpen is returned by Allow when the breaker is open and calls to the service are rejected
+var ErrOpen = errors.New("circuit breaker is open")
+
+// State is the state of a circuit breaker
+type State int
+
+const (
+	// Closed lets all calls through and counts consecutive failures
+	Closed State = iota
+	// Open rejects all calls until the open timeout passes and a probe succeeds
+	Open
+	// HalfOpen lets a single trial call through to decide whether to close or open again
+	HalfOpen
+)
+
+// Config holds the settings of the breakers
+type Config struct {
+	// FailureThreshold is the number of consecutive failures that opens the breaker
+	FailureThreshold int
+	// OpenTimeout is how long the breaker stays open before probing the service again
+	OpenTimeout time.Duration
+}
+
+// ProbeFunc checks whether a service is up again, e.g. by calling its /ping endpoint
+type ProbeFunc func(ctx context.Context) error
+
+// Status is a snapshot of the state of a breaker
+type Status struct {
+	Service   string     `json:"service"`
+	State     string     `json:"state"`
+	Failures  int        `json:"failures"`
+	OpenedAt  *time.Time `json:"opened_at,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+// Breaker is the circuit breaker of one downstream service
+type Breaker struct {
+	mu        sync.Mutex
+	service   string
+	config    Config
+	probe     ProbeFunc
+	state     State
+	failures  int
+	openedAt  time.Time
+	lastError string
+	// busy is set while a probe or the half open trial call is in flight,
+	// so that only one call at a time tests a recovering service
+	busy bool
+	// generation counts the trial calls, so that only the result of the current one decides
+	// whether a half open breaker closes, and not the late result of a call let through before
+	generation uint64
+}
+
+// Call is a call that a breaker let through. Its result gets recorded with exactly one of its methods
+type Call struct {
+	breaker *Breaker
+	// trial is the generation of the breaker's trial call, or 0 for the calls let through while closed
+	trial uint64
+}
+
+// Set holds one breaker per service, created the first time the service is called
+type Set struct {
+	mu       sync.Mutex
+	config   Config
+	newProbe func(service string) ProbeFunc
+	breakers map[string]*Breaker
+}
+
+// NewSet returns a Set whose breakers use the provided config and probes
+func NewSet(config Config, newProbe func(service string) ProbeFunc) *Set {
+	return &Set{
+		config:   config,
+		newProbe: newProbe,
+		breakers: make(map[string]*Breaker),
+	}
+}
+
+// Get returns the breaker of the provided service
+func (s *Set) Get(service string) *Breaker {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	b, ok := s.breakers[service]
+	if !ok {
+		b = &Breaker{
+			service: service,
+			config:  s.config,
+			probe:   s.newProbe(service),
+		}
+		s.breakers[service] = b
+	}
+
+	return b
+}
+
+// Statuses returns the status of every breaker sorted by service
+func (s *Set) Statuses() []Status {
+	s.mu.Lock()
+	breakers := make([]*Breaker, 0, len(s.breakers))
+	for _, b := range s.breakers {
+		breakers = append(breakers, b)
+	}
+	s.mu.Unlock()
+
+	statuses := make([]Status, len(breakers))
+
+	for i, b := range breakers {
+		statuses[i] = b.Status()
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Service < statuses[j].Service
+	})
+
+	return statuses
+}
+
+// Allow reports whether a call to the service may be made, returning the Call to record its result with.
+// When the breaker has been open for longer than the open timeout, the service gets probed and if it responds,
+// the breaker goes half open and the call is let through as the trial call
+func (b *Breaker) Allow(ctx context.Context) (*Call, error) {
+	b.mu.Lock()
+
+	switch b.state {
+	case Closed:
+		b.mu.Unlock()
+		return &Call{breaker: b}, nil
+	case HalfOpen:
+		b.mu.Unlock()
+		return nil, ErrOpen
+	}
+
+	if b.busy || time.Since(b.openedAt) < b.config.OpenTimeout {
+		b.mu.Unlock()
+		return nil, ErrOpen
+	}
+
+	b.busy = true
+	b.mu.Unlock()
+
+	err := b.probe(ctx)
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err != nil {
+		b.busy = false
+		b.openedAt = time.Now()
+		b.lastError = "probe failed: " + err.Error()
+		return nil, ErrOpen
+	}
+
+	// busy stays set until the trial call reports back
+	b.state = HalfOpen
+	b.generation++
+
+	return &Call{breaker: b, trial: b.generation}, nil
+}
+
+// isTrial reports whether the call is the trial call of the half open breaker. The caller must hold the lock
+func (c *Call) isTrial() bool {
+	return c.trial != 0 && c.trial == c.breaker.generation && c.breaker.state == HalfOpen
+}
+
+// Success records a successful call. The trial call closes the breaker and the calls
+// of a closed breaker reset its failures, while the late ones of an open breaker change nothing
+func (c *Call) Success() {
+	b := c.breaker
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch {
+	case c.isTrial():
+		b.state = Closed
+		b.failures = 0
+		b.busy = false
+	case b.state == Closed:
+		b.failures = 0
+	}
+}
+
+// Failure records a failed call, opening the breaker when the failure threshold is reached
+// or when the trial call of a half open breaker failed
+func (c *Call) Failure(err error) {
+	b := c.breaker
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.failures++
+	b.lastError = err.Error()
+
+	if c.isTrial() || (b.state == Closed && b.failures >= b.config.FailureThreshold) {
+		b.state = Open
+		b.openedAt = time.Now()
+		b.busy = false
+	}
+}
+
+// Cancel records a call that its caller gave up on before the service answered, which says
+// nothing about the health of the service. When it's the trial call, the breaker goes back
+// to open with its open timeout already passed, so that the next call becomes the trial call
+func (c *Call) Cancel() {
+	b := c.breaker
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if c.isTrial() {
+		b.state = Open
+		b.busy = false
+	}
+}
+
+// Status returns a snapshot of the breaker's state
+func (b *Breaker) Status() Status {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	status := Status{
+		Service:   b.service,
+		State:     b.state.String(),
+		Failures:  b.failures,
+		LastError: b.lastError,
+	}
+
+	if b.state != Closed {
+		openedAt := b.openedAt
+		status.OpenedAt = &openedAt
+	}
+
+	return status
+}
+
+// String returns the name of the state
+func (s State) String() string {
+	switch s {
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+
+	return "closed"
+}
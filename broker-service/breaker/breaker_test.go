@@ -0,0 +1,250 @@
+package breaker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// probe is a fake probe of a service that answers with err and counts its calls
+type probe struct {
+	err   error
+	calls int
+}
+
+func (p *probe) check(context.Context) error {
+	p.calls++
+	return p.err
+}
+
+// newTestBreaker returns the breaker of a service checked by the returned probe
+func newTestBreaker(threshold int, openTimeout time.Duration) (*Breaker, *probe) {
+	p := new(probe)
+
+	set := NewSet(Config{FailureThreshold: threshold, OpenTimeout: openTimeout}, func(string) ProbeFunc { return p.check })
+
+	return set.Get("http://service"), p
+}
+
+var errCall = errors.New("service failed")
+
+// allow returns the call that the breaker lets through, failing the test if it doesn't
+func allow(t *testing.T, b *Breaker) *Call {
+	t.Helper()
+
+	call, err := b.Allow(context.Background())
+	if err != nil {
+		t.Fatalf("got Allow error %v in state %s, want the call let through", err, b.state)
+	}
+
+	return call
+}
+
+func TestBreakerOpensAtTheThreshold(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold int
+		record    func(t *testing.T, b *Breaker)
+		state     State
+	}{
+		{
+			name:      "failures below the threshold",
+			threshold: 3,
+			record: func(t *testing.T, b *Breaker) {
+				allow(t, b).Failure(errCall)
+				allow(t, b).Failure(errCall)
+			},
+			state:     Closed,
+		},
+		{
+			name:      "failures at the threshold",
+			threshold: 3,
+			record: func(t *testing.T, b *Breaker) {
+				allow(t, b).Failure(errCall)
+				allow(t, b).Failure(errCall)
+				allow(t, b).Failure(errCall)
+			},
+			state:     Open,
+		},
+		{
+			name:      "a success resets the failures",
+			threshold: 3,
+			record: func(t *testing.T, b *Breaker) {
+				allow(t, b).Failure(errCall)
+				allow(t, b).Failure(errCall)
+				allow(t, b).Success()
+				allow(t, b).Failure(errCall)
+			},
+			state:     Closed,
+		},
+		{
+			name:      "cancelled calls are no failures",
+			threshold: 2,
+			record: func(t *testing.T, b *Breaker) {
+				allow(t, b).Failure(errCall)
+				allow(t, b).Cancel()
+				allow(t, b).Cancel()
+			},
+			state:     Closed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, _ := newTestBreaker(tt.threshold, time.Hour)
+
+			tt.record(t, b)
+
+			if b.state != tt.state {
+				t.Fatalf("got state %s, want %s", b.state, tt.state)
+			}
+
+			_, err := b.Allow(context.Background())
+			if open := errors.Is(err, ErrOpen); open != (tt.state == Open) {
+				t.Errorf("got Allow error %v in state %s", err, tt.state)
+			}
+		})
+	}
+}
+
+func TestOpenBreakerRejectsUntilItsTimeout(t *testing.T) {
+	b, p := newTestBreaker(1, time.Hour)
+
+	allow(t, b).Failure(errCall)
+
+	if _, err := b.Allow(context.Background()); !errors.Is(err, ErrOpen) {
+		t.Fatalf("got Allow error %v, want %v", err, ErrOpen)
+	}
+
+	if p.calls != 0 {
+		t.Errorf("the service got probed %d times before the open timeout, want none", p.calls)
+	}
+
+	if status := b.Status(); status.State != "open" || status.OpenedAt == nil || status.LastError != errCall.Error() {
+		t.Errorf("got status %+v, want open with the error of the last call", status)
+	}
+}
+
+func TestHalfOpenTransitions(t *testing.T) {
+	tests := []struct {
+		name     string
+		probeErr error
+		trial    func(call *Call)
+		state    State
+	}{
+		{name: "failed probe keeps it open", probeErr: errCall, state: Open},
+		{name: "successful trial closes it", trial: func(call *Call) { call.Success() }, state: Closed},
+		{name: "failed trial opens it again", trial: func(call *Call) { call.Failure(errCall) }, state: Open},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, p := newTestBreaker(1, 0)
+			p.err = tt.probeErr
+
+			allow(t, b).Failure(errCall)
+
+			call, err := b.Allow(context.Background())
+			if p.calls != 1 {
+				t.Fatalf("the service got probed %d times after the open timeout, want once", p.calls)
+			}
+
+			if tt.probeErr != nil {
+				if !errors.Is(err, ErrOpen) || b.state != Open {
+					t.Fatalf("got Allow error %v and state %s after a failed probe, want it still open", err, b.state)
+				}
+
+				return
+			}
+
+			if err != nil || b.state != HalfOpen {
+				t.Fatalf("got Allow error %v and state %s after a successful probe, want the trial call let through half open", err, b.state)
+			}
+
+			// only the trial call tests the recovering service
+			if _, err = b.Allow(context.Background()); !errors.Is(err, ErrOpen) {
+				t.Errorf("got Allow error %v while half open, want %v", err, ErrOpen)
+			}
+
+			tt.trial(call)
+
+			if b.state != tt.state {
+				t.Errorf("got state %s after the trial call, want %s", b.state, tt.state)
+			}
+		})
+	}
+}
+
+func TestCancelledTrialCallMakesTheNextOneTheTrial(t *testing.T) {
+	openTimeout := 20 * time.Millisecond
+
+	b, p := newTestBreaker(1, openTimeout)
+
+	allow(t, b).Failure(errCall)
+
+	time.Sleep(2 * openTimeout)
+
+	trial, err := b.Allow(context.Background())
+	if err != nil || b.state != HalfOpen {
+		t.Fatalf("got Allow error %v and state %s, want the trial call let through", err, b.state)
+	}
+
+	trial.Cancel()
+
+	if b.state != Open {
+		t.Fatalf("got state %s after the trial call got cancelled, want open", b.state)
+	}
+
+	// the open timeout already passed, so the next call gets probed for right away
+	if _, err := b.Allow(context.Background()); err != nil || b.state != HalfOpen {
+		t.Errorf("got Allow error %v and state %s right after the cancel, want the next trial call let through", err, b.state)
+	}
+
+	if p.calls != 2 {
+		t.Errorf("the service got probed %d times, want twice", p.calls)
+	}
+}
+
+func TestLateResultsDontDecideTheTrial(t *testing.T) {
+	tests := []struct {
+		name   string
+		result func(call *Call)
+	}{
+		{name: "success", result: func(call *Call) { call.Success() }},
+		{name: "failure", result: func(call *Call) { call.Failure(errCall) }},
+		{name: "cancel", result: func(call *Call) { call.Cancel() }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b, p := newTestBreaker(1, 0)
+
+			// a call let through while closed is still in flight when another one opens the breaker
+			late := allow(t, b)
+			allow(t, b).Failure(errCall)
+
+			trial := allow(t, b)
+			if b.state != HalfOpen {
+				t.Fatalf("got state %s, want the trial call let through half open", b.state)
+			}
+
+			tt.result(late)
+
+			if b.state != HalfOpen {
+				t.Fatalf("got state %s after the late result, want it still half open", b.state)
+			}
+
+			// the trial is still in flight, so no second call tests the service
+			if _, err := b.Allow(context.Background()); !errors.Is(err, ErrOpen) || p.calls != 1 {
+				t.Fatalf("got Allow error %v and %d probes after the late result, want %v without another probe", err, p.calls, ErrOpen)
+			}
+
+			trial.Success()
+
+			if b.state != Closed {
+				t.Errorf("got state %s after the trial call, want closed", b.state)
+			}
+		})
+	}
+}
@@ -0,0 +1,169 @@
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// Entry is a cached response
+type Entry struct {
+	Status int
+	Body   []byte
+	// ETag is the strong entity tag of the body, quoted as it's sent in the ETag header
+	ETag    string
+	Expires time.Time
+	// Tags are the names the entry can be purged by, e.g. the keyword it was searched for
+	Tags []string
+}
+
+// Stats describes the contents and the use of the cache
+type Stats struct {
+	Entries   int   `json:"entries"`
+	Bytes     int64 `json:"bytes"`
+	MaxBytes  int64 `json:"max_bytes"`
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+}
+
+// item is an entry stored in the recency list
+type item struct {
+	key   string
+	entry *Entry
+}
+
+// Cache is a least recently used cache of responses, bounded by the total size of their bodies.
+// Entries expire after the TTL they were stored with, and the least recently used ones
+// are evicted when a new entry doesn't fit
+type Cache struct {
+	mu       sync.Mutex
+	maxBytes int64
+	bytes    int64
+	// recency holds the items from the most to the least recently used
+	recency *list.List
+	items   map[string]*list.Element
+	stats   Stats
+	now     func() time.Time
+}
+
+// New returns an empty cache that holds up to maxBytes of response bodies
+func New(maxBytes int64) *Cache {
+	return &Cache{
+		maxBytes: maxBytes,
+		recency:  list.New(),
+		items:    make(map[string]*list.Element),
+		now:      time.Now,
+	}
+}
+
+// ETag returns the strong entity tag of a body
+func ETag(body []byte) string {
+	sum := sha256.Sum256(body)
+
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// Get returns the entry stored for the key, if there is one that hasn't expired
+func (c *Cache) Get(key string) (*Entry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.items[key]
+	if ok && c.now().After(element.Value.(*item).entry.Expires) {
+		c.remove(element)
+		ok = false
+	}
+
+	if !ok {
+		c.stats.Misses++
+		return nil, false
+	}
+
+	c.stats.Hits++
+	c.recency.MoveToFront(element)
+
+	return element.Value.(*item).entry, true
+}
+
+// Set stores a response for the key for ttl, with the tags it can be purged by, and returns the stored entry.
+// Bodies larger than the whole cache are not stored
+func (c *Cache) Set(key string, status int, body []byte, ttl time.Duration, tags ...string) *Entry {
+	entry := &Entry{
+		Status:  status,
+		Body:    body,
+		ETag:    ETag(body),
+		Expires: c.now().Add(ttl),
+		Tags:    tags,
+	}
+
+	size := int64(len(body))
+	if size > c.maxBytes {
+		return entry
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.items[key]; ok {
+		c.remove(element)
+	}
+
+	for c.bytes+size > c.maxBytes {
+		c.remove(c.recency.Back())
+		c.stats.Evictions++
+	}
+
+	c.items[key] = c.recency.PushFront(&item{key: key, entry: entry})
+	c.bytes += size
+
+	return entry
+}
+
+// Purge removes the entries that have the provided tag and returns how many were removed
+func (c *Cache) Purge(tag string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	removed := 0
+
+	for element := c.recency.Front(); element != nil; {
+		next := element.Next()
+
+		for _, t := range element.Value.(*item).entry.Tags {
+			if t == tag {
+				c.remove(element)
+				removed++
+				break
+			}
+		}
+
+		element = next
+	}
+
+	return removed
+}
+
+// Stats returns the current stats of the cache
+func (c *Cache) Stats() Stats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := c.stats
+	stats.Entries = len(c.items)
+	stats.Bytes = c.bytes
+	stats.MaxBytes = c.maxBytes
+
+	return stats
+}
+
+// remove removes an element from the list and the map, c.mu must be held
+func (c *Cache) remove(element *list.Element) {
+	it := element.Value.(*item)
+
+	c.recency.Remove(element)
+	delete(c.items, it.key)
+	c.bytes -= int64(len(it.entry.Body))
+}
@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestCache returns a cache of maxBytes whose clock is the returned time, moved by the tests
+func newTestCache(maxBytes int64) (*Cache, *time.Time) {
+	now := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	c := New(maxBytes)
+	c.now = func() time.Time { return now }
+
+	return c, &now
+}
+
+func TestGetExpiresEntries(t *testing.T) {
+	tests := []struct {
+		name  string
+		after time.Duration
+		found bool
+	}{
+		{name: "before the ttl", after: time.Minute - time.Second, found: true},
+		{name: "at the ttl", after: time.Minute, found: true},
+		{name: "after the ttl", after: time.Minute + time.Second, found: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, now := newTestCache(1 << 10)
+
+			c.Set("key", 200, []byte("body"), time.Minute)
+
+			*now = now.Add(tt.after)
+
+			_, found := c.Get("key")
+			if found != tt.found {
+				t.Fatalf("got found %t, want %t", found, tt.found)
+			}
+
+			if stats := c.Stats(); !tt.found && (stats.Entries != 0 || stats.Bytes != 0) {
+				t.Errorf("got stats %+v, want the expired entry removed", stats)
+			}
+		})
+	}
+}
+
+func TestETagFollowsTheBody(t *testing.T) {
+	c, now := newTestCache(1 << 10)
+
+	first := c.Set("key", 200, []byte(`{"data":1}`), time.Minute)
+
+	if first.ETag != ETag([]byte(`{"data":1}`)) || first.ETag[0] != '"' {
+		t.Fatalf("got etag %s, want the quoted etag of the body", first.ETag)
+	}
+
+	// the entry expires and gets stored again with the same body, which keeps its etag
+	*now = now.Add(2 * time.Minute)
+
+	if _, ok := c.Get("key"); ok {
+		t.Fatal("got the entry after its ttl, want it expired")
+	}
+
+	again := c.Set("key", 200, []byte(`{"data":1}`), time.Minute)
+	if again.ETag != first.ETag {
+		t.Errorf("got etag %s for the same body, want %s", again.ETag, first.ETag)
+	}
+
+	changed := c.Set("key", 200, []byte(`{"data":2}`), time.Minute)
+	if changed.ETag == first.ETag {
+		t.Error("got the same etag for a changed body")
+	}
+
+	if entry, ok := c.Get("key"); !ok || entry.ETag != changed.ETag {
+		t.Errorf("got entry %+v, want the one of the changed body", entry)
+	}
+}
+
+func TestSetEvictsTheLeastRecentlyUsed(t *testing.T) {
+	c, _ := newTestCache(8)
+
+	c.Set("a", 200, []byte("1234"), time.Minute)
+	c.Set("b", 200, []byte("1234"), time.Minute)
+
+	// reading a makes b the least recently used
+	c.Get("a")
+
+	c.Set("c", 200, []byte("1234"), time.Minute)
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("got b kept, want it evicted")
+	}
+
+	for _, key := range []string{"a", "c"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("got %s evicted, want it kept", key)
+		}
+	}
+
+	if stats := c.Stats(); stats.Evictions != 1 || stats.Bytes != 8 {
+		t.Errorf("got stats %+v, want 1 eviction and 8 bytes", stats)
+	}
+}
+
+func TestPurgeRemovesTaggedEntries(t *testing.T) {
+	c, _ := newTestCache(1 << 10)
+
+	c.Set("search asthma", 200, []byte("1"), time.Minute, "asthma")
+	c.Set("search-stream asthma", 200, []byte("2"), time.Minute, "asthma")
+	c.Set("search flu", 200, []byte("3"), time.Minute, "flu")
+
+	if purged := c.Purge("asthma"); purged != 2 {
+		t.Fatalf("purged %d entries, want 2", purged)
+	}
+
+	if _, ok := c.Get("search flu"); !ok {
+		t.Error("got the entry of another tag purged")
+	}
+}
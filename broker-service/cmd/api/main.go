@@ -1,21 +1,85 @@
 package main
 
 import (
-	"log"
+	"broker-service/auth"
+	"broker-service/server"
+	"common/logging"
+	"common/tracing"
+	"context"
+	"flag"
+	"log/slog"
 	"net/http"
+	"os"
+	"time"
 )
 
-const webPort = ":80"
+const (
+	serviceName = "broker-service"
+	webPort     = ":80"
+)
 
 func main() {
+	var err error
+
+	slog.SetDefault(logging.New(serviceName))
+
+	// Service flags
+	routesPath := flag.String("routes", "", "Path to the JSON routing config (built-in routes are used when empty)")
+	breakerThreshold := flag.Int("breakerThreshold", 5, "Consecutive failures of a service that pause calls to it")
+	breakerTimeout := flag.Duration("breakerTimeout", 30*time.Second, "How long calls to a failing service stay paused before it gets probed")
+	limitsPath := flag.String("limits", "", "Path to the JSON rate limit config (built-in limits are used when empty)")
+	keyStorePath := flag.String("keyStore", envOr("BROKER_KEY_STORE", "keys.json"), "Path to the file that stores the API keys")
+	tokenSecret := flag.String("tokenSecret", os.Getenv("BROKER_TOKEN_SECRET"), "Secret that signs client tokens (token auth is disabled when empty)")
+	traceFile := flag.String("traceFile", os.Getenv("TRACE_FILE"), "File that spans get written to when no OTLP endpoint is set (tracing is disabled when neither is given)")
+	cacheSize := flag.Int64("cacheSize", 64<<20, "Maximum bytes of responses kept in the response cache (0 disables the cache)")
+	adminKey := flag.String("adminKey", os.Getenv("BROKER_ADMIN_KEY"), "Key that can always access the admin endpoints")
+	accountScopes := flag.String("accountScopes", os.Getenv("BROKER_ACCOUNT_SCOPES"), "Comma separated scopes of the tokens issued to accounts (built-in scopes are used when empty)")
+
+	flag.Parse()
+
+	shutdownTracing, err := tracing.Setup(context.Background(), serviceName, *traceFile)
+	if err != nil {
+		logging.Fatal("Could not set up tracing", err)
+	}
+
+	handler, err := server.New(server.Config{
+		RoutesPath:       *routesPath,
+		BreakerThreshold: *breakerThreshold,
+		BreakerTimeout:   *breakerTimeout,
+		LimitsPath:       *limitsPath,
+		KeyStorePath:     *keyStorePath,
+		TokenSecret:      *tokenSecret,
+		AdminKey:         *adminKey,
+		AccountScopes:    auth.ParseScopes(*accountScopes),
+		CacheSize:        *cacheSize,
+	})
+	if err != nil {
+		logging.Fatal("Could not set up the broker", err)
+	}
+
+	// The services might still be starting up, so not being able to reach them is not fatal
+	go server.CheckServices()
+
 	srv := &http.Server{
 		Addr:    webPort,
-		Handler: routes(),
+		Handler: handler,
 	}
 
-	log.Println("Starting Broker on port", webPort)
+	slog.Info("Starting Broker", "port", webPort)
+
+	err = srv.ListenAndServe()
+
+	// logging.Fatal exits without running deferred calls, so the spans left get flushed first
+	shutdownTracing(context.Background())
 
-	err := srv.ListenAndServe()
+	logging.Fatal("Broker stopped", err)
+}
+
+// envOr returns the value of the provided environment variable or fallback if it is not set
+func envOr(key, fallback string) string {
+	if value, ok := os.LookupEnv(key); ok {
+		return value
+	}
 
-	log.Fatal(err)
+	return fallback
 }
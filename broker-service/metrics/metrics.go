@@ -0,0 +1,110 @@
+package metrics
+
+import (
+	"broker-service/cache"
+	"common/httpmetrics"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// namespace prefixes the names of the metrics of the broker
+const namespace = "broker"
+
+var (
+	requests = httpmetrics.New(namespace)
+
+	actions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "actions_total",
+		Help:      "Payloads dispatched, by action and the status code the broker answered with.",
+	}, []string{"action", "status"})
+
+	actionDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "action_duration_seconds",
+		Help:      "Latency of the payloads dispatched, by action.",
+		Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"action"})
+
+	serviceCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "service_calls_total",
+		Help:      "Calls to the downstream services, retries included, by action, service and mapped status code.",
+	}, []string{"action", "service", "status"})
+
+	serviceCallDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "service_call_duration_seconds",
+		Help:      "Latency of the calls to the downstream services, by action and service.",
+		Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"action", "service"})
+
+	breakerRejections = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "breaker_rejections_total",
+		Help:      "Calls not made because the circuit breaker of the service was open, by service.",
+	}, []string{"service"})
+
+	cacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_lookups_total",
+		Help:      "Lookups in the response cache, by action and result (hit or miss).",
+	}, []string{"action", "result"})
+)
+
+// Middleware is the middleware that counts every request of the service and observes its latency
+func Middleware(next http.Handler) http.Handler {
+	return requests.Middleware(next)
+}
+
+// ObserveAction records a dispatched payload of the action, answered with status after d
+func ObserveAction(action string, status int, d time.Duration) {
+	actions.WithLabelValues(action, strconv.Itoa(status)).Inc()
+	actionDuration.WithLabelValues(action).Observe(d.Seconds())
+}
+
+// ObserveServiceCall records a call of the action to the service, mapped to status and answered after d
+func ObserveServiceCall(action, service string, status int, d time.Duration) {
+	serviceCalls.WithLabelValues(action, service, strconv.Itoa(status)).Inc()
+	serviceCallDuration.WithLabelValues(action, service).Observe(d.Seconds())
+}
+
+// BreakerRejected records a call to the service that its open circuit breaker didn't let through
+func BreakerRejected(service string) {
+	breakerRejections.WithLabelValues(service).Inc()
+}
+
+// CacheLookup records a lookup of a response of the action in the response cache
+func CacheLookup(action string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+
+	cacheLookups.WithLabelValues(action, result).Inc()
+}
+
+// WatchCache exposes the size and the evictions of the response cache
+func WatchCache(c *cache.Cache) {
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cache_entries",
+		Help:      "Responses held in the response cache.",
+	}, func() float64 { return float64(c.Stats().Entries) })
+
+	promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "cache_bytes",
+		Help:      "Bytes of the response bodies held in the response cache.",
+	}, func() float64 { return float64(c.Stats().Bytes) })
+
+	promauto.NewCounterFunc(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_evictions_total",
+		Help:      "Responses evicted from the response cache to make room for new ones.",
+	}, func() float64 { return float64(c.Stats().Evictions) })
+}
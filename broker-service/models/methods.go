@@ -0,0 +1,61 @@
+package models
+
+import "fmt"
+
+// Field returns the part of the payload that gets forwarded to a service,
+// according to the provided payload field name (the json name of the field)
+func (p *RequestPayload) Field(name string) (any, error) {
+	switch name {
+	case "search":
+		return &p.Search, nil
+	case "log":
+		return &HistoryRecord{Entry: p.Entry}, nil
+	case "history":
+		return &HistoryRequest{HistoryQuery: p.History}, nil
+	case "nlp":
+		return &p.NLP, nil
+	case "pdf-job":
+		return &JobRequest{Kind: "get-pdf", Search: p.Search}, nil
+	case "search-job":
+		return &JobRequest{Kind: "search", Search: p.Search}, nil
+	case "job":
+		return &JobRequest{ID: p.Job.ID}, nil
+	case "page":
+		return &p.History, nil
+	case "account":
+		return &p.Account, nil
+	case "library":
+		return &LibraryRequest{ID: p.Library.ID, List: p.Library.List, Article: p.Library.Article}, nil
+	case "saved-search":
+		return &LibraryRequest{Search: &p.Search}, nil
+	case "alerts":
+		return &AlertRequest{AlertQuery: p.Alerts}, nil
+	}
+
+	return nil, fmt.Errorf("unknown payload field %q", name)
+}
+
+// SetUserID sets the user whose history the record goes to
+func (h *HistoryRecord) SetUserID(id string) {
+	h.UserID = id
+}
+
+// SetUserID sets the user whose history gets listed or cleared
+func (h *HistoryRequest) SetUserID(id string) {
+	h.UserID = id
+}
+
+// SetUserID sets the user who submits the job or whose job gets polled
+func (j *JobRequest) SetUserID(id string) {
+	j.UserID = id
+}
+
+// SetUserID sets the user whose saved searches or reading lists get acted on
+func (l *LibraryRequest) SetUserID(id string) {
+	l.UserID = id
+}
+
+// SetUserID sets the user whose alerts get listed or marked as read
+func (a *AlertRequest) SetUserID(id string) {
+	a.UserID = id
+}
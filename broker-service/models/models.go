@@ -2,32 +2,133 @@ package models
 
 import "time"
 
-// RequestPayload is the only type of payload that the broker recieves from the frontend / POST request
+// RequestPayload is the only type of payload that the broker recieves from the frontend / POST request.
+// The validate tags are the constraints that the broker checks (and documents) before dispatching a payload
 type RequestPayload struct {
-	Action string      `json:"action"`
-	Search SearchQuery `json:"search,omitempty"`
-	Entry  SearchEntry `json:"log,omitempty"`
-	NLP    NLPRequest  `json:"nlp,omitempty"`
+	Action  string       `json:"action" validate:"required"`
+	Search  SearchQuery  `json:"search,omitempty"`
+	Entry   SearchEntry  `json:"log,omitempty"`
+	NLP     NLPRequest   `json:"nlp,omitempty"`
+	History HistoryQuery `json:"history,omitempty"`
+	Job     JobQuery     `json:"job,omitempty"`
+	Library LibraryQuery `json:"library,omitempty"`
+	Account AccountQuery `json:"account,omitempty"`
+	Alerts  AlertQuery   `json:"alerts,omitempty"`
 }
 
 // SearchQuery is the type of payload that provides the search info when a search is requested
+// CallbackURL, if given, gets a signed webhook notification once the result is ready and,
+// for searches, whenever the search-service refreshes the entries of the keyword
 type SearchQuery struct {
-	Keyword       string   `json:"keyword"`
-	SitesToSearch []string `json:"sites_to_search,omitempty"`
+	Keyword       string   `json:"keyword" validate:"code=EMPTY_KEYWORD"`
+	SitesToSearch []string `json:"sites_to_search,omitempty" validate:"enum=pubmed|nhs|wiki,code=INVALID_SITE"`
+	CallbackURL   string   `json:"callback_url,omitempty" validate:"format=uri"`
 }
 
 // SearchEntry is the type of payload that is received from the search-service (when a search was previously requested)
 // and gets returned to the requester
 type SearchEntry struct {
 	ID        string           `bson:"_id,omitempty" json:"id,omitempty"`
-	Keyword   string           `bson:"name" json:"name"`
+	Keyword   string           `bson:"keyword" json:"keyword"`
 	Origin    string           `bson:"origin" json:"origin"`
 	Data      []map[string]any `bson:"data" json:"data"`
 	CreatedAt time.Time        `bson:"created_at" json:"created_at"`
 	UpdatedAt time.Time        `bson:"updated_at" json:"updated_at"`
 }
 
+// NLPRequest is the type of payload that provides the text to process and the process to run on it.
+// Fields is only used by pipelines, to choose which fields of the found articles get processed
 type NLPRequest struct {
-	Process string `json:"process"`
-	Text    string `json:"text"`
+	Process string   `json:"process" validate:"enum=simplify|translate,code=INVALID_PROCESS"`
+	Text    string   `json:"text"`
+	Fields  []string `json:"fields,omitempty" validate:"enum=title|summary|abstract|text|extract"`
+}
+
+// HistoryQuery is the type of payload that chooses the page of the client's search history to list
+type HistoryQuery struct {
+	Page    int `json:"page,omitempty" validate:"min=1"`
+	PerPage int `json:"per_page,omitempty" validate:"min=1,max=100"`
+}
+
+// UserScoped is implemented by the parts of the payload that act on the data of the client that sends them.
+// The broker sets their user id from the client's identity, so that no client can act for another
+type UserScoped interface {
+	SetUserID(id string)
+}
+
+// HistoryRecord is sent to the search-service to record a search (an entry without data)
+// or the articles opened from it (the entry's data) in the history of a user
+type HistoryRecord struct {
+	UserID string      `json:"user_id"`
+	Entry  SearchEntry `json:"entry"`
+}
+
+// HistoryRequest is sent to the search-service to list or clear the history of a user
+type HistoryRequest struct {
+	UserID string `json:"user_id"`
+	HistoryQuery
+}
+
+// JobQuery is the type of payload that chooses the job to get the status of
+type JobQuery struct {
+	ID string `json:"id"`
+}
+
+// JobRequest is sent to the search-service to submit a job of a user (with its kind and search)
+// or to get the status of one (with its id)
+type JobRequest struct {
+	UserID string      `json:"user_id"`
+	ID     string      `json:"id,omitempty"`
+	Kind   string      `json:"kind,omitempty"`
+	Search SearchQuery `json:"search,omitempty"`
+}
+
+// AccountQuery is the type of payload that registers an account or logs into one.
+// It's only accepted by /accounts/register and /accounts/login, which answer with a token of the account
+type AccountQuery struct {
+	Email    string `json:"email" validate:"format=email,code=INVALID_EMAIL"`
+	Password string `json:"password" validate:"min=8,max=72,code=INVALID_PASSWORD"`
+	Name     string `json:"name,omitempty"`
+}
+
+// LibraryQuery is the type of payload that chooses the saved search to delete (ID)
+// or the reading list (List, the default one when empty) and the article to bookmark, remove or list
+type LibraryQuery struct {
+	ID      string      `json:"id,omitempty"`
+	List    string      `json:"list,omitempty"`
+	Article *ArticleRef `json:"article,omitempty"`
+}
+
+// ArticleRef identifies an article by its origin and its id there:
+// the PMID for pubmed, the url for nhs and the title for wiki
+type ArticleRef struct {
+	ID     string `json:"id" validate:"required"`
+	Origin string `json:"origin" validate:"required,enum=pubmed|nhs|wiki,code=INVALID_SITE"`
+	Title  string `json:"title,omitempty"`
+	Link   string `json:"link,omitempty"`
+}
+
+// LibraryRequest is sent to the search-service to act on the saved searches or the reading lists of a user
+type LibraryRequest struct {
+	UserID  string       `json:"user_id"`
+	ID      string       `json:"id,omitempty"`
+	List    string       `json:"list,omitempty"`
+	Article *ArticleRef  `json:"article,omitempty"`
+	Search  *SearchQuery `json:"search,omitempty"`
+}
+
+// AlertQuery is the type of payload that chooses the page of the client's alerts to list (only the unread ones
+// if Unread is set) or the alerts to mark as read (all of them if no ids are given). Alerts are the
+// articles that the refreshes of the search-service found for the client's saved searches
+type AlertQuery struct {
+	Unread  bool     `json:"unread,omitempty"`
+	IDs     []string `json:"ids,omitempty"`
+	Page    int      `json:"page,omitempty" validate:"min=1"`
+	PerPage int      `json:"per_page,omitempty" validate:"min=1,max=100"`
+}
+
+// AlertRequest is sent to the search-service to list or mark the alerts of a user
+type AlertRequest struct {
+	UserID string `json:"user_id"`
+	AlertQuery
 }
@@ -0,0 +1,284 @@
+package openapi
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schema is an OpenAPI 3 schema object, limited to the keywords the broker uses
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	Enum                 []any              `json:"enum,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MinItems             *int               `json:"minItems,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	OneOf                []*Schema          `json:"oneOf,omitempty"`
+	Discriminator        *Discriminator     `json:"discriminator,omitempty"`
+	ErrorCode            string             `json:"x-error-code,omitempty"`
+}
+
+// Discriminator tells which schema of a oneOf applies according to the value of a property
+type Discriminator struct {
+	PropertyName string            `json:"propertyName"`
+	Mapping      map[string]string `json:"mapping,omitempty"`
+}
+
+// Generator generates schemas from Go types, using their json tags for the property names
+// and their validate tags for the constraints. The validate tag is a comma separated list of:
+//   - required: the property must be present and not empty
+//   - enum=a|b|c: the value (or every item of a slice) must be one of the listed values
+//   - min=n, max=n: the number, or the length of the string, must be within the bounds
+//   - format=uri: the string must be an absolute http or https url
+//   - format=email: the string must be an email address
+//   - code=CODE: the error code of the values that don't match (for a slice, of its items), see errcode
+//
+// Named struct types become components that get referenced by name
+type Generator struct {
+	Components map[string]*Schema
+}
+
+var (
+	timeType       = reflect.TypeOf(time.Time{})
+	rawMessageType = reflect.TypeOf(json.RawMessage{})
+)
+
+// NewGenerator returns a Generator without any components
+func NewGenerator() *Generator {
+	return &Generator{Components: make(map[string]*Schema)}
+}
+
+// Ref returns a reference to the component schema of the type of v, generating the component the first time
+func (g *Generator) Ref(v any) *Schema {
+	return g.schemaOf(reflect.TypeOf(v))
+}
+
+// Component returns the component schema with the provided name, following references
+func (g *Generator) Component(name string) *Schema {
+	return g.Components[name]
+}
+
+// Resolve returns the schema that s refers to, or s itself if it isn't a reference
+func (g *Generator) Resolve(s *Schema) *Schema {
+	for s != nil && s.Ref != "" {
+		s = g.Components[strings.TrimPrefix(s.Ref, componentPrefix)]
+	}
+
+	return s
+}
+
+// Require returns a copy of the component schema that s refers to, where the provided properties
+// are required and may not be empty. It's used for the payload parts that actions share with
+// different requirements, e.g. a search needs sites to search but a pdf retrieval doesn't
+func (g *Generator) Require(s *Schema, properties ...string) *Schema {
+	resolved := *g.Resolve(s)
+
+	resolved.Properties = make(map[string]*Schema, len(resolved.Properties))
+	for name, property := range g.Resolve(s).Properties {
+		resolved.Properties[name] = property
+	}
+
+	resolved.Required = append([]string(nil), resolved.Required...)
+
+	for _, name := range properties {
+		property, ok := resolved.Properties[name]
+		if !ok {
+			continue
+		}
+
+		resolved.Properties[name] = nonEmpty(g.Resolve(property))
+
+		if !contains(resolved.Required, name) {
+			resolved.Required = append(resolved.Required, name)
+		}
+	}
+
+	return &resolved
+}
+
+// componentPrefix is the prefix of every reference to a component schema
+const componentPrefix = "#/components/schemas/"
+
+// schemaOf returns the schema of the provided type
+func (g *Generator) schemaOf(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t == rawMessageType:
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: g.schemaOf(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: g.schemaOf(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.structSchema(t)
+		}
+
+		if _, ok := g.Components[t.Name()]; !ok {
+			// registering before generating, so that recursive types end up as references
+			g.Components[t.Name()] = &Schema{}
+			*g.Components[t.Name()] = *g.structSchema(t)
+		}
+
+		return &Schema{Ref: componentPrefix + t.Name()}
+	}
+
+	// interfaces (any) can hold any value
+	return &Schema{}
+}
+
+// structSchema returns the object schema of the provided struct type
+func (g *Generator) structSchema(t reflect.Type) *Schema {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+
+		if !field.IsExported() {
+			continue
+		}
+
+		name, options, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+
+		// embedded structs without a json name get flattened by encoding/json, so they do here too
+		if field.Anonymous && name == "" {
+			embedded := g.Resolve(g.schemaOf(field.Type))
+			for propName, property := range embedded.Properties {
+				s.Properties[propName] = property
+			}
+			s.Required = append(s.Required, embedded.Required...)
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+
+		property := g.schemaOf(field.Type)
+
+		// nil slices and maps are written as null unless they're omitted when empty
+		if kind := field.Type.Kind(); (kind == reflect.Slice || kind == reflect.Map) && !strings.Contains(options, "omitempty") {
+			property.Nullable = true
+		}
+
+		if applyTag(property, field.Tag.Get("validate")) {
+			s.Required = append(s.Required, name)
+		}
+
+		s.Properties[name] = property
+	}
+
+	return s
+}
+
+// applyTag applies the constraints of a validate tag to the schema
+// and returns whether the tag makes the property required
+func applyTag(s *Schema, tag string) bool {
+	required := false
+
+	for _, rule := range strings.Split(tag, ",") {
+		key, value, _ := strings.Cut(rule, "=")
+
+		// the constraints of a slice apply to its items
+		target := s
+		if s.Type == "array" && key != "required" {
+			target = s.Items
+		}
+
+		switch key {
+		case "required":
+			required = true
+			*s = *nonEmpty(s)
+		case "enum":
+			for _, option := range strings.Split(value, "|") {
+				target.Enum = append(target.Enum, option)
+			}
+		case "format":
+			target.Format = value
+		case "code":
+			target.ErrorCode = value
+		case "min":
+			if target.Type == "string" {
+				if n, err := strconv.Atoi(value); err == nil {
+					target.MinLength = &n
+				}
+			} else if n, err := strconv.ParseFloat(value, 64); err == nil {
+				target.Minimum = &n
+			}
+		case "max":
+			if target.Type == "string" {
+				if n, err := strconv.Atoi(value); err == nil {
+					target.MaxLength = &n
+				}
+			} else if n, err := strconv.ParseFloat(value, 64); err == nil {
+				target.Maximum = &n
+			}
+		}
+	}
+
+	return required
+}
+
+// nonEmpty returns a copy of the schema that does not accept empty strings or arrays
+func nonEmpty(s *Schema) *Schema {
+	one := 1
+	copied := *s
+
+	// a stricter minimum of the schema is kept
+	copied.Nullable = false
+	switch copied.Type {
+	case "string":
+		if copied.MinLength == nil {
+			copied.MinLength = &one
+		}
+	case "array":
+		if copied.MinItems == nil {
+			copied.MinItems = &one
+		}
+	}
+
+	return &copied
+}
+
+// contains reports whether the slice has the provided value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,210 @@
+package openapi
+
+import (
+	"common/errcode"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"sort"
+)
+
+// FieldError describes why the value of a field does not match its schema
+type FieldError = errcode.FieldError
+
+// Validate checks a value decoded from JSON (maps, slices, strings, float64s, bools and nils)
+// against the provided schema and returns an error for every field that doesn't match it.
+// Field names are dotted paths from the root, e.g. search.sites_to_search[1]
+func (g *Generator) Validate(s *Schema, value any) []FieldError {
+	var errs []FieldError
+
+	g.validate(s, value, "", &errs)
+
+	return errs
+}
+
+func (g *Generator) validate(s *Schema, value any, path string, errs *[]FieldError) {
+	s = g.Resolve(s)
+	if s == nil {
+		return
+	}
+
+	fail := func(format string, args ...any) {
+		*errs = append(*errs, FieldError{Field: path, Code: errcode.Code(s.ErrorCode), Message: fmt.Sprintf(format, args...)})
+	}
+
+	if value == nil {
+		if s.Type != "" && !s.Nullable {
+			fail("must not be null")
+		}
+		return
+	}
+
+	if len(s.OneOf) > 0 {
+		g.validateOneOf(s, value, path, errs)
+		return
+	}
+
+	switch s.Type {
+	case "object":
+		object, ok := value.(map[string]any)
+		if !ok {
+			fail("must be an object")
+			return
+		}
+
+		for _, name := range s.Required {
+			if _, ok := object[name]; !ok {
+				var code errcode.Code
+				if property := g.Resolve(s.Properties[name]); property != nil {
+					code = errcode.Code(property.ErrorCode)
+				}
+
+				*errs = append(*errs, FieldError{Field: join(path, name), Code: code, Message: "is required"})
+			}
+		}
+
+		// sorting so that the errors always come in the same order
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if property, ok := s.Properties[name]; ok {
+				g.validate(property, object[name], join(path, name), errs)
+			} else if s.AdditionalProperties != nil {
+				g.validate(s.AdditionalProperties, object[name], join(path, name), errs)
+			}
+		}
+
+	case "array":
+		array, ok := value.([]any)
+		if !ok {
+			fail("must be an array")
+			return
+		}
+
+		if s.MinItems != nil && len(array) < *s.MinItems {
+			fail("must have at least %d item(s)", *s.MinItems)
+		}
+
+		for i, item := range array {
+			g.validate(s.Items, item, fmt.Sprintf("%s[%d]", path, i), errs)
+		}
+
+	case "string":
+		str, ok := value.(string)
+		if !ok {
+			fail("must be a string")
+			return
+		}
+
+		if s.MinLength != nil && len(str) < *s.MinLength {
+			if *s.MinLength == 1 {
+				fail("must not be empty")
+			} else {
+				fail("must be at least %d characters long", *s.MinLength)
+			}
+		}
+
+		if s.MaxLength != nil && len(str) > *s.MaxLength {
+			fail("must be at most %d characters long", *s.MaxLength)
+		}
+
+		if s.Format == "uri" && str != "" && !isURL(str) {
+			fail("must be an absolute http or https url")
+		}
+
+		if s.Format == "email" && str != "" && !isEmail(str) {
+			fail("must be an email address")
+		}
+
+	case "integer", "number":
+		n, ok := value.(float64)
+		if !ok {
+			fail("must be a number")
+			return
+		}
+
+		if s.Type == "integer" && n != float64(int64(n)) {
+			fail("must be an integer")
+		}
+
+		if s.Minimum != nil && n < *s.Minimum {
+			fail("must be at least %v", *s.Minimum)
+		}
+
+		if s.Maximum != nil && n > *s.Maximum {
+			fail("must be at most %v", *s.Maximum)
+		}
+
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			fail("must be a boolean")
+		}
+	}
+
+	if len(s.Enum) > 0 && !inEnum(s.Enum, value) {
+		fail("must be one of %v", s.Enum)
+	}
+}
+
+// validateOneOf validates the value against the schema that its discriminator property points to
+func (g *Generator) validateOneOf(s *Schema, value any, path string, errs *[]FieldError) {
+	if s.Discriminator == nil {
+		return
+	}
+
+	object, ok := value.(map[string]any)
+	if !ok {
+		*errs = append(*errs, FieldError{Field: path, Message: "must be an object"})
+		return
+	}
+
+	property := s.Discriminator.PropertyName
+
+	key, _ := object[property].(string)
+
+	ref, ok := s.Discriminator.Mapping[key]
+	if !ok {
+		*errs = append(*errs, FieldError{Field: join(path, property), Code: errcode.Code(s.ErrorCode), Message: fmt.Sprintf("unknown value %q", key)})
+		return
+	}
+
+	g.validate(&Schema{Ref: ref}, value, path, errs)
+}
+
+// inEnum reports whether the value is one of the enum values
+func inEnum(enum []any, value any) bool {
+	for _, option := range enum {
+		if option == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isURL reports whether the string is an absolute http or https url
+func isURL(str string) bool {
+	u, err := url.Parse(str)
+
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// isEmail reports whether the string is a bare email address
+func isEmail(str string) bool {
+	address, err := mail.ParseAddress(str)
+
+	return err == nil && address.Address == str
+}
+
+// join appends a property name to a dotted path
+func join(path, name string) string {
+	if path == "" {
+		return name
+	}
+
+	return path + "." + name
+}
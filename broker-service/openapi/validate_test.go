@@ -0,0 +1,113 @@
+package openapi
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+type testItem struct {
+	Name  string   `json:"name" validate:"required,max=5"`
+	Kind  string   `json:"kind,omitempty" validate:"enum=a|b,code=INVALID_KIND"`
+	Count int      `json:"count,omitempty" validate:"min=1,max=10"`
+	Link  string   `json:"link,omitempty" validate:"format=uri"`
+	Tags  []string `json:"tags"`
+	Notes []string `json:"notes,omitempty" validate:"enum=x|y"`
+}
+
+type testEnvelope struct {
+	Items []testItem `json:"items" validate:"required"`
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		fields []string
+		codes  []string
+	}{
+		{name: "valid", value: `{"items":[{"name":"abc","kind":"a","count":3,"link":"https://example.com","tags":null,"notes":["x"]}]}`},
+		{name: "missing required", value: `{}`, fields: []string{"items"}},
+		{name: "empty required array", value: `{"items":[]}`, fields: []string{"items"}},
+		{name: "null required array", value: `{"items":null}`, fields: []string{"items"}},
+		{name: "wrong type", value: `{"items":{}}`, fields: []string{"items"}},
+		{
+			name:   "item constraints",
+			value:  `{"items":[{"name":"","kind":"c","count":1.5,"link":"example.com","notes":["z"]}]}`,
+			fields: []string{"items[0].count", "items[0].kind", "items[0].link", "items[0].name", "items[0].notes[0]"},
+			codes:  []string{"", "INVALID_KIND", "", "", ""},
+		},
+		{
+			name:   "bounds",
+			value:  `{"items":[{"name":"abcdef","count":11},{"name":"a","count":0}]}`,
+			fields: []string{"items[0].count", "items[0].name", "items[1].count"},
+		},
+		{name: "missing item property", value: `{"items":[{"kind":"a"}]}`, fields: []string{"items[0].name"}},
+	}
+
+	g := NewGenerator()
+	schema := g.Ref(testEnvelope{})
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var value any
+			if err := json.Unmarshal([]byte(tt.value), &value); err != nil {
+				t.Fatal(err)
+			}
+
+			errs := g.Validate(schema, value)
+			if len(errs) != len(tt.fields) {
+				t.Fatalf("got errors %+v, want errors for %v", errs, tt.fields)
+			}
+
+			for i, err := range errs {
+				if err.Field != tt.fields[i] {
+					t.Errorf("got error %+v, want one for %s", err, tt.fields[i])
+				}
+
+				if tt.codes != nil && string(err.Code) != tt.codes[i] {
+					t.Errorf("got code %q for %s, want %q", err.Code, err.Field, tt.codes[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRequireCopiesTheComponent(t *testing.T) {
+	g := NewGenerator()
+
+	ref := g.Ref(testItem{})
+	required := g.Require(ref, "kind", "tags")
+
+	if errs := g.Validate(required, map[string]any{"name": "abc", "kind": "a"}); len(errs) != 1 || errs[0].Field != "tags" {
+		t.Fatalf("got errors %+v, want tags required", errs)
+	}
+
+	if errs := g.Validate(required, map[string]any{"name": "abc", "kind": "a", "tags": nil}); len(errs) != 1 || errs[0].Field != "tags" {
+		t.Errorf("got errors %+v, want a required tags not accepting null", errs)
+	}
+
+	// the component itself keeps its requirements
+	if errs := g.Validate(ref, map[string]any{"name": "abc", "tags": nil}); len(errs) != 0 {
+		t.Errorf("got errors %+v for the component, want its requirements unchanged", errs)
+	}
+}
+
+func TestValidateOneOfFollowsTheDiscriminator(t *testing.T) {
+	g := NewGenerator()
+	g.Components["Item"] = g.Resolve(g.Ref(testItem{}))
+
+	schema := &Schema{
+		OneOf:         []*Schema{{Ref: componentPrefix + "Item"}},
+		Discriminator: &Discriminator{PropertyName: "kind", Mapping: map[string]string{"a": componentPrefix + "Item"}},
+		ErrorCode:     "UNKNOWN_KIND",
+	}
+
+	if errs := g.Validate(schema, map[string]any{"kind": "a"}); len(errs) != 1 || errs[0].Field != "name" {
+		t.Errorf("got errors %+v, want the schema of the mapping applied", errs)
+	}
+
+	errs := g.Validate(schema, map[string]any{"kind": "b", "name": "abc"})
+	if len(errs) != 1 || errs[0].Field != "kind" || errs[0].Code != "UNKNOWN_KIND" {
+		t.Errorf("got errors %+v, want an unknown kind", errs)
+	}
+}
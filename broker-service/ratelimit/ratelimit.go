@@ -0,0 +1,266 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often the limiter drops the buckets of clients that have gone idle
+const sweepInterval = 10 * time.Minute
+
+// Limit is a token bucket limit: Rate requests per second on average with bursts of up to Burst requests
+type Limit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// Config holds the limits of the broker. Actions without their own limit use the Default one
+// and only the actions found in DailyQuotas have a daily quota
+type Config struct {
+	Default     Limit            `json:"default"`
+	Actions     map[string]Limit `json:"actions,omitempty"`
+	DailyQuotas map[string]int   `json:"daily_quotas,omitempty"`
+}
+
+// Decision is the result of asking the limiter whether a request may go through
+type Decision struct {
+	Allowed    bool
+	RetryAfter time.Duration
+	Reason     string
+}
+
+// key identifies the limits of one client for one action
+type key struct {
+	client string
+	action string
+}
+
+type bucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+type quota struct {
+	used int
+	day  time.Time
+}
+
+// Limiter applies token bucket rate limits and daily quotas per client and action
+type Limiter struct {
+	mu        sync.Mutex
+	config    Config
+	buckets   map[key]*bucket
+	quotas    map[key]*quota
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// DefaultConfig returns the limits used when no config file is given.
+// Actions that make the services scrape or run models are kept the tightest,
+// and registering has a daily quota per address so that clients can't mint new identities at will
+func DefaultConfig() Config {
+	return Config{
+		Default: Limit{Rate: 2, Burst: 10},
+		Actions: map[string]Limit{
+			"search":             {Rate: 1, Burst: 5},
+			"search-stream":      {Rate: 1, Burst: 5},
+			"get-pdf":            {Rate: 0.2, Burst: 2},
+			"get-pdf-job":        {Rate: 0.2, Burst: 2},
+			"search-job":         {Rate: 1, Burst: 5},
+			"register":           {Rate: 0.05, Burst: 3},
+			"login":              {Rate: 0.2, Burst: 5},
+			"process-text":       {Rate: 0.5, Burst: 3},
+			"search-and-process": {Rate: 0.1, Burst: 2},
+		},
+		DailyQuotas: map[string]int{
+			"get-pdf":            100,
+			"get-pdf-job":        100,
+			"process-text":       200,
+			"search-and-process": 50,
+			"register":           20,
+		},
+	}
+}
+
+// Load reads the limiter config from the JSON file in the provided path,
+// or returns the DefaultConfig if the path is empty
+func Load(path string) (Config, error) {
+	if path == "" {
+		return DefaultConfig(), nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return Config{}, err
+	}
+	defer f.Close()
+
+	config := Config{}
+
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+
+	err = dec.Decode(&config)
+	if err != nil {
+		return Config{}, fmt.Errorf("could not decode rate limit config %s with error: %s", path, err.Error())
+	}
+
+	limits := map[string]Limit{"default": config.Default}
+	for action, limit := range config.Actions {
+		limits[action] = limit
+	}
+
+	for name, limit := range limits {
+		if limit.Rate <= 0 || limit.Burst < 1 {
+			return Config{}, fmt.Errorf("rate limit for %s needs a positive rate and a burst of at least 1", name)
+		}
+	}
+
+	return config, nil
+}
+
+// New returns a Limiter that applies the provided config
+func New(config Config) *Limiter {
+	return &Limiter{
+		config:    config,
+		buckets:   make(map[key]*bucket),
+		quotas:    make(map[key]*quota),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// Allow reports whether the client may perform the action now. If it may, the request
+// is counted against the client's limits, otherwise the decision says how long to wait
+func (l *Limiter) Allow(client, action string) Decision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	k := key{client: client, action: action}
+
+	l.sweep(now)
+
+	q, denied := l.quotaFor(k, now, 1)
+	if denied != nil {
+		return *denied
+	}
+
+	limit := l.limitFor(action)
+
+	b := l.buckets[k]
+	if b == nil {
+		b = &bucket{tokens: float64(limit.Burst), lastSeen: now}
+		l.buckets[k] = b
+	}
+
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.lastSeen).Seconds()*limit.Rate)
+	b.lastSeen = now
+
+	if b.tokens < 1 {
+		wait := (1 - b.tokens) / limit.Rate
+
+		return Decision{
+			RetryAfter: time.Duration(wait * float64(time.Second)),
+			Reason:     fmt.Sprintf("rate limit for %s exceeded", action),
+		}
+	}
+
+	b.tokens--
+
+	if q != nil {
+		q.used++
+	}
+
+	return Decision{Allowed: true}
+}
+
+// Reserve counts n requests of the action against the client's daily quota at once, for the requests
+// that the broker makes on behalf of a client, like the NLP calls of search-and-process. They don't take
+// tokens of the bucket, which paces the client's own requests. None of them are counted when they don't
+// all fit in what is left of the quota, and actions without a daily quota always have room
+func (l *Limiter) Reserve(client, action string, n int) Decision {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+
+	l.sweep(now)
+
+	q, denied := l.quotaFor(key{client: client, action: action}, now, n)
+	if denied != nil {
+		return *denied
+	}
+
+	if q != nil {
+		q.used += n
+	}
+
+	return Decision{Allowed: true}
+}
+
+// quotaFor returns the quota of today for the key, or nil if its action has no daily quota,
+// along with the decision to deny n more requests when they don't fit in it. The caller must hold the lock
+func (l *Limiter) quotaFor(k key, now time.Time, n int) (*quota, *Decision) {
+	maxPerDay, hasQuota := l.config.DailyQuotas[k.action]
+	if !hasQuota {
+		return nil, nil
+	}
+
+	q := l.quotas[k]
+
+	today := now.UTC().Truncate(24 * time.Hour)
+
+	if q == nil || !q.day.Equal(today) {
+		q = &quota{day: today}
+		l.quotas[k] = q
+	}
+
+	if q.used+n > maxPerDay {
+		return nil, &Decision{
+			RetryAfter: today.Add(24 * time.Hour).Sub(now),
+			Reason:     fmt.Sprintf("daily quota of %d requests for %s exceeded", maxPerDay, k.action),
+		}
+	}
+
+	return q, nil
+}
+
+// limitFor returns the limit for the provided action
+func (l *Limiter) limitFor(action string) Limit {
+	if limit, ok := l.config.Actions[action]; ok {
+		return limit
+	}
+
+	return l.config.Default
+}
+
+// sweep drops the buckets that have refilled completely and the quotas of past days,
+// since they hold no more info than a new one would. The caller must hold the lock
+func (l *Limiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < sweepInterval {
+		return
+	}
+
+	l.lastSweep = now
+
+	for k, b := range l.buckets {
+		limit := l.limitFor(k.action)
+
+		if b.tokens+now.Sub(b.lastSeen).Seconds()*limit.Rate >= float64(limit.Burst) {
+			delete(l.buckets, k)
+		}
+	}
+
+	today := now.UTC().Truncate(24 * time.Hour)
+
+	for k, q := range l.quotas {
+		if q.day.Before(today) {
+			delete(l.quotas, k)
+		}
+	}
+}
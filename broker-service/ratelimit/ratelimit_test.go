@@ -0,0 +1,248 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// clock is a fake clock for the limiter, moved forward by the steps of the tests
+type clock struct {
+	now time.Time
+}
+
+func (c *clock) Now() time.Time {
+	return c.now
+}
+
+// newTestLimiter returns a limiter with the provided config that runs on a fake clock
+func newTestLimiter(config Config, start time.Time) (*Limiter, *clock) {
+	c := &clock{now: start}
+
+	l := New(config)
+	l.now = c.Now
+	l.lastSweep = start
+
+	return l, c
+}
+
+// step is a request of a client after advancing the clock by wait
+type step struct {
+	wait    time.Duration
+	client  string
+	allowed bool
+}
+
+func TestAllow(t *testing.T) {
+	// a time in the middle of a day, so that the day only changes when a test waits for it
+	noon := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		config Config
+		start  time.Time
+		steps  []step
+	}{
+		{
+			name:   "burst then limited",
+			config: Config{Default: Limit{Rate: 1, Burst: 3}},
+			start:  noon,
+			steps: []step{
+				{client: "a", allowed: true},
+				{client: "a", allowed: true},
+				{client: "a", allowed: true},
+				{client: "a", allowed: false},
+			},
+		},
+		{
+			name:   "refill at the rate",
+			config: Config{Default: Limit{Rate: 2, Burst: 1}},
+			start:  noon,
+			steps: []step{
+				{client: "a", allowed: true},
+				{client: "a", allowed: false},
+				{wait: 250 * time.Millisecond, client: "a", allowed: false},
+				{wait: 250 * time.Millisecond, client: "a", allowed: true},
+				{client: "a", allowed: false},
+			},
+		},
+		{
+			name:   "refill never exceeds the burst",
+			config: Config{Default: Limit{Rate: 1, Burst: 2}},
+			start:  noon,
+			steps: []step{
+				{client: "a", allowed: true},
+				{wait: time.Hour, client: "a", allowed: true},
+				{client: "a", allowed: true},
+				{client: "a", allowed: false},
+			},
+		},
+		{
+			name:   "clients have buckets of their own",
+			config: Config{Default: Limit{Rate: 1, Burst: 1}},
+			start:  noon,
+			steps: []step{
+				{client: "a", allowed: true},
+				{client: "a", allowed: false},
+				{client: "b", allowed: true},
+			},
+		},
+		{
+			name: "daily quota",
+			config: Config{
+				Default:     Limit{Rate: 100, Burst: 100},
+				DailyQuotas: map[string]int{"search": 2},
+			},
+			start: noon,
+			steps: []step{
+				{client: "a", allowed: true},
+				{client: "a", allowed: true},
+				{client: "a", allowed: false},
+				{wait: 6 * time.Hour, client: "a", allowed: false},
+				{client: "b", allowed: true},
+			},
+		},
+		{
+			name: "daily quota resets at midnight utc",
+			config: Config{
+				Default:     Limit{Rate: 100, Burst: 100},
+				DailyQuotas: map[string]int{"search": 1},
+			},
+			start: time.Date(2024, 3, 10, 23, 59, 0, 0, time.UTC),
+			steps: []step{
+				{client: "a", allowed: true},
+				{client: "a", allowed: false},
+				{wait: 30 * time.Second, client: "a", allowed: false},
+				{wait: 30 * time.Second, client: "a", allowed: true},
+				{client: "a", allowed: false},
+			},
+		},
+		{
+			name: "requests denied by the rate limit don't use the quota",
+			config: Config{
+				Default:     Limit{Rate: 1, Burst: 1},
+				DailyQuotas: map[string]int{"search": 2},
+			},
+			start: noon,
+			steps: []step{
+				{client: "a", allowed: true},
+				{client: "a", allowed: false},
+				{client: "a", allowed: false},
+				{wait: time.Second, client: "a", allowed: true},
+				{wait: time.Second, client: "a", allowed: false},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, c := newTestLimiter(tt.config, tt.start)
+
+			for i, s := range tt.steps {
+				c.now = c.now.Add(s.wait)
+
+				decision := l.Allow(s.client, "search")
+				if decision.Allowed != s.allowed {
+					t.Fatalf("step %d: got allowed %t, want %t (%s)", i, decision.Allowed, s.allowed, decision.Reason)
+				}
+
+				if !decision.Allowed && decision.RetryAfter <= 0 {
+					t.Errorf("step %d: got retry after %s, want a positive wait", i, decision.RetryAfter)
+				}
+			}
+		})
+	}
+}
+
+func TestAllowUsesTheLimitOfTheAction(t *testing.T) {
+	l, _ := newTestLimiter(Config{
+		Default: Limit{Rate: 1, Burst: 5},
+		Actions: map[string]Limit{"get-pdf": {Rate: 1, Burst: 1}},
+	}, time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC))
+
+	if !l.Allow("a", "get-pdf").Allowed || l.Allow("a", "get-pdf").Allowed {
+		t.Error("got more than the burst of 1 of get-pdf allowed")
+	}
+
+	for i := 0; i < 5; i++ {
+		if !l.Allow("a", "search").Allowed {
+			t.Fatalf("request %d of search denied, want the default burst of 5 allowed", i)
+		}
+	}
+}
+
+func TestSweepEvictsIdleClients(t *testing.T) {
+	start := time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC)
+
+	l, c := newTestLimiter(Config{
+		Default:     Limit{Rate: 1, Burst: 10},
+		DailyQuotas: map[string]int{"search": 100},
+	}, start)
+
+	l.Allow("idle", "search")
+
+	// the sweep only runs once its interval has passed
+	c.now = start.Add(sweepInterval - time.Second)
+	for i := 0; i < 5; i++ {
+		l.Allow("busy", "search")
+	}
+
+	if len(l.buckets) != 2 {
+		t.Fatalf("got %d buckets before the sweep, want 2", len(l.buckets))
+	}
+
+	// by the sweep the bucket of the idle client has refilled, while the one of the busy client hasn't yet
+	c.now = start.Add(sweepInterval)
+	l.Allow("busy", "search")
+
+	if b := l.buckets[key{client: "busy", action: "search"}]; b == nil || b.tokens != 5 {
+		t.Errorf("got the bucket %+v of the busy client, want the one it kept using with 5 tokens left", b)
+	}
+
+	if _, ok := l.buckets[key{client: "idle", action: "search"}]; ok {
+		t.Error("got the refilled bucket of the idle client kept, want it evicted")
+	}
+
+	// quotas are only evicted once their day is over, since they'd reset the count otherwise
+	if len(l.quotas) != 2 {
+		t.Fatalf("got %d quotas on the same day, want 2", len(l.quotas))
+	}
+
+	c.now = start.Add(24 * time.Hour)
+	l.Allow("busy", "search")
+
+	if _, ok := l.quotas[key{client: "idle", action: "search"}]; ok {
+		t.Error("got the quota of the idle client of the day before kept, want it evicted")
+	}
+
+	if q := l.quotas[key{client: "busy", action: "search"}]; q == nil || q.used != 1 {
+		t.Errorf("got quota %+v of the busy client, want a new one with 1 use", q)
+	}
+}
+
+func TestReserveCountsAgainstTheDailyQuota(t *testing.T) {
+	l, _ := newTestLimiter(Config{
+		Default:     Limit{Rate: 1, Burst: 1},
+		DailyQuotas: map[string]int{"process-text": 10},
+	}, time.Date(2024, 3, 10, 12, 0, 0, 0, time.UTC))
+
+	if !l.Reserve("a", "process-text", 8).Allowed {
+		t.Fatal("got 8 of a quota of 10 denied")
+	}
+
+	// reservations take no tokens of the bucket
+	if !l.Allow("a", "process-text").Allowed {
+		t.Fatal("got the request after the reservation denied, want the burst of 1 left")
+	}
+
+	if decision := l.Reserve("a", "process-text", 2); decision.Allowed || decision.RetryAfter <= 0 {
+		t.Fatalf("got decision %+v reserving 2 of the 1 left, want it denied until the next day", decision)
+	}
+
+	if !l.Reserve("a", "process-text", 1).Allowed || l.Reserve("a", "process-text", 1).Allowed {
+		t.Error("got a denied reservation counted, want the 1 left reservable once")
+	}
+
+	if !l.Reserve("a", "search", 1000).Allowed {
+		t.Error("got a reservation of an action without a quota denied")
+	}
+}
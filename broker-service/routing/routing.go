@@ -0,0 +1,395 @@
+package routing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// envPrefix is the prefix of the environment variables that override or add routes,
+// e.g. BROKER_ROUTE_GET_PDF_URL=http://localhost:8081/get-pdf
+const envPrefix = "BROKER_ROUTE_"
+
+// DefaultTimeout is the timeout used for routes that don't specify one
+const DefaultTimeout = 30 * time.Second
+
+// DefaultDeadline is the deadline used for routes that don't specify one, unless their timeout is longer
+const DefaultDeadline = 2 * time.Minute
+
+// Route describes how the broker forwards an action to a downstream service
+type Route struct {
+	Action  string   `json:"action"`
+	URL     string   `json:"url"`
+	Method  string   `json:"method,omitempty"`
+	Timeout Duration `json:"timeout,omitempty"`
+	Payload string   `json:"payload"`
+	// Idempotent actions can safely be called again, so failed calls get retried up to Retries times
+	Idempotent bool `json:"idempotent,omitempty"`
+	Retries    int  `json:"retries,omitempty"`
+	// Deadline is how long a call of the action may take in all, its retries and the waits between them included
+	Deadline Duration `json:"deadline,omitempty"`
+	// Stream routes answer with Server-Sent Events and can only be called through /handle/stream
+	Stream bool `json:"stream,omitempty"`
+	// CacheTTL is how long the successful responses of the action are served from the broker's cache, 0 disables caching
+	CacheTTL Duration `json:"cache_ttl,omitempty"`
+}
+
+// Duration is a time.Duration that is written as a string ("10s", "1m30s") in the config file
+type Duration struct {
+	time.Duration
+}
+
+// Table holds the routes of the broker, keyed by action
+type Table struct {
+	routes map[string]*Route
+}
+
+// config is the layout of the routing config file
+type config struct {
+	Routes []*Route `json:"routes"`
+}
+
+// Default returns the routing table that the broker uses when no config is given
+func Default() *Table {
+	t := &Table{routes: make(map[string]*Route)}
+
+	t.add(&Route{Action: "search", URL: "http://search-service/search-entry", Payload: "search", Idempotent: true, Retries: 2, CacheTTL: Duration{10 * time.Minute}})
+	t.add(&Route{Action: "get-pdf", URL: "http://search-service/get-pdf", Payload: "search", Idempotent: true, Retries: 2, CacheTTL: Duration{time.Hour}})
+	t.add(&Route{Action: "search-stream", URL: "http://search-service/search-entry/stream", Payload: "search", Stream: true})
+	t.add(&Route{Action: "log", URL: "http://search-service/history/record", Payload: "log"})
+	t.add(&Route{Action: "history", URL: "http://search-service/history/list", Payload: "history", Idempotent: true, Retries: 2})
+	t.add(&Route{Action: "clear-history", URL: "http://search-service/history/clear", Payload: "history"})
+	t.add(&Route{Action: "get-pdf-job", URL: "http://search-service/jobs/submit", Payload: "pdf-job"})
+	t.add(&Route{Action: "search-job", URL: "http://search-service/jobs/submit", Payload: "search-job"})
+	t.add(&Route{Action: "job-status", URL: "http://search-service/jobs/status", Payload: "job", Idempotent: true, Retries: 2})
+	t.add(&Route{Action: "webhook-dead-letters", URL: "http://search-service/webhooks/dead-letters", Payload: "page", Idempotent: true, Retries: 2})
+	t.add(&Route{Action: "register", URL: "http://search-service/accounts/register", Payload: "account"})
+	t.add(&Route{Action: "login", URL: "http://search-service/accounts/login", Payload: "account"})
+	t.add(&Route{Action: "save-search", URL: "http://search-service/saved-searches/save", Payload: "saved-search"})
+	t.add(&Route{Action: "saved-searches", URL: "http://search-service/saved-searches/list", Payload: "library", Idempotent: true, Retries: 2})
+	t.add(&Route{Action: "delete-saved-search", URL: "http://search-service/saved-searches/delete", Payload: "library"})
+	t.add(&Route{Action: "bookmark", URL: "http://search-service/reading-lists/add", Payload: "library"})
+	t.add(&Route{Action: "reading-lists", URL: "http://search-service/reading-lists/list", Payload: "library", Idempotent: true, Retries: 2})
+	t.add(&Route{Action: "remove-bookmark", URL: "http://search-service/reading-lists/remove", Payload: "library"})
+	t.add(&Route{Action: "alerts", URL: "http://search-service/alerts/list", Payload: "alerts", Idempotent: true, Retries: 2})
+	t.add(&Route{Action: "read-alerts", URL: "http://search-service/alerts/read", Payload: "alerts"})
+	t.add(&Route{Action: "process-text", URL: "http://nlp-service/process-text", Payload: "nlp"})
+
+	return t
+}
+
+// Load builds the routing table from the config file in the provided path,
+// or from the default routes if the path is empty, and then applies
+// any overrides found in the environment variables
+func Load(path string) (*Table, error) {
+	t := Default()
+
+	if path != "" {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+
+		conf := new(config)
+
+		dec := json.NewDecoder(f)
+		dec.DisallowUnknownFields()
+
+		err = dec.Decode(conf)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode routing config %s with error: %s", path, err.Error())
+		}
+
+		t.routes = make(map[string]*Route, len(conf.Routes))
+
+		for _, route := range conf.Routes {
+			if _, ok := t.routes[route.Action]; ok {
+				return nil, fmt.Errorf("routing config %s has more than one route for action %s", path, route.Action)
+			}
+
+			t.add(route)
+		}
+	}
+
+	err := t.applyEnv(os.Environ())
+	if err != nil {
+		return nil, err
+	}
+
+	for _, route := range t.routes {
+		err = route.validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return t, nil
+}
+
+// Lookup returns the route for the provided action and whether it exists
+func (t *Table) Lookup(action string) (*Route, bool) {
+	route, ok := t.routes[action]
+
+	return route, ok
+}
+
+// Routes returns all routes of the table sorted by action
+func (t *Table) Routes() []*Route {
+	routes := make([]*Route, 0, len(t.routes))
+
+	for _, route := range t.routes {
+		routes = append(routes, route)
+	}
+
+	sort.Slice(routes, func(i, j int) bool {
+		return routes[i].Action < routes[j].Action
+	})
+
+	return routes
+}
+
+// Services returns the url of every downstream service of the table once, sorted, e.g. http://search-service
+func (t *Table) Services() []string {
+	var services []string
+
+	seen := make(map[string]bool)
+
+	for _, route := range t.routes {
+		service := route.ServiceURL()
+
+		if !seen[service] {
+			seen[service] = true
+			services = append(services, service)
+		}
+	}
+
+	sort.Strings(services)
+
+	return services
+}
+
+// CheckServices pings every downstream service of the table once
+// and returns the error for every service that is not reachable, keyed by service url
+func (t *Table) CheckServices(client *http.Client) map[string]error {
+	failed := make(map[string]error)
+
+	for _, service := range t.Services() {
+		response, err := client.Get(service + "/ping")
+		if err != nil {
+			failed[service] = err
+			continue
+		}
+		response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			failed[service] = fmt.Errorf("ping returned status code %d", response.StatusCode)
+		}
+	}
+
+	return failed
+}
+
+// ServiceURL returns the scheme and host of the route's url, e.g. http://search-service
+func (r *Route) ServiceURL() string {
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return r.URL
+	}
+
+	return u.Scheme + "://" + u.Host
+}
+
+// Budget returns the deadline of a call of the route: its own, or the DefaultDeadline
+// or its timeout, whichever is longer, when it has none
+func (r *Route) Budget() time.Duration {
+	if r.Deadline.Duration > 0 {
+		return r.Deadline.Duration
+	}
+
+	return max(DefaultDeadline, r.Timeout.Duration)
+}
+
+// add adds a route to the table filling in the defaults for the missing values
+func (t *Table) add(route *Route) {
+	if route.Method == "" {
+		route.Method = http.MethodPost
+	}
+
+	if route.Timeout.Duration == 0 {
+		route.Timeout.Duration = DefaultTimeout
+	}
+
+	t.routes[route.Action] = route
+}
+
+// applyEnv applies the BROKER_ROUTE_<ACTION>_<FIELD> variables to the table.
+// The action is written in upper snake case (GET_PDF for get-pdf) and the field
+// is one of URL, METHOD, TIMEOUT, DEADLINE, PAYLOAD, IDEMPOTENT, RETRIES, STREAM or CACHE_TTL. Setting the URL of an unknown action adds a new route
+func (t *Table) applyEnv(environ []string) error {
+	// the URLs go first so that new actions exist before their other fields are set
+	for _, fields := range [][]string{{"_URL"}, {"_METHOD", "_TIMEOUT", "_DEADLINE", "_PAYLOAD", "_IDEMPOTENT", "_RETRIES", "_STREAM", "_CACHE_TTL"}} {
+		for _, env := range environ {
+			key, value, _ := strings.Cut(env, "=")
+
+			if !strings.HasPrefix(key, envPrefix) {
+				continue
+			}
+
+			for _, field := range fields {
+				name, found := strings.CutSuffix(strings.TrimPrefix(key, envPrefix), field)
+				if !found || name == "" {
+					continue
+				}
+
+				action := strings.ReplaceAll(strings.ToLower(name), "_", "-")
+
+				err := t.setField(action, field, value)
+				if err != nil {
+					return fmt.Errorf("invalid value for %s: %s", key, err.Error())
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// setField sets one field of the route of the provided action
+func (t *Table) setField(action, field, value string) error {
+	route, ok := t.routes[action]
+	if !ok {
+		if field != "_URL" {
+			return fmt.Errorf("no route for action %s", action)
+		}
+
+		route = &Route{Action: action, Payload: action}
+		t.add(route)
+	}
+
+	switch field {
+	case "_URL":
+		route.URL = value
+	case "_METHOD":
+		route.Method = strings.ToUpper(value)
+	case "_TIMEOUT":
+		d, err := positiveDuration(value)
+		if err != nil {
+			return err
+		}
+		route.Timeout.Duration = d
+	case "_DEADLINE":
+		d, err := positiveDuration(value)
+		if err != nil {
+			return err
+		}
+		route.Deadline.Duration = d
+	case "_PAYLOAD":
+		route.Payload = value
+	case "_IDEMPOTENT":
+		idempotent, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		route.Idempotent = idempotent
+	case "_STREAM":
+		stream, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		route.Stream = stream
+	case "_RETRIES":
+		retries, err := strconv.Atoi(value)
+		if err != nil {
+			return err
+		}
+		route.Retries = retries
+	case "_CACHE_TTL":
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return err
+		}
+		route.CacheTTL.Duration = d
+	}
+
+	return nil
+}
+
+// positiveDuration parses a duration that must be longer than zero, since calls with
+// a timeout or a deadline of zero would fail before they even reach the service
+func positiveDuration(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+
+	if d <= 0 {
+		return 0, fmt.Errorf("duration must be positive, got %s", value)
+	}
+
+	return d, nil
+}
+
+// validate checks that the route has all the info needed to call its service
+func (r *Route) validate() error {
+	if r.Action == "" {
+		return errors.New("route without an action in routing config")
+	}
+
+	u, err := url.Parse(r.URL)
+	if err != nil || u.Scheme == "" || u.Host == "" {
+		return fmt.Errorf("route for action %s has an invalid url: %q", r.Action, r.URL)
+	}
+
+	if r.Timeout.Duration < 0 {
+		return fmt.Errorf("route for action %s has a negative timeout", r.Action)
+	}
+
+	if r.Deadline.Duration < 0 {
+		return fmt.Errorf("route for action %s has a negative deadline", r.Action)
+	}
+
+	if r.Deadline.Duration > 0 && r.Deadline.Duration < r.Timeout.Duration {
+		return fmt.Errorf("route for action %s has a deadline shorter than its timeout", r.Action)
+	}
+
+	if r.Retries < 0 {
+		return fmt.Errorf("route for action %s has a negative number of retries", r.Action)
+	}
+
+	if r.CacheTTL.Duration < 0 {
+		return fmt.Errorf("route for action %s has a negative cache ttl", r.Action)
+	}
+
+	if r.CacheTTL.Duration > 0 && r.Stream {
+		return fmt.Errorf("route for action %s streams its responses, which can't be cached", r.Action)
+	}
+
+	return nil
+}
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// UnmarshalJSON reads the duration from a string such as "10s"
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return errors.New("duration must be a string, e.g. \"10s\"")
+	}
+
+	d.Duration, err = time.ParseDuration(s)
+
+	return err
+}
@@ -0,0 +1,129 @@
+package routing
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig writes the routing config to a file of the test and returns its path
+func writeConfig(t *testing.T, config string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "routes.json")
+
+	if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestLoad(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{
+			name:   "valid",
+			config: `{"routes": [{"action": "search", "url": "http://search-service/search-entry", "timeout": "5s", "deadline": "1m"}]}`,
+		},
+		{
+			name: "duplicate action",
+			config: `{"routes": [
+				{"action": "search", "url": "http://search-service/search-entry"},
+				{"action": "search", "url": "http://other-service/search-entry"}
+			]}`,
+			err: "more than one route for action search",
+		},
+		{
+			name:   "negative timeout",
+			config: `{"routes": [{"action": "search", "url": "http://search-service/search-entry", "timeout": "-1s"}]}`,
+			err:    "negative timeout",
+		},
+		{
+			name:   "deadline shorter than the timeout",
+			config: `{"routes": [{"action": "search", "url": "http://search-service/search-entry", "timeout": "10s", "deadline": "5s"}]}`,
+			err:    "deadline shorter than its timeout",
+		},
+		{
+			name:   "unknown field",
+			config: `{"routes": [{"action": "search", "url": "http://search-service/search-entry", "timout": "5s"}]}`,
+			err:    "unknown field",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table, err := Load(writeConfig(t, tt.config))
+
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("got error %v, want one about %q", err, tt.err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if routes := table.Routes(); len(routes) != 1 || routes[0].Method != "POST" {
+				t.Errorf("got routes %+v, want the one of the config with the default method", routes)
+			}
+		})
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	tests := []struct {
+		name  string
+		env   string
+		err   bool
+		check func(r *Route) bool
+	}{
+		{name: "timeout", env: "BROKER_ROUTE_SEARCH_TIMEOUT=30s", check: func(r *Route) bool { return r.Timeout.Duration == 30*time.Second }},
+		{name: "deadline", env: "BROKER_ROUTE_SEARCH_DEADLINE=3m", check: func(r *Route) bool { return r.Deadline.Duration == 3*time.Minute }},
+		{name: "zero timeout", env: "BROKER_ROUTE_SEARCH_TIMEOUT=0s", err: true},
+		{name: "negative timeout", env: "BROKER_ROUTE_SEARCH_TIMEOUT=-5s", err: true},
+		{name: "zero deadline", env: "BROKER_ROUTE_SEARCH_DEADLINE=0", err: true},
+		{name: "negative deadline", env: "BROKER_ROUTE_SEARCH_DEADLINE=-1m", err: true},
+		{name: "cache ttl of zero disables caching", env: "BROKER_ROUTE_SEARCH_CACHE_TTL=0s", check: func(r *Route) bool { return r.CacheTTL.Duration == 0 }},
+		{name: "retries", env: "BROKER_ROUTE_SEARCH_RETRIES=x", err: true},
+		{name: "field of an unknown action", env: "BROKER_ROUTE_UNKNOWN_TIMEOUT=5s", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := Default()
+
+			err := table.applyEnv([]string{tt.env})
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v, want an error: %t", err, tt.err)
+			}
+
+			if tt.check != nil {
+				if route, _ := table.Lookup("search"); !tt.check(route) {
+					t.Errorf("got route %+v after %s", route, tt.env)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyEnvAddsRoutes(t *testing.T) {
+	table := Default()
+
+	err := table.applyEnv([]string{"BROKER_ROUTE_SUMMARIZE_TIMEOUT=20s", "BROKER_ROUTE_SUMMARIZE_URL=http://nlp-service/summarize"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	route, ok := table.Lookup("summarize")
+	if !ok || route.URL != "http://nlp-service/summarize" || route.Timeout.Duration != 20*time.Second || route.Payload != "summarize" {
+		t.Errorf("got route %+v, want the one of the variables, whatever their order", route)
+	}
+}
@@ -0,0 +1,132 @@
+package server
+
+import (
+	"broker-service/models"
+	"common/envelope"
+	"encoding/json"
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// accountActions are the actions that register and log into accounts. Their clients aren't
+// authenticated yet, so they are only accepted by the public /accounts endpoints
+var accountActions = map[string]bool{
+	"register": true,
+	"login":    true,
+}
+
+// DefaultAccountScopes returns the scopes of the tokens issued to accounts when none are configured.
+// Anyone can register an account, so they only cover searching and the account's own data, while the actions
+// that download PDFs or run models are kept for API keys, whose daily quotas can't be reset by registering again
+func DefaultAccountScopes() []string {
+	return []string{
+		"search", "search-stream", "search-job", "job-status",
+		"log", "history", "clear-history",
+		"save-search", "saved-searches", "delete-saved-search",
+		"bookmark", "reading-lists", "remove-bookmark",
+		"alerts", "read-alerts",
+	}
+}
+
+// accountResponse holds the token issued for an account along with the account
+type accountResponse struct {
+	Token     string          `json:"token"`
+	ExpiresAt time.Time       `json:"expires_at"`
+	Account   json.RawMessage `json:"account"`
+}
+
+// Register creates an account and answers with a token for it
+func Register(w http.ResponseWriter, r *http.Request) {
+	handleAccount(w, r, "register", http.StatusCreated)
+}
+
+// Login checks the email and password of an account and answers with a token for it
+func Login(w http.ResponseWriter, r *http.Request) {
+	handleAccount(w, r, "login", http.StatusOK)
+}
+
+// handleAccount forwards the account of the request to the route of the action and issues a token
+// for the account it answers with. The token carries the accountScopes, the account id being its user id,
+// and lasts for the accountTokenTTL
+func handleAccount(w http.ResponseWriter, r *http.Request, action string, status int) {
+	if tokenSigner == nil {
+		envelope.ErrorJSON(w, errors.New("token authentication is not enabled"), http.StatusNotImplemented)
+		return
+	}
+
+	route, ok := routeTable.Lookup(action)
+	if !ok {
+		envelope.ErrorJSON(w, errors.New("action "+action+" is not routed"), http.StatusNotImplemented)
+		return
+	}
+
+	payload := &models.RequestPayload{Action: action}
+
+	err := envelope.ReadJSON(w, r, &payload.Account)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	if errs := spec.Validate(payload); len(errs) > 0 {
+		invalidPayload(errs).write(w)
+		return
+	}
+
+	// the clients have no identity yet, so they are limited by their address
+	decision := limiter.Allow("ip:"+clientIP(r), action)
+	if !decision.Allowed {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(decision.RetryAfter.Seconds()))))
+		envelope.ErrorJSON(w, errors.New(decision.Reason), http.StatusTooManyRequests)
+		return
+	}
+
+	response := callService(r.Context(), route, &payload.Account)
+	if response.Status >= http.StatusMultipleChoices {
+		response.write(w)
+		return
+	}
+
+	var body struct {
+		Data json.RawMessage `json:"data"`
+	}
+
+	var account struct {
+		ID string `json:"id"`
+	}
+
+	if json.Unmarshal(response.Body, &body) != nil || json.Unmarshal(body.Data, &account) != nil || account.ID == "" {
+		envelope.ErrorJSON(w, errors.New("service "+route.ServiceURL()+" did not answer with an account"), http.StatusBadGateway)
+		return
+	}
+
+	token, err := tokenSigner.Issue(account.ID, accountScopes, accountTokenTTL)
+	if err != nil {
+		envelope.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	envelope.WriteJSON(w, status, envelope.Response{
+		Error:   false,
+		Message: response.message(),
+		Data: accountResponse{
+			Token:     token,
+			ExpiresAt: time.Now().Add(accountTokenTTL),
+			Account:   body.Data,
+		},
+	})
+}
+
+// clientIP returns the address of the client of the request, without its port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
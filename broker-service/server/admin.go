@@ -0,0 +1,179 @@
+package server
+
+import (
+	"broker-service/auth"
+	"broker-service/models"
+	"common/envelope"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+const (
+	defaultTokenTTL = 24 * time.Hour
+	maxTokenTTL     = 30 * 24 * time.Hour
+	// accountTokenTTL is how long the tokens of accounts last. Unlike the ones of API keys they
+	// can't be revoked through the key store, so they are kept short and clients log in again
+	accountTokenTTL = 15 * time.Minute
+)
+
+// keyRequest is the payload for creating an API key
+type keyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+// keyResponse holds a credential along with its API key, which is only shown once
+type keyResponse struct {
+	*auth.Credential
+	Key string `json:"key"`
+}
+
+// tokenRequest is the payload for issuing a signed token for an existing API key credential
+type tokenRequest struct {
+	KeyID string `json:"key_id"`
+	TTL   string `json:"ttl,omitempty"`
+}
+
+// tokenResponse holds an issued token and its expiration time
+type tokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// ListKeys writes all stored API key credentials, without their keys
+func ListKeys(w http.ResponseWriter, r *http.Request) {
+	envelope.WriteJSON(w, http.StatusOK, envelope.Response{
+		Error:   false,
+		Message: "Keys retrieved successfully!",
+		Data:    keyStore.List(),
+	})
+}
+
+// CreateKey creates a new API key with the requested name and scopes
+func CreateKey(w http.ResponseWriter, r *http.Request) {
+	request := new(keyRequest)
+
+	err := envelope.ReadJSON(w, r, request)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	if request.Name == "" || len(request.Scopes) == 0 {
+		envelope.ErrorJSON(w, errors.New("a key needs a name and at least one scope"))
+		return
+	}
+
+	credential, key, err := keyStore.Create(request.Name, request.Scopes)
+	if err != nil {
+		envelope.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	envelope.WriteJSON(w, http.StatusCreated, envelope.Response{
+		Error:   false,
+		Message: "Key created, store it safely since it can not be retrieved again",
+		Data:    keyResponse{Credential: credential, Key: key},
+	})
+}
+
+// RotateKey replaces the API key of the credential with the id in the url
+func RotateKey(w http.ResponseWriter, r *http.Request) {
+	credential, key, err := keyStore.Rotate(chi.URLParam(r, "id"))
+	if err != nil {
+		envelope.ErrorJSON(w, err, storeErrorStatus(err))
+		return
+	}
+
+	envelope.WriteJSON(w, http.StatusOK, envelope.Response{
+		Error:   false,
+		Message: "Key rotated, the previous key is no longer valid",
+		Data:    keyResponse{Credential: credential, Key: key},
+	})
+}
+
+// DeleteKey revokes the credential with the id in the url
+func DeleteKey(w http.ResponseWriter, r *http.Request) {
+	err := keyStore.Delete(chi.URLParam(r, "id"))
+	if err != nil {
+		envelope.ErrorJSON(w, err, storeErrorStatus(err))
+		return
+	}
+
+	envelope.WriteJSON(w, http.StatusOK, envelope.Response{
+		Error:   false,
+		Message: "Key deleted",
+	})
+}
+
+// IssueToken issues a signed token carrying the scopes of the requested API key credential,
+// which stays valid until it expires or the key gets rotated or deleted
+func IssueToken(w http.ResponseWriter, r *http.Request) {
+	if tokenSigner == nil {
+		envelope.ErrorJSON(w, errors.New("token authentication is not enabled"), http.StatusNotImplemented)
+		return
+	}
+
+	request := new(tokenRequest)
+
+	err := envelope.ReadJSON(w, r, request)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	ttl := defaultTokenTTL
+
+	if request.TTL != "" {
+		ttl, err = time.ParseDuration(request.TTL)
+		if err != nil || ttl <= 0 || ttl > maxTokenTTL {
+			envelope.ErrorJSON(w, errors.New("ttl must be a positive duration of at most 720h"))
+			return
+		}
+	}
+
+	credential, err := keyStore.Get(request.KeyID)
+	if err != nil {
+		envelope.ErrorJSON(w, err, storeErrorStatus(err))
+		return
+	}
+
+	token, err := tokenSigner.IssueForKey(credential, ttl)
+	if err != nil {
+		envelope.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	envelope.WriteJSON(w, http.StatusCreated, envelope.Response{
+		Error:   false,
+		Message: "Token issued",
+		Data:    tokenResponse{Token: token, ExpiresAt: time.Now().Add(ttl)},
+	})
+}
+
+// storeErrorStatus returns the status code that corresponds to an error of the key store
+func storeErrorStatus(err error) int {
+	if errors.Is(err, auth.ErrNotFound) {
+		return http.StatusNotFound
+	}
+
+	return http.StatusInternalServerError
+}
+
+// ListDeadLetters handles GET /admin/webhooks/dead-letters?page=&per_page=, writing a page of the webhook
+// notifications that the search-service could not deliver. It's the same as the webhook-dead-letters action
+func ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	payload := &models.RequestPayload{Action: "webhook-dead-letters"}
+
+	// invalid numbers are left to the validation of the payload
+	payload.History.Page, _ = strconv.Atoi(query.Get("page"))
+	payload.History.PerPage, _ = strconv.Atoi(query.Get("per_page"))
+
+	dispatch(r.Context(), payload).write(w)
+}
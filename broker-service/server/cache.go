@@ -0,0 +1,289 @@
+package server
+
+import (
+	"broker-service/cache"
+	"broker-service/metrics"
+	"broker-service/models"
+	"broker-service/routing"
+	"common/envelope"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// cachedCall calls the service of the route, serving successful responses from the response cache
+// for as long as the route's cache ttl. Responses that miss the results of some of the sites searched
+// aren't cached, so that a site failing once doesn't keep its results out of the cache for the whole ttl.
+// The service always gets the payload part as the client sent it, the normalized one only keys the cache
+func cachedCall(ctx context.Context, route *routing.Route, item any) *serviceResponse {
+	if responseCache == nil || route.CacheTTL.Duration <= 0 || !cacheable(item) {
+		return callService(ctx, route, item)
+	}
+
+	key, err := cacheKey(route, normalize(item))
+	if err != nil {
+		return callService(ctx, route, item)
+	}
+
+	order := siteOrder(item)
+
+	entry, ok := responseCache.Get(key)
+
+	metrics.CacheLookup(route.Action, ok)
+
+	if ok {
+		body, ok := reorderEntries(entry.Body, order, false)
+		if ok {
+			response := rawServiceResponse(entry.Status, body)
+			response.Header.Set("ETag", cache.ETag(body))
+			response.Header.Set("X-Cache", "HIT")
+
+			return response
+		}
+	}
+
+	response := callService(ctx, route, item)
+	response.Header.Set("X-Cache", "MISS")
+
+	if response.Status < http.StatusOK || response.Status >= http.StatusMultipleChoices || partial(response.Body) {
+		return response
+	}
+
+	// the entry keeps the entries in the order of the sorted sites, which every client's order maps to,
+	// and the client gets them back the way a hit would answer, so that its ETag stays the same
+	sorted, ok := reorderEntries(response.Body, order, true)
+	if !ok {
+		return response
+	}
+
+	body, ok := reorderEntries(sorted, order, false)
+	if !ok {
+		return response
+	}
+
+	responseCache.Set(key, response.Status, sorted, route.CacheTTL.Duration, cacheTags(item)...)
+
+	response.Body = body
+	response.Header.Set("ETag", cache.ETag(body))
+
+	return response
+}
+
+// cacheable reports whether the response to a payload part can be cached. Payloads that act on
+// a client's own data are never cached, and neither are the ones with a callback url,
+// since the callback only gets notified when the service handles the request
+func cacheable(item any) bool {
+	if _, scoped := item.(models.UserScoped); scoped {
+		return false
+	}
+
+	if query, ok := item.(*models.SearchQuery); ok && query.CallbackURL != "" {
+		return false
+	}
+
+	return true
+}
+
+// normalize returns the payload part that keys the cache in place of item. The keyword of a search is
+// trimmed and its sites sorted, so that every search of the same sites shares its key whatever order they
+// were given in. Keywords keep their case, since the services look them up as they are given
+func normalize(item any) any {
+	query, ok := item.(*models.SearchQuery)
+	if !ok {
+		return item
+	}
+
+	normalized := *query
+	normalized.Keyword = strings.TrimSpace(query.Keyword)
+	normalized.SitesToSearch = append([]string(nil), query.SitesToSearch...)
+
+	sort.Strings(normalized.SitesToSearch)
+
+	return &normalized
+}
+
+// siteOrder returns the positions of the sites of a search in their sorted order,
+// which is the order of the entries of its cached response, or nil for other payload parts
+func siteOrder(item any) []int {
+	query, ok := item.(*models.SearchQuery)
+	if !ok {
+		return nil
+	}
+
+	order := make([]int, len(query.SitesToSearch))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return query.SitesToSearch[order[i]] < query.SitesToSearch[order[j]]
+	})
+
+	return order
+}
+
+// reorderEntries returns the body of a search response with its entries moved from the order
+// of the client's sites into the order of the sorted sites, or back when toSorted is false.
+// It reports false when the entries of the body don't match the sites, so the body can't be reordered
+func reorderEntries(body []byte, order []int, toSorted bool) ([]byte, bool) {
+	if order == nil {
+		return body, true
+	}
+
+	var response struct {
+		envelope.Response
+		Data []json.RawMessage `json:"data"`
+	}
+
+	if err := json.Unmarshal(body, &response); err != nil || len(response.Data) != len(order) {
+		return nil, false
+	}
+
+	entries := make([]json.RawMessage, len(order))
+	for sorted, position := range order {
+		if toSorted {
+			entries[sorted] = response.Data[position]
+		} else {
+			entries[position] = response.Data[sorted]
+		}
+	}
+
+	reordered := response.Response
+	reordered.Data = entries
+
+	out, err := json.Marshal(reordered)
+	if err != nil {
+		return nil, false
+	}
+
+	return out, true
+}
+
+// cacheKey returns the key of a request in the response cache. The payload part is encoded
+// from its decoded model, so requests that only differ in formatting, field order or
+// the other parts of the payload share their key
+func cacheKey(route *routing.Route, item any) (string, error) {
+	body, err := json.Marshal(item)
+	if err != nil {
+		return "", err
+	}
+
+	return route.Action + " " + string(body), nil
+}
+
+// partial reports whether the body of a response is a list of entries missing some of them,
+// like the results of a search that some of the sites could not be searched for
+func partial(body []byte) bool {
+	var response struct {
+		Data []json.RawMessage `json:"data"`
+	}
+
+	// the data of other responses is no list, which fails to decode
+	if json.Unmarshal(body, &response) != nil {
+		return false
+	}
+
+	for _, entry := range response.Data {
+		if string(entry) == "null" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// cacheTags returns the tags that the cached response of a payload part can be purged by.
+// Searches are tagged by their keyword and pdfs by their PMCID, which is the keyword of get-pdf
+func cacheTags(item any) []string {
+	if query, ok := item.(*models.SearchQuery); ok {
+		return []string{cacheTag(query.Keyword)}
+	}
+
+	return nil
+}
+
+// cacheTag normalizes a keyword or PMCID into a cache tag, so that purging doesn't depend on its case
+func cacheTag(keyword string) string {
+	return strings.ToLower(strings.TrimSpace(keyword))
+}
+
+// writeFor writes the response to the request, or only 304 Not Modified
+// when the client already has the body that the response's ETag stands for
+func (s *serviceResponse) writeFor(w http.ResponseWriter, r *http.Request) {
+	etag := s.Header.Get("ETag")
+
+	if etag == "" || !matchesETag(r.Header.Get("If-None-Match"), etag) {
+		s.write(w)
+		return
+	}
+
+	for key, value := range s.Header {
+		w.Header()[key] = value
+	}
+
+	w.WriteHeader(http.StatusNotModified)
+}
+
+// matchesETag reports whether an If-None-Match header matches the etag
+func matchesETag(ifNoneMatch, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CacheStats writes the stats of the response cache
+func CacheStats(w http.ResponseWriter, r *http.Request) {
+	if responseCache == nil {
+		envelope.ErrorJSON(w, errors.New("the response cache is disabled"), http.StatusNotImplemented)
+		return
+	}
+
+	envelope.WriteJSON(w, http.StatusOK, envelope.Response{
+		Error:   false,
+		Message: "Cache stats retrieved",
+		Data:    responseCache.Stats(),
+	})
+}
+
+// PurgeCache removes the cached responses of the keyword and / or the PMCID given in the query
+func PurgeCache(w http.ResponseWriter, r *http.Request) {
+	if responseCache == nil {
+		envelope.ErrorJSON(w, errors.New("the response cache is disabled"), http.StatusNotImplemented)
+		return
+	}
+
+	query := r.URL.Query()
+
+	var tags []string
+	for _, name := range []string{"keyword", "pmcid"} {
+		if value := cacheTag(query.Get(name)); value != "" {
+			tags = append(tags, value)
+		}
+	}
+
+	if len(tags) == 0 {
+		envelope.ErrorJSON(w, errors.New("a keyword or a pmcid to purge must be given"))
+		return
+	}
+
+	purged := 0
+	for _, tag := range tags {
+		purged += responseCache.Purge(tag)
+	}
+
+	envelope.WriteJSON(w, http.StatusOK, envelope.Response{
+		Error:   false,
+		Message: fmt.Sprintf("Purged %d cached responses", purged),
+		Data:    map[string]int{"purged": purged},
+	})
+}
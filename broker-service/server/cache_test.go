@@ -0,0 +1,207 @@
+package server
+
+import (
+	"broker-service/breaker"
+	"broker-service/cache"
+	"broker-service/models"
+	"broker-service/routing"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeSearch is a search-service that answers searches with the data it's given
+// and keeps the queries it got
+type fakeSearch struct {
+	mu      sync.Mutex
+	data    string
+	queries []models.SearchQuery
+}
+
+func (f *fakeSearch) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	query := models.SearchQuery{}
+	_ = json.NewDecoder(r.Body).Decode(&query)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.queries = append(f.queries, query)
+
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write([]byte(`{"error":false,"message":"Search was successful!","data":` + f.data + `}`))
+}
+
+func (f *fakeSearch) calls() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return len(f.queries)
+}
+
+// withCache sets up the response cache and the breakers of the broker in front of a fake search-service,
+// and returns its route along with the service
+func withCache(t *testing.T, data string) (*routing.Route, *fakeSearch) {
+	t.Helper()
+
+	service := &fakeSearch{data: data}
+
+	server := httptest.NewServer(service)
+	t.Cleanup(server.Close)
+
+	oldCache, oldBreakers := responseCache, breakers
+	t.Cleanup(func() { responseCache, breakers = oldCache, oldBreakers })
+
+	responseCache = cache.New(1 << 20)
+	breakers = breaker.NewSet(breaker.Config{FailureThreshold: 5, OpenTimeout: time.Minute}, pingProbe)
+
+	route := &routing.Route{
+		Action:   "search",
+		URL:      server.URL + "/search-entry",
+		Method:   http.MethodPost,
+		Timeout:  routing.Duration{Duration: 5 * time.Second},
+		Payload:  "search",
+		CacheTTL: routing.Duration{Duration: time.Minute},
+	}
+
+	return route, service
+}
+
+// entryOrigins returns the origins of the entries of a search response, in their order
+func entryOrigins(t *testing.T, response *serviceResponse) []string {
+	t.Helper()
+
+	var body struct {
+		Data []struct {
+			Origin string `json:"origin"`
+		} `json:"data"`
+	}
+
+	if err := json.Unmarshal(response.Body, &body); err != nil {
+		t.Fatal(err)
+	}
+
+	origins := make([]string, len(body.Data))
+	for i, entry := range body.Data {
+		origins[i] = entry.Origin
+	}
+
+	return origins
+}
+
+func TestCachedCallSharesTheKeyOfEquivalentSearches(t *testing.T) {
+	route, service := withCache(t, `[{"keyword":"Asthma","origin":"pubmed"},{"keyword":"Asthma","origin":"nhs"}]`)
+
+	first := cachedCall(context.Background(), route, &models.SearchQuery{Keyword: " Asthma ", SitesToSearch: []string{"pubmed", "nhs"}})
+	if first.Header.Get("X-Cache") != "MISS" {
+		t.Fatalf("got X-Cache %q for the first search, want MISS", first.Header.Get("X-Cache"))
+	}
+
+	// the service gets the query as the client sent it
+	if got := service.queries[0]; got.Keyword != " Asthma " || got.SitesToSearch[0] != "pubmed" || got.SitesToSearch[1] != "nhs" {
+		t.Errorf("the service got query %+v, want the one of the client", got)
+	}
+
+	if origins := entryOrigins(t, first); origins[0] != "pubmed" || origins[1] != "nhs" {
+		t.Errorf("got entries of %v, want them in the order of the sites", origins)
+	}
+
+	// the cached entries are answered in the order of the sites of every search sharing the key
+	second := cachedCall(context.Background(), route, &models.SearchQuery{Keyword: "Asthma", SitesToSearch: []string{"nhs", "pubmed"}})
+	if second.Header.Get("X-Cache") != "HIT" {
+		t.Fatalf("got X-Cache %q, want a HIT", second.Header.Get("X-Cache"))
+	}
+
+	if origins := entryOrigins(t, second); origins[0] != "nhs" || origins[1] != "pubmed" {
+		t.Errorf("got entries of %v, want them in the order of the sites", origins)
+	}
+
+	third := cachedCall(context.Background(), route, &models.SearchQuery{Keyword: "Asthma", SitesToSearch: []string{"pubmed", "nhs"}})
+	if third.Header.Get("X-Cache") != "HIT" || third.Header.Get("ETag") != first.Header.Get("ETag") {
+		t.Fatalf("got X-Cache %q and ETag %q, want a HIT with ETag %q", third.Header.Get("X-Cache"), third.Header.Get("ETag"), first.Header.Get("ETag"))
+	}
+
+	if calls := service.calls(); calls != 1 {
+		t.Errorf("the service got %d calls, want 1", calls)
+	}
+
+	// the services look keywords up as they're given, so another case is another search
+	if other := cachedCall(context.Background(), route, &models.SearchQuery{Keyword: "asthma", SitesToSearch: []string{"pubmed", "nhs"}}); other.Header.Get("X-Cache") != "MISS" {
+		t.Errorf("got X-Cache %q for a keyword in another case, want MISS", other.Header.Get("X-Cache"))
+	}
+
+	// purging by the keyword in any case removes the entries of every case
+	if purged := responseCache.Purge(cacheTag("ASTHMA")); purged != 2 {
+		t.Errorf("purged %d responses, want 2", purged)
+	}
+}
+
+func TestCachedCallSkipsPartialResults(t *testing.T) {
+	route, service := withCache(t, `[{"keyword":"asthma","origin":"nhs"},null]`)
+
+	query := &models.SearchQuery{Keyword: "asthma", SitesToSearch: []string{"nhs", "pubmed"}}
+
+	for i := 0; i < 2; i++ {
+		response := cachedCall(context.Background(), route, query)
+
+		if response.Header.Get("X-Cache") != "MISS" || response.Header.Get("ETag") != "" {
+			t.Fatalf("call %d: got X-Cache %q and ETag %q, want a MISS without an ETag", i, response.Header.Get("X-Cache"), response.Header.Get("ETag"))
+		}
+	}
+
+	if calls := service.calls(); calls != 2 {
+		t.Errorf("the service got %d calls, want every search of partial results to reach it", calls)
+	}
+}
+
+func TestWriteForAnswersNotModified(t *testing.T) {
+	route, _ := withCache(t, `[{"keyword":"asthma","origin":"nhs"}]`)
+
+	query := &models.SearchQuery{Keyword: "asthma", SitesToSearch: []string{"nhs"}}
+
+	etag := cachedCall(context.Background(), route, query).Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("got no ETag for a cached response")
+	}
+
+	tests := []struct {
+		name        string
+		ifNoneMatch string
+		status      int
+	}{
+		{name: "no If-None-Match", status: http.StatusOK},
+		{name: "same etag", ifNoneMatch: etag, status: http.StatusNotModified},
+		{name: "weak etag", ifNoneMatch: "W/" + etag, status: http.StatusNotModified},
+		{name: "one of a list", ifNoneMatch: `"other", ` + etag, status: http.StatusNotModified},
+		{name: "wildcard", ifNoneMatch: "*", status: http.StatusNotModified},
+		{name: "another etag", ifNoneMatch: `"other"`, status: http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/handle", nil)
+			if tt.ifNoneMatch != "" {
+				request.Header.Set("If-None-Match", tt.ifNoneMatch)
+			}
+
+			recorder := httptest.NewRecorder()
+
+			cachedCall(context.Background(), route, query).writeFor(recorder, request)
+
+			if recorder.Code != tt.status {
+				t.Fatalf("got status %d, want %d", recorder.Code, tt.status)
+			}
+
+			if recorder.Header().Get("ETag") != etag {
+				t.Errorf("got ETag %q, want %q", recorder.Header().Get("ETag"), etag)
+			}
+
+			if tt.status == http.StatusNotModified && recorder.Body.Len() != 0 {
+				t.Errorf("got a body of %d bytes with 304, want none", recorder.Body.Len())
+			}
+		})
+	}
+}
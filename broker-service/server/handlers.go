@@ -0,0 +1,278 @@
+package server
+
+import (
+	"broker-service/auth"
+	"broker-service/metrics"
+	"broker-service/models"
+	"broker-service/ratelimit"
+	"common/envelope"
+	"common/errcode"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi"
+)
+
+const (
+	// maxBatchSize is the maximum number of payloads in a batch request
+	maxBatchSize = 20
+	// batchConcurrency is the maximum number of payloads of a batch that run at the same time
+	batchConcurrency = 4
+)
+
+// adminActions are the actions that act on the data of every client, so they need the admin scope
+var adminActions = map[string]bool{
+	"webhook-dead-letters": true,
+}
+
+// batchResult is the result of one payload of a batch request
+type batchResult struct {
+	Index    int             `json:"index"`
+	Action   string          `json:"action"`
+	Status   int             `json:"status"`
+	Response json.RawMessage `json:"response"`
+}
+
+// HandleSubmittion is the single point of entry to the broker service
+// that handles all requests based on the action specified
+func HandleSubmittion(w http.ResponseWriter, r *http.Request) {
+	requestPayload := new(models.RequestPayload)
+
+	err := envelope.ReadJSON(w, r, requestPayload)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	dispatch(r.Context(), requestPayload).writeFor(w, r)
+}
+
+// HandleBatch handles an array of payloads concurrently and writes the result of
+// every payload in the order they were sent. A failed payload doesn't fail the rest
+func HandleBatch(w http.ResponseWriter, r *http.Request) {
+	var payloads []*models.RequestPayload
+
+	err := envelope.ReadJSON(w, r, &payloads)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	if len(payloads) == 0 || len(payloads) > maxBatchSize {
+		envelope.ErrorJSON(w, fmt.Errorf("a batch needs between 1 and %d payloads", maxBatchSize))
+		return
+	}
+
+	// a null entry has no payload to dispatch, so the batch is rejected before any of it runs
+	var nulls []errcode.FieldError
+	for i, payload := range payloads {
+		if payload == nil {
+			nulls = append(nulls, errcode.FieldError{Field: strconv.Itoa(i), Message: "must be a payload, not null"})
+		}
+	}
+
+	if len(nulls) > 0 {
+		envelope.ErrorJSON(w, errcode.Invalid(nulls...))
+		return
+	}
+
+	results := make([]batchResult, len(payloads))
+
+	wg := new(sync.WaitGroup)
+	wg.Add(len(payloads))
+
+	// Every payload writes only to its own index of results,
+	// and the semaphore keeps at most batchConcurrency calls in flight
+	semaphore := make(chan struct{}, batchConcurrency)
+
+	for i, payload := range payloads {
+		go func(i int, payload *models.RequestPayload) {
+			defer wg.Done()
+
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			response := dispatch(r.Context(), payload)
+
+			results[i] = batchResult{
+				Index:    i,
+				Action:   payload.Action,
+				Status:   response.Status,
+				Response: response.Body,
+			}
+		}(i, payload)
+	}
+
+	wg.Wait()
+
+	failed := 0
+	for _, result := range results {
+		if result.Status >= http.StatusBadRequest {
+			failed++
+		}
+	}
+
+	envelope.WriteJSON(w, http.StatusOK, envelope.Response{
+		Error:   failed == len(results),
+		Message: fmt.Sprintf("Batch processed, %d of %d payloads failed", failed, len(results)),
+		Data:    results,
+	})
+}
+
+// HandleStream handles the actions whose routes stream Server-Sent Events,
+// relaying every event to the client as soon as the service sends it
+func HandleStream(w http.ResponseWriter, r *http.Request) {
+	requestPayload := new(models.RequestPayload)
+
+	err := envelope.ReadJSON(w, r, requestPayload)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	route, ok := routeTable.Lookup(requestPayload.Action)
+	if !ok || !route.Stream {
+		envelope.ErrorJSON(w, errors.New("action "+requestPayload.Action+" is not a streaming action"))
+		return
+	}
+
+	if errs := spec.Validate(requestPayload); len(errs) > 0 {
+		invalidPayload(errs).write(w)
+		return
+	}
+
+	_, denied := authorize(r.Context(), route.Action)
+	if denied != nil {
+		denied.write(w)
+		return
+	}
+
+	item, err := requestPayload.Field(route.Payload)
+	if err != nil {
+		envelope.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	streamService(r.Context(), route, item, w)
+}
+
+// JobStatus handles GET /jobs/{id}, writing the status, progress and result of a job
+// submitted with get-pdf-job or search-job. It's the same as the job-status action
+func JobStatus(w http.ResponseWriter, r *http.Request) {
+	payload := &models.RequestPayload{
+		Action: "job-status",
+		Job:    models.JobQuery{ID: chi.URLParam(r, "id")},
+	}
+
+	dispatch(r.Context(), payload).writeFor(w, r)
+}
+
+// ServiceStatus writes the state of the circuit breaker of every service called so far
+func ServiceStatus(w http.ResponseWriter, r *http.Request) {
+	envelope.WriteJSON(w, http.StatusOK, envelope.Response{
+		Error:   false,
+		Message: "Service status retrieved successfully!",
+		Data: map[string]any{
+			"breakers": breakers.Statuses(),
+		},
+	})
+}
+
+// dispatch routes a payload to the service of its action, after checking that the payload
+// matches the schema of its action and that the client of the request is allowed to perform the action and is within its rate limits.
+// Every dispatched payload is counted and timed by its action
+func dispatch(ctx context.Context, requestPayload *models.RequestPayload) *serviceResponse {
+	start := time.Now()
+
+	response := dispatchAction(ctx, requestPayload)
+
+	// actions that aren't routed come from the client, so they share a label
+	action := requestPayload.Action
+	if _, ok := routeTable.Lookup(action); !ok && pipelines[action] == nil {
+		action = "unknown"
+	}
+
+	metrics.ObserveAction(action, response.Status, time.Since(start))
+
+	return response
+}
+
+// dispatchAction performs the action of the payload, see dispatch
+func dispatchAction(ctx context.Context, requestPayload *models.RequestPayload) *serviceResponse {
+	route, isRoute := routeTable.Lookup(requestPayload.Action)
+	pipeline, isPipeline := pipelines[requestPayload.Action]
+
+	if !isRoute && !isPipeline {
+		return newCodedError(errcode.UnknownAction, "unknown action")
+	}
+
+	if accountActions[requestPayload.Action] {
+		return newServiceError(http.StatusBadRequest, "action "+requestPayload.Action+" must be sent to /accounts/"+requestPayload.Action)
+	}
+
+	if isRoute && route.Stream {
+		return newServiceError(http.StatusBadRequest, "action "+route.Action+" streams its results and must be sent to /handle/stream")
+	}
+
+	if errs := spec.Validate(requestPayload); len(errs) > 0 {
+		return invalidPayload(errs)
+	}
+
+	identity, denied := authorize(ctx, requestPayload.Action)
+	if denied != nil {
+		return denied
+	}
+
+	if isPipeline {
+		return pipeline(ctx, identity, requestPayload)
+	}
+
+	item, err := requestPayload.Field(route.Payload)
+	if err != nil {
+		return newServiceError(http.StatusInternalServerError, err.Error())
+	}
+
+	if scoped, ok := item.(models.UserScoped); ok {
+		scoped.SetUserID(identity.ID)
+	}
+
+	return cachedCall(ctx, route, item)
+}
+
+// authorize checks that the client of the request is allowed to perform the action
+// and is within its rate limits. It returns the client's identity, or the response
+// to send back if the client is denied
+func authorize(ctx context.Context, action string) (*auth.Identity, *serviceResponse) {
+	scope := action
+	if adminActions[action] {
+		scope = auth.ScopeAdmin
+	}
+
+	identity, ok := auth.FromContext(ctx)
+	if !ok || !identity.Allows(scope) {
+		return nil, newServiceError(http.StatusForbidden, "not allowed to perform action "+action)
+	}
+
+	decision := limiter.Allow(identity.ID, action)
+	if !decision.Allowed {
+		return nil, rateLimited(decision)
+	}
+
+	return identity, nil
+}
+
+// rateLimited returns the response to a request that the limiter denied,
+// telling the client when to retry
+func rateLimited(decision ratelimit.Decision) *serviceResponse {
+	response := newServiceError(http.StatusTooManyRequests, decision.Reason)
+	response.Header.Set("Retry-After", strconv.Itoa(int(math.Ceil(decision.RetryAfter.Seconds()))))
+
+	return response
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"common/envelope"
+	"common/errcode"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleBatchRejectsNullPayloads(t *testing.T) {
+	tests := []struct {
+		name   string
+		body   string
+		fields []string
+	}{
+		{name: "only null", body: `[null]`, fields: []string{"0"}},
+		{name: "null after a payload", body: `[{"action":"search","search":{"keyword":"asthma"}}, null]`, fields: []string{"1"}},
+		{name: "several nulls", body: `[null, {"action":"search"}, null]`, fields: []string{"0", "2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := httptest.NewRequest(http.MethodPost, "/handle/batch", strings.NewReader(tt.body))
+			request.Header.Set("Content-Type", "application/json")
+
+			recorder := httptest.NewRecorder()
+
+			// the batch must be rejected before any payload gets dispatched, which would panic on a nil payload
+			HandleBatch(recorder, request)
+
+			if recorder.Code != http.StatusBadRequest {
+				t.Fatalf("got status %d, want %d", recorder.Code, http.StatusBadRequest)
+			}
+
+			var resp struct {
+				envelope.Response
+				Data []errcode.FieldError `json:"data"`
+			}
+
+			if err := json.NewDecoder(recorder.Body).Decode(&resp); err != nil {
+				t.Fatalf("could not decode response: %v", err)
+			}
+
+			if resp.Code != errcode.ValidationFailed || len(resp.Data) != len(tt.fields) {
+				t.Fatalf("got code %s and fields %+v, want %s for entries %v", resp.Code, resp.Data, errcode.ValidationFailed, tt.fields)
+			}
+
+			for i, field := range resp.Data {
+				if field.Field != tt.fields[i] {
+					t.Errorf("got invalid entry %s, want %s", field.Field, tt.fields[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,87 @@
+package server
+
+import (
+	"broker-service/auth"
+	"common/envelope"
+	"crypto/subtle"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// bootstrapAdminID is the identity of requests authenticated with the admin key given on startup
+const bootstrapAdminID = "bootstrap-admin"
+
+// authenticate is the middleware that identifies the client of a request through an API key
+// (X-API-Key header or Bearer) or a signed token (Bearer) and stores its auth.Identity in the request context
+func authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		credential := r.Header.Get("X-API-Key")
+
+		if credential == "" {
+			credential, _ = strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		}
+
+		if credential == "" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="medexpress"`)
+			envelope.ErrorJSON(w, errors.New("authentication required"), http.StatusUnauthorized)
+			return
+		}
+
+		identity, err := identify(credential)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="medexpress", error="invalid_token"`)
+			envelope.ErrorJSON(w, err, http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), identity)))
+	})
+}
+
+// requireScope is the middleware that only lets through requests whose identity has the provided scope
+func requireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			identity, ok := auth.FromContext(r.Context())
+			if !ok || !identity.Allows(scope) {
+				envelope.ErrorJSON(w, errors.New("not allowed to access "+r.URL.Path), http.StatusForbidden)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// identify returns the identity that the provided API key or token belongs to
+func identify(credential string) (*auth.Identity, error) {
+	if auth.IsToken(credential) {
+		if tokenSigner == nil {
+			return nil, errors.New("token authentication is not enabled")
+		}
+
+		claims, err := tokenSigner.Verify(credential)
+		if err != nil {
+			return nil, err
+		}
+
+		// the tokens of API keys only last as long as their key isn't rotated or deleted
+		if claims.KeyID != "" && !keyStore.IsCurrent(claims.KeyID, claims.KeyVersion) {
+			return nil, auth.ErrRevokedToken
+		}
+
+		return claims.Identity(), nil
+	}
+
+	if adminKey != "" && subtle.ConstantTimeCompare([]byte(credential), []byte(adminKey)) == 1 {
+		return &auth.Identity{ID: bootstrapAdminID, Scopes: []string{auth.ScopeAdmin}}, nil
+	}
+
+	identity, ok := keyStore.Authenticate(credential)
+	if !ok {
+		return nil, errors.New("invalid API key")
+	}
+
+	return identity, nil
+}
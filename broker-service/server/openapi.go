@@ -0,0 +1,442 @@
+package server
+
+import (
+	"broker-service/auth"
+	"broker-service/cache"
+	"broker-service/models"
+	"broker-service/openapi"
+	"common/envelope"
+	"common/errcode"
+	"common/health"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// apiVersion is the version of the broker's API that the OpenAPI document describes
+const apiVersion = "1.0.0"
+
+// payloadRequirements are the parts of the payload of an action and the properties it requires in them.
+// Actions that aren't listed are made of the part their route forwards, which only needs to match the models
+var payloadRequirements = map[string]map[string][]string{
+	"search":              {"search": {"keyword", "sites_to_search"}},
+	"search-stream":       {"search": {"keyword", "sites_to_search"}},
+	"get-pdf":             {"search": {"keyword"}},
+	"get-pdf-job":         {"search": {"keyword"}},
+	"search-job":          {"search": {"keyword", "sites_to_search"}},
+	"job-status":          {"job": {"id"}},
+	"register":            {"account": {"email", "password"}},
+	"login":               {"account": {"email", "password"}},
+	"save-search":         {"search": {"keyword", "sites_to_search"}},
+	"delete-saved-search": {"library": {"id"}},
+	"bookmark":            {"library": {"article"}},
+	"remove-bookmark":     {"library": {"article"}},
+	"log":                 {"log": {"keyword"}},
+	"process-text":        {"nlp": {"process", "text"}},
+	"search-and-process":  {"search": {"keyword", "sites_to_search"}, "nlp": {"process"}},
+}
+
+// apiSpec holds the OpenAPI document of the broker along with the schema of the payload of every action,
+// which every payload gets validated against before it's dispatched
+type apiSpec struct {
+	generator *openapi.Generator
+	document  *openapi.Document
+	actions   map[string]*openapi.Schema
+}
+
+// newAPISpec generates the OpenAPI document from the models and the actions of the routing table and the pipelines
+func newAPISpec() *apiSpec {
+	g := openapi.NewGenerator()
+
+	spec := &apiSpec{
+		generator: g,
+		actions:   make(map[string]*openapi.Schema),
+	}
+
+	payload := g.Resolve(g.Ref(models.RequestPayload{}))
+
+	parts := make(map[string][]string)
+	for _, route := range routeTable.Routes() {
+		parts[route.Action] = []string{route.Payload}
+	}
+	for action := range pipelines {
+		parts[action] = nil
+	}
+	for action := range parts {
+		if requirements, ok := payloadRequirements[action]; ok {
+			parts[action] = parts[action][:0]
+			for part := range requirements {
+				parts[action] = append(parts[action], part)
+			}
+			sort.Strings(parts[action])
+		}
+	}
+
+	actionNames := make([]string, 0, len(parts))
+	for action := range parts {
+		actionNames = append(actionNames, action)
+	}
+	sort.Strings(actionNames)
+
+	mapping := make(map[string]string, len(parts))
+	oneOf := make([]*openapi.Schema, 0, len(parts))
+
+	for _, action := range actionNames {
+		schema := &openapi.Schema{
+			Type: "object",
+			Properties: map[string]*openapi.Schema{
+				"action": {Type: "string", Enum: []any{action}},
+			},
+			Required: []string{"action"},
+		}
+
+		for _, part := range parts[action] {
+			// parts made by the broker out of other parts, such as pdf-job, are not in the payload
+			if _, ok := payload.Properties[part]; !ok {
+				continue
+			}
+
+			required := payloadRequirements[action][part]
+
+			schema.Properties[part] = g.Require(payload.Properties[part], required...)
+			if len(required) > 0 {
+				schema.Required = append(schema.Required, part)
+			}
+		}
+
+		name := componentName(action) + "Payload"
+		g.Components[name] = schema
+		spec.actions[action] = schema
+
+		// account actions have endpoints of their own and are not accepted by /handle
+		if accountActions[action] {
+			continue
+		}
+
+		ref := "#/components/schemas/" + name
+		mapping[action] = ref
+		oneOf = append(oneOf, &openapi.Schema{Ref: ref})
+	}
+
+	g.Components["ActionPayload"] = &openapi.Schema{
+		OneOf:         oneOf,
+		Discriminator: &openapi.Discriminator{PropertyName: "action", Mapping: mapping},
+		ErrorCode:     string(errcode.UnknownAction),
+	}
+
+	spec.document = spec.build(actionNames)
+
+	return spec
+}
+
+// Validate checks the payload against the schema of its action and returns
+// the fields that don't match it. Unknown actions are left to the dispatcher
+func (s *apiSpec) Validate(payload *models.RequestPayload) []openapi.FieldError {
+	schema, ok := s.actions[payload.Action]
+	if !ok {
+		return nil
+	}
+
+	// validating the payload as it would be sent, since the schema describes its JSON
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return []openapi.FieldError{{Field: "", Message: err.Error()}}
+	}
+
+	var value any
+	if err = json.Unmarshal(body, &value); err != nil {
+		return []openapi.FieldError{{Field: "", Message: err.Error()}}
+	}
+
+	return s.generator.Validate(schema, value)
+}
+
+// errorCodes returns the schema of the code of the error envelope, which lists the whole catalogue of codes
+func errorCodes() *openapi.Schema {
+	s := &openapi.Schema{Type: "string"}
+
+	var description strings.Builder
+	description.WriteString("The code of the error, only set on errors:\n")
+
+	for _, entry := range errcode.Catalogue() {
+		s.Enum = append(s.Enum, string(entry.Code))
+
+		retry := ""
+		if entry.Retryable {
+			retry = ", retryable"
+		}
+
+		fmt.Fprintf(&description, "  - %s (%d%s): %s\n", entry.Code, entry.Status, retry, entry.Description)
+	}
+
+	s.Description = description.String()
+
+	return s
+}
+
+// build returns the document with the paths of the broker
+func (s *apiSpec) build(actions []string) *openapi.Document {
+	g := s.generator
+
+	errorEnvelope := g.Ref(envelope.Response{})
+	g.Resolve(errorEnvelope).Properties["code"] = errorCodes()
+
+	withData := func(data *openapi.Schema) *openapi.Schema {
+		return &openapi.Schema{
+			Type: "object",
+			Properties: map[string]*openapi.Schema{
+				"error":   {Type: "boolean"},
+				"code":    errorCodes(),
+				"message": {Type: "string"},
+				"data":    data,
+			},
+		}
+	}
+
+	invalid := openapi.Response{
+		Description: "The payload is invalid, data lists the fields that are invalid and why",
+		Content:     openapi.JSON(withData(&openapi.Schema{Type: "array", Items: g.Ref(openapi.FieldError{})})),
+	}
+	failure := func(description string) openapi.Response {
+		return openapi.Response{Description: description, Content: openapi.JSON(errorEnvelope)}
+	}
+
+	// the responses that every action can end with
+	common := map[string]openapi.Response{
+		"304": {Description: "The If-None-Match header matches the ETag of the cached response"},
+		"400": invalid,
+		"401": failure("No valid API key or token given"),
+		"403": failure("The client is not allowed to perform the action"),
+		"429": failure("The client exceeded its rate limit or daily quota, see the Retry-After header"),
+		"502": failure("The service of the action failed or sent back an invalid response"),
+		"503": failure("The service of the action is unreachable or paused by its circuit breaker"),
+		"504": failure("The service of the action timed out"),
+	}
+	responses := func(ok openapi.Response) map[string]openapi.Response {
+		all := map[string]openapi.Response{"200": ok}
+		for status, response := range common {
+			all[status] = response
+		}
+		return all
+	}
+
+	entries := openapi.Response{
+		Description: "The entries found, one per site searched",
+		Content:     openapi.JSON(withData(&openapi.Schema{Type: "array", Items: g.Ref(models.SearchEntry{})})),
+	}
+	success := openapi.Response{Description: "The action succeeded", Content: openapi.JSON(errorEnvelope)}
+
+	admin := func(summary, id string, body any, ok openapi.Response, parameters ...openapi.Parameter) *openapi.Operation {
+		operation := &openapi.Operation{
+			Summary:     summary,
+			OperationID: id,
+			Parameters:  parameters,
+			Responses: map[string]openapi.Response{
+				"200": ok,
+				"401": common["401"],
+				"403": failure("The client does not have the " + auth.ScopeAdmin + " scope"),
+			},
+		}
+		if body != nil {
+			operation.RequestBody = &openapi.RequestBody{Required: true, Content: openapi.JSON(g.Ref(body))}
+		}
+		return operation
+	}
+	account := func(summary, id, status string) *openapi.Operation {
+		return &openapi.Operation{
+			Summary:     summary,
+			OperationID: id,
+			Security:    []map[string][]string{},
+			RequestBody: &openapi.RequestBody{Required: true, Content: openapi.JSON(g.Require(g.Ref(models.AccountQuery{}), "email", "password"))},
+			Responses: map[string]openapi.Response{
+				status: {
+					Description: "The token of the account, valid for " + accountTokenTTL.String(),
+					Content:     openapi.JSON(withData(g.Ref(accountResponse{}))),
+				},
+				"400": invalid,
+				"401": failure("The email or the password is wrong"),
+				"409": failure("An account with the email already exists"),
+				"429": common["429"],
+				"501": failure("Token authentication is not enabled"),
+			},
+		}
+	}
+	keyID := openapi.Parameter{Name: "id", In: "path", Required: true, Schema: &openapi.Schema{Type: "string"}}
+
+	searchQuery := g.Resolve(g.Ref(models.SearchQuery{}))
+	nlpRequest := g.Resolve(g.Ref(models.NLPRequest{}))
+
+	return &openapi.Document{
+		OpenAPI: "3.0.3",
+		Info: openapi.Info{
+			Title:       "MedExpress Broker",
+			Description: "The only end of connection between the outer world and the MedExpress services. Actions: " + strings.Join(actions, ", "),
+			Version:     apiVersion,
+		},
+		Paths: map[string]openapi.PathItem{
+			"/handle": {"post": {
+				Summary:     "Perform the action of the payload",
+				OperationID: "handle",
+				RequestBody: &openapi.RequestBody{Required: true, Content: openapi.JSON(&openapi.Schema{Ref: "#/components/schemas/ActionPayload"})},
+				Responses:   responses(success),
+			}},
+			"/handle/batch": {"post": {
+				Summary:     "Perform the actions of up to 20 payloads concurrently",
+				OperationID: "handleBatch",
+				RequestBody: &openapi.RequestBody{Required: true, Content: openapi.JSON(&openapi.Schema{
+					Type:  "array",
+					Items: &openapi.Schema{Ref: "#/components/schemas/ActionPayload"},
+				})},
+				Responses: map[string]openapi.Response{
+					"200": {
+						Description: "The result of every payload, in the order they were sent",
+						Content:     openapi.JSON(withData(&openapi.Schema{Type: "array", Items: g.Ref(batchResult{})})),
+					},
+					"400": failure("The batch is empty or too large"),
+					"401": common["401"],
+				},
+			}},
+			"/handle/stream": {"post": {
+				Summary:     "Perform a streaming action, its results are sent as Server-Sent Events",
+				OperationID: "handleStream",
+				RequestBody: &openapi.RequestBody{Required: true, Content: openapi.JSON(&openapi.Schema{Ref: "#/components/schemas/ActionPayload"})},
+				Responses: responses(openapi.Response{
+					Description: "The stream of events",
+					Content:     map[string]openapi.MediaType{"text/event-stream": {Schema: &openapi.Schema{Type: "string"}}},
+				}),
+			}},
+			"/status": {"get": {
+				Summary:     "The state of the circuit breaker of every service",
+				OperationID: "status",
+				Responses:   map[string]openapi.Response{"200": success, "401": common["401"]},
+			}},
+			"/jobs/{id}": {"get": {
+				Summary:     "The status, progress and result of a job submitted with get-pdf-job or search-job",
+				OperationID: "jobStatus",
+				Parameters: []openapi.Parameter{
+					{Name: "id", In: "path", Required: true, Schema: &openapi.Schema{Type: "string"}},
+				},
+				Responses: responses(success),
+			}},
+			"/v1/search": {"get": {
+				Summary:     "Search for a keyword in the given sites",
+				OperationID: "v1Search",
+				Parameters: []openapi.Parameter{
+					{Name: "keyword", In: "query", Required: true, Schema: searchQuery.Properties["keyword"]},
+					{Name: "sites", In: "query", Required: true, Description: "Comma separated sites, can be given more than once", Schema: searchQuery.Properties["sites_to_search"]},
+				},
+				Responses: responses(entries),
+			}},
+			"/v1/pdf/{pmcid}": {"get": {
+				Summary:     "Get the pdf of an open access article",
+				OperationID: "v1PDF",
+				Parameters: []openapi.Parameter{
+					{Name: "pmcid", In: "path", Required: true, Schema: &openapi.Schema{Type: "string"}},
+				},
+				Responses: responses(success),
+			}},
+			"/v1/nlp/{process}": {"post": {
+				Summary:     "Run an NLP process on a text",
+				OperationID: "v1ProcessText",
+				Parameters: []openapi.Parameter{
+					{Name: "process", In: "path", Required: true, Schema: nlpRequest.Properties["process"]},
+				},
+				RequestBody: &openapi.RequestBody{Required: true, Content: openapi.JSON(g.Ref(nlpTextRequest{}))},
+				Responses:   responses(success),
+			}},
+			"/admin/keys": {
+				"get":  admin("List the API key credentials", "listKeys", nil, success),
+				"post": admin("Create an API key", "createKey", keyRequest{}, openapi.Response{Description: "The key, which is only shown once", Content: openapi.JSON(withData(g.Ref(keyResponse{})))}),
+			},
+			"/admin/keys/{id}/rotate": {
+				"post": admin("Replace the key of a credential", "rotateKey", nil, openapi.Response{Description: "The new key", Content: openapi.JSON(withData(g.Ref(keyResponse{})))}, keyID),
+			},
+			"/admin/keys/{id}": {
+				"delete": admin("Delete an API key credential", "deleteKey", nil, success, keyID),
+			},
+			"/admin/tokens": {
+				"post": admin("Issue a signed token for a credential", "issueToken", tokenRequest{}, openapi.Response{Description: "The token", Content: openapi.JSON(withData(g.Ref(tokenResponse{})))}),
+			},
+			"/admin/cache": {
+				"get": admin("The stats of the response cache", "cacheStats", nil, openapi.Response{Description: "The stats", Content: openapi.JSON(withData(g.Ref(cache.Stats{})))}),
+				"delete": admin("Purge the cached responses of a keyword and / or a PMCID", "purgeCache", nil, success,
+					openapi.Parameter{Name: "keyword", In: "query", Schema: &openapi.Schema{Type: "string"}},
+					openapi.Parameter{Name: "pmcid", In: "query", Schema: &openapi.Schema{Type: "string"}},
+				),
+			},
+			"/admin/webhooks/dead-letters": {
+				"get": admin("List the webhook notifications that could not be delivered, newest first", "listDeadLetters", nil, success,
+					openapi.Parameter{Name: "page", In: "query", Schema: &openapi.Schema{Type: "integer"}},
+					openapi.Parameter{Name: "per_page", In: "query", Schema: &openapi.Schema{Type: "integer"}},
+				),
+			},
+			"/accounts/register": {"post": account("Register an account, answering with a token for it", "register", "201")},
+			"/accounts/login":    {"post": account("Log into an account, answering with a token for it", "login", "200")},
+			health.LivenessPath: {"get": {
+				Summary:     "Whether the broker is up, without checking the services it routes to",
+				OperationID: "healthz",
+				Security:    []map[string][]string{},
+				Responses:   map[string]openapi.Response{"200": success},
+			}},
+			health.ReadinessPath: {"get": {
+				Summary:     "Whether any service the broker routes to can be reached, with the status of each, cached for " + readyCacheTTL.String(),
+				OperationID: "readyz",
+				Security:    []map[string][]string{},
+				Responses: map[string]openapi.Response{
+					"200": {Description: "Some services can be reached, data holds the status of each", Content: openapi.JSON(withData(g.Ref(health.Report{})))},
+					"503": {Description: "No service can be reached", Content: openapi.JSON(withData(g.Ref(health.Report{})))},
+				},
+			}},
+			"/openapi.json": {"get": {
+				Summary:     "This document",
+				OperationID: "openapi",
+				Security:    []map[string][]string{},
+				Responses:   map[string]openapi.Response{"200": {Description: "The OpenAPI document"}},
+			}},
+		},
+		Components: openapi.Components{
+			Schemas: g.Components,
+			SecuritySchemes: map[string]openapi.SecurityScheme{
+				"apiKey":     {Type: "apiKey", Name: "X-API-Key", In: "header"},
+				"bearerAuth": {Type: "http", Scheme: "bearer", BearerFormat: "JWT"},
+			},
+		},
+		Security: []map[string][]string{{"apiKey": {}}, {"bearerAuth": {}}},
+	}
+}
+
+// componentName turns an action into a component name, e.g. search-and-process into SearchAndProcess
+func componentName(action string) string {
+	var name strings.Builder
+
+	for _, word := range strings.FieldsFunc(action, func(r rune) bool { return r == '-' || r == '_' }) {
+		name.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+
+	return name.String()
+}
+
+// ServeSpec writes the OpenAPI document of the broker
+func ServeSpec(w http.ResponseWriter, r *http.Request) {
+	out, err := json.MarshalIndent(spec.document, "", "  ")
+	if err != nil {
+		envelope.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(out)
+}
+
+// invalidPayload returns the response to a payload that doesn't match the schema of its action
+func invalidPayload(errs []openapi.FieldError) *serviceResponse {
+	return newServiceResponse(http.StatusBadRequest, envelope.Response{
+		Error:   true,
+		Code:    errcode.ValidationFailed,
+		Message: "The payload is invalid",
+		Data:    errs,
+	})
+}
@@ -0,0 +1,197 @@
+package server
+
+import (
+	"broker-service/models"
+	"broker-service/routing"
+	"encoding/json"
+	"testing"
+)
+
+// withSpec sets up the spec of the broker for the default routing table
+func withSpec(t *testing.T) {
+	t.Helper()
+
+	oldTable, oldSpec := routeTable, spec
+	t.Cleanup(func() { routeTable, spec = oldTable, oldSpec })
+
+	routeTable = routing.Default()
+	spec = newAPISpec()
+}
+
+func TestValidateChecksThePayloadOfEveryAction(t *testing.T) {
+	withSpec(t)
+
+	tests := []struct {
+		action  string
+		valid   string
+		invalid string
+		fields  []string
+	}{
+		{
+			action:  "search",
+			valid:   `{"search":{"keyword":"asthma","sites_to_search":["pubmed","nhs"]}}`,
+			invalid: `{"search":{"keyword":"","sites_to_search":["google"]}}`,
+			fields:  []string{"search.keyword", "search.sites_to_search[0]"},
+		},
+		{
+			action:  "search-stream",
+			valid:   `{"search":{"keyword":"asthma","sites_to_search":["wiki"]}}`,
+			invalid: `{"search":{"keyword":"asthma"}}`,
+			fields:  []string{"search.sites_to_search"},
+		},
+		{
+			action:  "search-job",
+			valid:   `{"search":{"keyword":"asthma","sites_to_search":["wiki"],"callback_url":"https://example.com/hook"}}`,
+			invalid: `{"search":{"keyword":"asthma","sites_to_search":[],"callback_url":"example.com"}}`,
+			fields:  []string{"search.sites_to_search", "search.callback_url"},
+		},
+		{
+			action:  "get-pdf",
+			valid:   `{"search":{"keyword":"PMC123"}}`,
+			invalid: `{"search":{}}`,
+			fields:  []string{"search.keyword"},
+		},
+		{
+			action:  "get-pdf-job",
+			valid:   `{"search":{"keyword":"PMC123"}}`,
+			invalid: `{"search":{"keyword":""}}`,
+			fields:  []string{"search.keyword"},
+		},
+		{
+			action:  "job-status",
+			valid:   `{"job":{"id":"1"}}`,
+			invalid: `{}`,
+			fields:  []string{"job.id"},
+		},
+		{
+			action:  "log",
+			valid:   `{"log":{"keyword":"asthma","origin":"nhs"}}`,
+			invalid: `{"log":{"origin":"nhs"}}`,
+			fields:  []string{"log.keyword"},
+		},
+		{
+			action:  "history",
+			valid:   `{"history":{"page":2,"per_page":10}}`,
+			invalid: `{"history":{"page":-1,"per_page":500}}`,
+			fields:  []string{"history.page", "history.per_page"},
+		},
+		{
+			action: "webhook-dead-letters",
+			valid:  `{"history":{"page":1}}`,
+		},
+		{
+			action:  "register",
+			valid:   `{"account":{"email":"user@example.com","password":"password"}}`,
+			invalid: `{"account":{"email":"user","password":"short"}}`,
+			fields:  []string{"account.email", "account.password"},
+		},
+		{
+			action:  "save-search",
+			valid:   `{"search":{"keyword":"asthma","sites_to_search":["pubmed"]}}`,
+			invalid: `{"search":{"sites_to_search":["pubmed"]}}`,
+			fields:  []string{"search.keyword"},
+		},
+		{
+			action:  "delete-saved-search",
+			valid:   `{"library":{"id":"1"}}`,
+			invalid: `{"library":{}}`,
+			fields:  []string{"library.id"},
+		},
+		{
+			action:  "bookmark",
+			valid:   `{"library":{"article":{"id":"1","origin":"pubmed"}}}`,
+			invalid: `{"library":{"article":{"id":"","origin":"google"}}}`,
+			fields:  []string{"library.article.id", "library.article.origin"},
+		},
+		{
+			action:  "remove-bookmark",
+			valid:   `{"library":{"list":"reading","article":{"id":"1","origin":"wiki"}}}`,
+			invalid: `{"library":{"list":"reading"}}`,
+			fields:  []string{"library.article"},
+		},
+		{
+			action:  "alerts",
+			valid:   `{"alerts":{"unread":true,"per_page":20}}`,
+			invalid: `{"alerts":{"page":-1,"per_page":500}}`,
+			fields:  []string{"alerts.page", "alerts.per_page"},
+		},
+		{
+			action:  "process-text",
+			valid:   `{"nlp":{"process":"simplify","text":"some text"}}`,
+			invalid: `{"nlp":{"process":"summarize"}}`,
+			fields:  []string{"nlp.process", "nlp.text"},
+		},
+		{
+			action:  "search-and-process",
+			valid:   `{"search":{"keyword":"asthma","sites_to_search":["nhs"]},"nlp":{"process":"translate","fields":["title"]}}`,
+			invalid: `{"search":{"keyword":"asthma","sites_to_search":["nhs"]},"nlp":{"process":"summarize","fields":["authors"]}}`,
+			fields:  []string{"nlp.fields[0]", "nlp.process"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.action, func(t *testing.T) {
+			if _, ok := spec.actions[tt.action]; !ok {
+				t.Fatalf("got no schema for action %s", tt.action)
+			}
+
+			if errs := spec.Validate(decodePayload(t, tt.action, tt.valid)); len(errs) != 0 {
+				t.Errorf("got errors %+v for a valid payload", errs)
+			}
+
+			if tt.invalid == "" {
+				return
+			}
+
+			errs := spec.Validate(decodePayload(t, tt.action, tt.invalid))
+			if len(errs) != len(tt.fields) {
+				t.Fatalf("got errors %+v, want errors for %v", errs, tt.fields)
+			}
+
+			for i, err := range errs {
+				if err.Field != tt.fields[i] {
+					t.Errorf("got error %+v, want one for %s", err, tt.fields[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSpecDocumentsEveryAction(t *testing.T) {
+	withSpec(t)
+
+	for _, route := range routeTable.Routes() {
+		if _, ok := spec.actions[route.Action]; !ok {
+			t.Errorf("got no schema for the route of action %s", route.Action)
+		}
+	}
+
+	// every action listed in the requirements must exist, or it's documented with parts it doesn't have
+	for action := range payloadRequirements {
+		if _, ok := spec.actions[action]; !ok {
+			t.Errorf("got requirements for action %s, which has no route or pipeline", action)
+		}
+	}
+
+	// account actions are only accepted by their own endpoints
+	mapping := spec.generator.Components["ActionPayload"].Discriminator.Mapping
+	for action := range accountActions {
+		if _, ok := mapping[action]; ok {
+			t.Errorf("got action %s accepted by /handle", action)
+		}
+	}
+}
+
+// decodePayload returns the payload of the action with the parts in the provided JSON
+func decodePayload(t *testing.T, action, parts string) *models.RequestPayload {
+	t.Helper()
+
+	payload := &models.RequestPayload{}
+	if err := json.Unmarshal([]byte(parts), payload); err != nil {
+		t.Fatalf("could not decode payload: %v", err)
+	}
+
+	payload.Action = action
+
+	return payload
+}
@@ -0,0 +1,195 @@
+package server
+
+import (
+	"broker-service/auth"
+	"broker-service/models"
+	"broker-service/routing"
+	"common/envelope"
+	"common/errcode"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// pipelineFunc handles an action that is made up of calls to more than one service
+type pipelineFunc func(ctx context.Context, identity *auth.Identity, payload *models.RequestPayload) *serviceResponse
+
+// pipelines holds the actions that are handled by the broker itself instead of being routed
+var pipelines = map[string]pipelineFunc{
+	"search-and-process": searchAndProcess,
+}
+
+// processableFields are the article fields that search-and-process can run an NLP process on
+var processableFields = map[string]bool{
+	"title":    true,
+	"summary":  true,
+	"abstract": true,
+	"text":     true,
+	"extract":  true,
+}
+
+// defaultProcessFields are processed when the payload doesn't choose any fields
+var defaultProcessFields = []string{"summary", "abstract", "text"}
+
+// maxProcessJobs is the most fields that one search-and-process runs the NLP process on
+const maxProcessJobs = 50
+
+// processJob is the processing of one field of one article
+type processJob struct {
+	article map[string]any
+	field   string
+	result  string
+	err     string
+}
+
+// searchAndProcess searches for the payload's SearchQuery and runs the payload's NLP process
+// on the chosen fields of every article found, in parallel. The processed texts get added
+// to every article under "processed" and the fields that failed under "process_errors",
+// so a failed field doesn't fail the whole request. Searches that find more than maxProcessJobs fields
+// to process are rejected, and the fields are counted against the client's process-text quota
+func searchAndProcess(ctx context.Context, identity *auth.Identity, payload *models.RequestPayload) *serviceResponse {
+	for _, action := range []string{"search", "process-text"} {
+		if !identity.Allows(action) {
+			return newServiceError(http.StatusForbidden, "search-and-process needs permission to perform action "+action)
+		}
+	}
+
+	searchRoute, hasSearch := routeTable.Lookup("search")
+	nlpRoute, hasNLP := routeTable.Lookup("process-text")
+
+	if !hasSearch || !hasNLP {
+		return newServiceError(http.StatusNotImplemented, "search-and-process needs routes for search and process-text")
+	}
+
+	if payload.NLP.Process == "" {
+		return newCodedError(errcode.InvalidProcess, "no nlp process given for search-and-process")
+	}
+
+	fields := payload.NLP.Fields
+	if len(fields) == 0 {
+		fields = defaultProcessFields
+	}
+
+	for _, field := range fields {
+		if !processableFields[field] {
+			return newServiceError(http.StatusBadRequest, fmt.Sprintf("field %q can not be processed", field))
+		}
+	}
+
+	response := cachedCall(ctx, searchRoute, &payload.Search)
+	if response.Status >= http.StatusMultipleChoices {
+		return response
+	}
+
+	var entries []*models.SearchEntry
+
+	parsed, _ := parseEnvelope(response.Body)
+	if parsed == nil || remarshal(parsed.Data, &entries) != nil {
+		return newServiceError(http.StatusBadGateway, "search returned entries that could not be read")
+	}
+
+	var jobs []*processJob
+
+	for _, entry := range entries {
+		// sites that failed to be searched are sent as null entries
+		if entry == nil {
+			continue
+		}
+
+		for _, article := range entry.Data {
+			for _, field := range fields {
+				if text, ok := article[field].(string); ok && text != "" {
+					jobs = append(jobs, &processJob{article: article, field: field})
+				}
+			}
+		}
+	}
+
+	if len(jobs) > maxProcessJobs {
+		return newServiceError(http.StatusBadRequest, fmt.Sprintf(
+			"search-and-process found %d fields to process, more than the limit of %d, search fewer sites or process fewer fields",
+			len(jobs), maxProcessJobs))
+	}
+
+	// every field is a call to the nlp-service on behalf of the client, so they all count against its process-text quota
+	if decision := limiter.Reserve(identity.ID, "process-text", len(jobs)); !decision.Allowed {
+		return rateLimited(decision)
+	}
+
+	wg := new(sync.WaitGroup)
+	wg.Add(len(jobs))
+
+	semaphore := make(chan struct{}, batchConcurrency)
+
+	for _, job := range jobs {
+		go func(job *processJob) {
+			defer wg.Done()
+
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			job.result, job.err = processField(ctx, nlpRoute, payload.NLP.Process, job.article[job.field].(string))
+		}(job)
+	}
+
+	wg.Wait()
+
+	// applying the results only after all jobs are done, since jobs share article maps
+	failed := 0
+
+	for _, job := range jobs {
+		key, value := "processed", job.result
+		if job.err != "" {
+			key, value = "process_errors", job.err
+			failed++
+		}
+
+		results, _ := job.article[key].(map[string]string)
+		if results == nil {
+			results = make(map[string]string)
+			job.article[key] = results
+		}
+
+		results[job.field] = value
+	}
+
+	return newServiceResponse(http.StatusOK, envelope.Response{
+		Error:   false,
+		Message: fmt.Sprintf("Search and process was successful, %d of %d fields could not be processed", failed, len(jobs)),
+		Data:    entries,
+	})
+}
+
+// processField runs the NLP process on the provided text and returns
+// the processed text, or the error message if the process failed
+func processField(ctx context.Context, route *routing.Route, process, text string) (string, string) {
+	response := callService(ctx, route, &models.NLPRequest{Process: process, Text: text})
+
+	parsed, ok := parseEnvelope(response.Body)
+	if !ok {
+		return "", http.StatusText(response.Status)
+	}
+
+	if response.Status >= http.StatusMultipleChoices || parsed.Error {
+		return "", parsed.Message
+	}
+
+	processed, ok := parsed.Data.(string)
+	if !ok {
+		return "", "nlp process returned no text"
+	}
+
+	return processed, ""
+}
+
+// remarshal converts data that was decoded into an interface to the provided type
+func remarshal(data, v any) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(b, v)
+}
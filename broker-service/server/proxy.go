@@ -0,0 +1,561 @@
+package server
+
+import (
+	"broker-service/breaker"
+	"broker-service/metrics"
+	"broker-service/routing"
+	"bufio"
+	"bytes"
+	"common/deadline"
+	"common/envelope"
+	"common/errcode"
+	"common/requestid"
+	"common/tracing"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// maxServiceResponseBytes is the biggest response the broker accepts from a service (pdf texts can be long)
+const maxServiceResponseBytes int64 = 32 << 20
+
+// serviceResponse is the response of a downstream service translated for the client:
+// the status code it maps to, a body that is always an envelope.Response and any extra headers
+type serviceResponse struct {
+	Status int
+	Body   []byte
+	Header http.Header
+}
+
+// callService calls the microservice according to the provided route with the provided item
+// and returns its response mapped to the status code and envelope the broker answers with.
+// Calls go through the circuit breaker of the service, and failed calls of idempotent routes
+// are retried with a jittered exponential backoff, all attempts together within the deadline of the route
+func callService(ctx context.Context, route *routing.Route, item any) *serviceResponse {
+	jsonData, err := json.Marshal(item)
+	if err != nil {
+		return newServiceError(http.StatusInternalServerError, err.Error())
+	}
+
+	requestCtx := ctx
+
+	ctx, cancel := context.WithTimeout(ctx, route.Budget())
+	defer cancel()
+
+	service := route.ServiceURL()
+	b := breakers.Get(service)
+
+	ctx, span := tracing.Start(ctx, "call "+route.Action, trace.WithAttributes(
+		attribute.String("broker.action", route.Action),
+		attribute.String("broker.service", service),
+	))
+	defer span.End()
+
+	attempts := 1
+	if route.Idempotent {
+		attempts += route.Retries
+	}
+
+	var response *serviceResponse
+
+	for attempt := 0; attempt < attempts; attempt++ {
+		if attempt > 0 && !sleepBackoff(ctx, attempt) {
+			break
+		}
+
+		call, err := b.Allow(ctx)
+		if err != nil {
+			if response != nil {
+				break
+			}
+
+			metrics.BreakerRejected(service)
+
+			response = newCodedError(errcode.UpstreamUnavailable, fmt.Sprintf("service %s is unavailable, calls are paused after repeated failures", service))
+			endCallSpan(span, attempt, response)
+
+			return response
+		}
+
+		start := time.Now()
+
+		response = doCall(ctx, route, jsonData)
+
+		metrics.ObserveServiceCall(route.Action, service, response.Status, time.Since(start))
+
+		slog.InfoContext(ctx, "Service called", "action", route.Action, "url", route.URL, "status", response.Status, "latency_ms", time.Since(start).Milliseconds())
+
+		if !response.failed() {
+			call.Success()
+			endCallSpan(span, attempt+1, response)
+			return response
+		}
+
+		// the client left, its deadline or the one of the route passed, so there is no time left to retry
+		if ctx.Err() != nil {
+			call.Cancel()
+			slog.InfoContext(ctx, "Service call cancelled", "action", route.Action, "url", route.URL, "error", ctx.Err())
+
+			if requestCtx.Err() == nil {
+				response = newCodedError(errcode.UpstreamTimeout, fmt.Sprintf("service %s did not respond within the deadline of %s", service, route.Budget()))
+			} else {
+				response = newServiceError(http.StatusGatewayTimeout, fmt.Sprintf("the request ended before service %s responded", service))
+			}
+
+			endCallSpan(span, attempt+1, response)
+			return response
+		}
+
+		call.Failure(errors.New(response.message()))
+
+		slog.WarnContext(ctx, "Service call failed", "action", route.Action, "url", route.URL, "attempt", attempt+1, "attempts", attempts, "status", response.Status, "error", response.message())
+	}
+
+	endCallSpan(span, attempts, response)
+
+	return response
+}
+
+// endCallSpan records the number of attempts and the final response of a call on its span,
+// marking the span as failed if the service failed
+func endCallSpan(span trace.Span, attempts int, response *serviceResponse) {
+	span.SetAttributes(
+		attribute.Int("broker.attempts", attempts),
+		attribute.Int("http.response.status_code", response.Status),
+	)
+
+	if response.failed() {
+		span.SetStatus(codes.Error, response.message())
+	}
+}
+
+// doCall makes a single call to the service of the route with the provided json body
+func doCall(ctx context.Context, route *routing.Route, jsonData []byte) *serviceResponse {
+	ctx, cancel := context.WithTimeout(ctx, route.Timeout.Duration)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, route.Method+" "+route.URL, trace.WithSpanKind(trace.SpanKindClient))
+	defer span.End()
+
+	request, err := http.NewRequestWithContext(ctx, route.Method, route.URL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return newServiceError(http.StatusInternalServerError, err.Error())
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "application/json")
+	request.Header.Set(requestid.Header, requestid.FromContext(ctx))
+	deadline.Set(request.Header, ctx)
+	tracing.Inject(request.Header, ctx)
+
+	response, err := http.DefaultClient.Do(request)
+
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		return newCodedError(transportErrorCode(err), unreachableMessage(route, err))
+	}
+	defer response.Body.Close()
+
+	span.SetAttributes(attribute.Int("http.response.status_code", response.StatusCode))
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, maxServiceResponseBytes+1))
+	if err != nil {
+		return newCodedError(transportErrorCode(err), unreachableMessage(route, err))
+	}
+
+	if int64(len(body)) > maxServiceResponseBytes {
+		return newServiceError(http.StatusBadGateway, fmt.Sprintf("response of service %s is too large", route.ServiceURL()))
+	}
+
+	return mapServiceResponse(route, response.StatusCode, body)
+}
+
+// streamService calls the streaming service of the provided route and relays the Server-Sent Events
+// it sends to w as they arrive. If the service doesn't answer with an event stream, its response
+// gets mapped and written the same way callService does
+func streamService(ctx context.Context, route *routing.Route, item any, w http.ResponseWriter) {
+	jsonData, err := json.Marshal(item)
+	if err != nil {
+		envelope.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	b := breakers.Get(route.ServiceURL())
+
+	call, err := b.Allow(ctx)
+	if err != nil {
+		metrics.BreakerRejected(route.ServiceURL())
+		newCodedError(errcode.UpstreamUnavailable, fmt.Sprintf("service %s is unavailable, calls are paused after repeated failures", route.ServiceURL())).write(w)
+		return
+	}
+
+	// the context of the client, to tell its cancellation apart from the route's timeout
+	clientCtx := ctx
+
+	ctx, cancel := context.WithTimeout(ctx, route.Timeout.Duration)
+	defer cancel()
+
+	ctx, span := tracing.Start(ctx, "stream "+route.Action, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("broker.action", route.Action),
+		attribute.String("broker.service", route.ServiceURL()),
+	))
+	defer span.End()
+
+	request, err := http.NewRequestWithContext(ctx, route.Method, route.URL, bytes.NewBuffer(jsonData))
+	if err != nil {
+		call.Success()
+		envelope.ErrorJSON(w, err, http.StatusInternalServerError)
+		return
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("Accept", "text/event-stream")
+	request.Header.Set(requestid.Header, requestid.FromContext(ctx))
+	deadline.Set(request.Header, ctx)
+	tracing.Inject(request.Header, ctx)
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		if clientCtx.Err() != nil {
+			call.Cancel()
+			return
+		}
+
+		call.Failure(err)
+		newCodedError(transportErrorCode(err), unreachableMessage(route, err)).write(w)
+		return
+	}
+	defer response.Body.Close()
+
+	if !strings.HasPrefix(response.Header.Get("Content-Type"), "text/event-stream") {
+		body, _ := io.ReadAll(io.LimitReader(response.Body, maxServiceResponseBytes))
+
+		mapped := mapServiceResponse(route, response.StatusCode, body)
+		if mapped.failed() {
+			call.Failure(errors.New(mapped.message()))
+		} else {
+			call.Success()
+		}
+
+		mapped.write(w)
+		return
+	}
+
+	call.Success()
+
+	flusher, _ := w.(http.Flusher)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	// relaying line by line, flushing at the blank line that ends every event
+	reader := bufio.NewReader(response.Body)
+
+	for {
+		line, err := reader.ReadBytes('\n')
+
+		if len(line) > 0 {
+			if _, writeErr := w.Write(line); writeErr != nil {
+				return
+			}
+
+			if flusher != nil && len(bytes.TrimSpace(line)) == 0 {
+				flusher.Flush()
+			}
+		}
+
+		if err != nil {
+			if err != io.EOF {
+				slog.WarnContext(ctx, "Stream ended with error", "action", route.Action, "url", route.URL, "error", err)
+			}
+
+			if flusher != nil {
+				flusher.Flush()
+			}
+
+			return
+		}
+	}
+}
+
+// pingProbe returns a breaker.ProbeFunc that calls the /ping endpoint of the provided service
+func pingProbe(service string) breaker.ProbeFunc {
+	return func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+		defer cancel()
+
+		request, err := http.NewRequestWithContext(ctx, http.MethodGet, service+"/ping", nil)
+		if err != nil {
+			return err
+		}
+
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			return err
+		}
+		response.Body.Close()
+
+		if response.StatusCode != http.StatusOK {
+			return fmt.Errorf("ping returned status code %d", response.StatusCode)
+		}
+
+		return nil
+	}
+}
+
+// sleepBackoff waits before the provided retry attempt for a random duration of up to
+// retryBaseDelay * 2^(attempt-1), capped at retryMaxDelay. It returns false if ctx ended while waiting
+func sleepBackoff(ctx context.Context, attempt int) bool {
+	const (
+		retryBaseDelay = 250 * time.Millisecond
+		retryMaxDelay  = 4 * time.Second
+	)
+
+	delay := retryBaseDelay << (attempt - 1)
+	if delay > retryMaxDelay || delay <= 0 {
+		delay = retryMaxDelay
+	}
+
+	timer := time.NewTimer(time.Duration(rand.Int63n(int64(delay)) + 1))
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// failed reports whether the response means that the service failed (and not the client),
+// which is what the circuit breakers count and what gets retried
+func (s *serviceResponse) failed() bool {
+	switch s.Status {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+
+	return false
+}
+
+// message returns the message of the response's envelope
+func (s *serviceResponse) message() string {
+	parsed, ok := parseEnvelope(s.Body)
+	if !ok {
+		return http.StatusText(s.Status)
+	}
+
+	return parsed.Message
+}
+
+// write writes the service response to the client
+func (s *serviceResponse) write(w http.ResponseWriter) {
+	for key, value := range s.Header {
+		w.Header()[key] = value
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(s.Status)
+
+	_, err := w.Write(s.Body)
+	if err != nil {
+		slog.Warn("Could not write service response", "error", err)
+	}
+}
+
+// mapServiceResponse maps a downstream status code and body to the ones the broker answers with:
+//   - 2xx keeps its status, an error envelope sent with 2xx (as nlp-service does) gets the status
+//     of its code, or 400 without one
+//   - 4xx keeps its status, since the service rejected the input of the client
+//   - 5xx becomes 502, since the service failed and not the client
+//
+// Bodies that are valid JSON but not an envelope get wrapped in one as its data,
+// while bodies that are not JSON become the message of an error envelope.
+// The code of an error envelope is kept, and the ones without a code get the generic code of their status
+func mapServiceResponse(route *routing.Route, status int, body []byte) *serviceResponse {
+	service := route.ServiceURL()
+
+	parsed, isEnvelope := parseEnvelope(body)
+	isJSON := isEnvelope || json.Valid(body)
+
+	switch {
+	case status >= 200 && status < 300:
+		if !isJSON {
+			return newServiceError(http.StatusBadGateway, fmt.Sprintf("service %s sent a malformed response", service))
+		}
+
+		if !isEnvelope {
+			return newServiceResponse(status, envelope.Response{
+				Error:   false,
+				Message: "ok",
+				Data:    json.RawMessage(body),
+			})
+		}
+
+		if parsed.Error {
+			status = http.StatusBadRequest
+			if parsed.Code.Known() {
+				status = parsed.Code.Status()
+			}
+
+			// the service failed and not the client, as with 5xx
+			if status >= http.StatusInternalServerError {
+				status = http.StatusBadGateway
+			}
+
+			return rawServiceResponse(status, codedBody(body, parsed, status))
+		}
+
+		return rawServiceResponse(status, body)
+
+	case status >= 400 && status < 500:
+		if isEnvelope {
+			return rawServiceResponse(status, codedBody(body, parsed, status))
+		}
+
+		return newServiceError(status, downstreamMessage(service, status, body, isJSON))
+	}
+
+	if isEnvelope && parsed.Error {
+		return rawServiceResponse(http.StatusBadGateway, codedBody(body, parsed, http.StatusBadGateway))
+	}
+
+	return newServiceError(http.StatusBadGateway, downstreamMessage(service, status, body, isJSON))
+}
+
+// codedBody returns the body of an error envelope with a code, adding the generic code
+// of the status to the envelopes that don't have one
+func codedBody(body []byte, parsed *envelope.Response, status int) []byte {
+	if parsed.Code != "" {
+		return body
+	}
+
+	coded := *parsed
+	coded.Code = errcode.ForStatus(status)
+
+	out, err := json.Marshal(coded)
+	if err != nil {
+		return body
+	}
+
+	return out
+}
+
+// parseEnvelope decodes body as an envelope.Response and reports whether it is one,
+// meaning that it is a JSON object with at least the error and message fields
+func parseEnvelope(body []byte) (*envelope.Response, bool) {
+	var fields map[string]json.RawMessage
+
+	if json.Unmarshal(body, &fields) != nil {
+		return nil, false
+	}
+
+	_, hasError := fields["error"]
+	_, hasMessage := fields["message"]
+
+	if !hasError || !hasMessage {
+		return nil, false
+	}
+
+	parsed := new(envelope.Response)
+
+	if json.Unmarshal(body, parsed) != nil {
+		return nil, false
+	}
+
+	return parsed, true
+}
+
+// downstreamMessage builds the error message for a response of a service that is not an envelope
+func downstreamMessage(service string, status int, body []byte, isJSON bool) string {
+	const maxTextLen = 200
+
+	text := strings.TrimSpace(string(body))
+
+	if isJSON || text == "" {
+		return fmt.Sprintf("service %s responded with status %d", service, status)
+	}
+
+	if len(text) > maxTextLen {
+		text = text[:maxTextLen] + "..."
+	}
+
+	return fmt.Sprintf("service %s responded with status %d: %s", service, status, text)
+}
+
+// unreachableMessage builds the error message for a service that could not be reached
+func unreachableMessage(route *routing.Route, err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Sprintf("service %s did not respond within %s", route.ServiceURL(), route.Timeout)
+	}
+
+	return fmt.Sprintf("service %s is unreachable", route.ServiceURL())
+}
+
+// transportErrorCode returns the code of an error that happened while calling a service:
+// UPSTREAM_TIMEOUT (504) for timeouts, UPSTREAM_UNAVAILABLE (503) when the service can't be connected to
+// and UPSTREAM_FAILED (502) for everything else
+func transportErrorCode(err error) errcode.Code {
+	var (
+		netErr net.Error
+		opErr  *net.OpError
+		dnsErr *net.DNSError
+	)
+
+	switch {
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return errcode.UpstreamTimeout
+	case errors.As(err, &dnsErr), errors.As(err, &opErr) && opErr.Op == "dial":
+		return errcode.UpstreamUnavailable
+	}
+
+	return errcode.UpstreamFailed
+}
+
+// newServiceResponse marshals the provided envelope into a serviceResponse with the provided status
+func newServiceResponse(status int, response envelope.Response) *serviceResponse {
+	body, err := json.Marshal(response)
+	if err != nil {
+		return newServiceError(http.StatusInternalServerError, err.Error())
+	}
+
+	return rawServiceResponse(status, body)
+}
+
+// rawServiceResponse returns a serviceResponse with a body that is already an envelope
+func rawServiceResponse(status int, body []byte) *serviceResponse {
+	return &serviceResponse{Status: status, Body: body, Header: make(http.Header)}
+}
+
+// newServiceError returns a serviceResponse with an error envelope with the provided message
+// and the generic error code of the status
+func newServiceError(status int, message string) *serviceResponse {
+	return newCodedError(errcode.ForStatus(status), message, status)
+}
+
+// newCodedError returns a serviceResponse with an error envelope with the provided code and message,
+// with the status of the code unless one is given
+func newCodedError(code errcode.Code, message string, status ...int) *serviceResponse {
+	statusCode := code.Status()
+	if len(status) > 0 {
+		statusCode = status[0]
+	}
+
+	body, _ := json.Marshal(envelope.Response{Error: true, Code: code, Message: message})
+
+	return rawServiceResponse(statusCode, body)
+}
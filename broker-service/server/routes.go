@@ -0,0 +1,78 @@
+package server
+
+import (
+	"broker-service/auth"
+	"broker-service/metrics"
+	"common/deadline"
+	"common/health"
+	"common/httpmetrics"
+	"common/httpmw"
+	"common/requestid"
+	"common/tracing"
+	"net/http"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+)
+
+func routes() http.Handler {
+	mux := chi.NewRouter()
+
+	// the broker reports every service it routes to, but stays ready while any of them can be reached,
+	// since it still handles the actions of those. The report is cached so probes don't ping every service
+	checker := health.New(pingTimeout)
+	checker.NeedsAny()
+	checker.CacheFor(readyCacheTTL)
+	for _, service := range routeTable.Services() {
+		checker.Add(service, health.Check(pingProbe(service)))
+	}
+
+	mux.Use(middleware.Heartbeat("/ping"))
+	mux.Use(checker.Middleware)
+	mux.Use(requestid.Middleware)
+	mux.Use(deadline.Middleware)
+	mux.Use(tracing.Middleware)
+	mux.Use(metrics.Middleware)
+	mux.Use(httpmw.LogRequests)
+	mux.Use(httpmw.Recover)
+
+	mux.Get("/openapi.json", ServeSpec)
+	mux.Method(http.MethodGet, "/metrics", httpmetrics.Handler())
+
+	mux.Post("/accounts/register", Register)
+	mux.Post("/accounts/login", Login)
+
+	mux.Group(func(mux chi.Router) {
+		mux.Use(authenticate)
+
+		mux.Post("/handle", HandleSubmittion)
+		mux.Post("/handle/batch", HandleBatch)
+		mux.Post("/handle/stream", HandleStream)
+		mux.Get("/status", ServiceStatus)
+		mux.Get("/jobs/{id}", JobStatus)
+	})
+
+	mux.Route("/v1", func(mux chi.Router) {
+		mux.Use(authenticate)
+
+		mux.Get("/search", V1Search)
+		mux.Get("/pdf/{pmcid}", V1PDF)
+		mux.Post("/nlp/{process}", V1ProcessText)
+	})
+
+	mux.Route("/admin", func(mux chi.Router) {
+		mux.Use(authenticate)
+		mux.Use(requireScope(auth.ScopeAdmin))
+
+		mux.Get("/keys", ListKeys)
+		mux.Post("/keys", CreateKey)
+		mux.Post("/keys/{id}/rotate", RotateKey)
+		mux.Delete("/keys/{id}", DeleteKey)
+		mux.Post("/tokens", IssueToken)
+		mux.Get("/cache", CacheStats)
+		mux.Delete("/cache", PurgeCache)
+		mux.Get("/webhooks/dead-letters", ListDeadLetters)
+	})
+
+	return mux
+}
@@ -0,0 +1,152 @@
+package server
+
+import (
+	"broker-service/auth"
+	"broker-service/breaker"
+	"broker-service/cache"
+	"broker-service/metrics"
+	"broker-service/models"
+	"broker-service/ratelimit"
+	"broker-service/routing"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	pingTimeout     = 5 * time.Second
+	pingRetries     = 5
+	pingRetryPeriod = 3 * time.Second
+	// readyCacheTTL is how long the readiness of the broker is served before its services get pinged again
+	readyCacheTTL = 5 * time.Second
+)
+
+var (
+	// routeTable maps every action the broker accepts to its downstream service
+	routeTable *routing.Table
+	// breakers holds the circuit breaker of every downstream service
+	breakers *breaker.Set
+	// keyStore holds the API keys of the clients
+	keyStore *auth.Store
+	// tokenSigner signs and verifies client tokens, it's nil when no token secret is given
+	tokenSigner *auth.Signer
+	// limiter applies the rate limits and daily quotas per client and action
+	limiter *ratelimit.Limiter
+	// adminKey is the key that can always access the admin endpoints, used to create the first keys
+	adminKey string
+	// accountScopes are the scopes of the tokens issued to accounts on registration and login
+	accountScopes []string
+	// responseCache holds the cached responses of the routes with a cache ttl, it's nil when caching is disabled
+	responseCache *cache.Cache
+	// spec is the OpenAPI document of the broker, which payloads get validated against
+	spec *apiSpec
+)
+
+// Config is the configuration of the broker
+type Config struct {
+	// RoutesPath is the path to the JSON routing config, the built-in routes are used when it's empty
+	RoutesPath string
+	// BreakerThreshold is the number of consecutive failures of a service that pause calls to it
+	BreakerThreshold int
+	// BreakerTimeout is how long calls to a failing service stay paused before it gets probed
+	BreakerTimeout time.Duration
+	// LimitsPath is the path to the JSON rate limit config, the built-in limits are used when it's empty
+	LimitsPath string
+	// KeyStorePath is the path to the file that stores the API keys
+	KeyStorePath string
+	// TokenSecret signs client tokens, token auth is disabled when it's empty
+	TokenSecret string
+	// AdminKey is the key that can always access the admin endpoints
+	AdminKey string
+	// AccountScopes are the scopes of the tokens issued to accounts, the DefaultAccountScopes are used when it's empty
+	AccountScopes []string
+	// CacheSize is the maximum bytes of responses kept in the response cache, 0 disables the cache
+	CacheSize int64
+}
+
+// New sets up the routing table, the circuit breakers, the key store, the rate limits
+// and the response cache of the broker and returns the handler of the broker
+func New(config Config) (http.Handler, error) {
+	breakers = breaker.NewSet(breaker.Config{
+		FailureThreshold: config.BreakerThreshold,
+		OpenTimeout:      config.BreakerTimeout,
+	}, pingProbe)
+
+	limits, err := ratelimit.Load(config.LimitsPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not load rate limits: %w", err)
+	}
+
+	limiter = ratelimit.New(limits)
+
+	keyStore, err = auth.NewStore(config.KeyStorePath)
+	if err != nil {
+		return nil, fmt.Errorf("could not load key store: %w", err)
+	}
+
+	if config.TokenSecret != "" {
+		tokenSigner = auth.NewSigner(config.TokenSecret)
+	} else {
+		slog.Warn("No token secret given, token authentication is disabled")
+	}
+
+	accountScopes = config.AccountScopes
+	if len(accountScopes) == 0 {
+		accountScopes = DefaultAccountScopes()
+	}
+
+	for _, scope := range accountScopes {
+		if scope == auth.ScopeAdmin {
+			return nil, errors.New("accounts can't be given the admin scope")
+		}
+	}
+
+	adminKey = config.AdminKey
+	if adminKey == "" {
+		slog.Warn("No admin key given, keys can only be managed by stored admin keys")
+	}
+
+	routeTable, err = routing.Load(config.RoutesPath)
+	if err != nil {
+		return nil, fmt.Errorf("could not load routing table: %w", err)
+	}
+
+	for _, route := range routeTable.Routes() {
+		if _, err = new(models.RequestPayload).Field(route.Payload); err != nil {
+			return nil, fmt.Errorf("route for action %s is invalid: %w", route.Action, err)
+		}
+
+		slog.Info("Routing action", "action", route.Action, "method", route.Method, "url", route.URL)
+	}
+
+	spec = newAPISpec()
+
+	if config.CacheSize > 0 {
+		responseCache = cache.New(config.CacheSize)
+		metrics.WatchCache(responseCache)
+	}
+
+	return routes(), nil
+}
+
+// CheckServices pings the services of the routing table until all of them respond
+// or the retries run out, logging the ones that could not be reached
+func CheckServices() {
+	client := &http.Client{Timeout: pingTimeout}
+
+	for i := 1; i <= pingRetries; i++ {
+		failed := routeTable.CheckServices(client)
+		if len(failed) == 0 {
+			slog.Info("All routed services are reachable")
+			return
+		}
+
+		for service, err := range failed {
+			slog.Warn("Service not reachable", "service", service, "attempt", i, "attempts", pingRetries, "error", err)
+		}
+
+		time.Sleep(pingRetryPeriod)
+	}
+}
@@ -0,0 +1,75 @@
+package server
+
+import (
+	"broker-service/models"
+	"common/envelope"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi"
+)
+
+// nlpTextRequest is the body of POST /v1/nlp/{process}
+type nlpTextRequest struct {
+	Text string `json:"text"`
+}
+
+// V1Search handles GET /v1/search?keyword=&sites=, where sites is a comma separated list
+// that can also be given more than once (sites=pubmed&sites=nhs). It's the same as the search action
+func V1Search(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var sites []string
+
+	for _, value := range query["sites"] {
+		for _, site := range strings.Split(value, ",") {
+			if site = strings.TrimSpace(site); site != "" {
+				sites = append(sites, site)
+			}
+		}
+	}
+
+	payload := &models.RequestPayload{
+		Action: "search",
+		Search: models.SearchQuery{
+			Keyword:       query.Get("keyword"),
+			SitesToSearch: sites,
+		},
+	}
+
+	dispatch(r.Context(), payload).writeFor(w, r)
+}
+
+// V1PDF handles GET /v1/pdf/{pmcid}. It's the same as the get-pdf action
+func V1PDF(w http.ResponseWriter, r *http.Request) {
+	payload := &models.RequestPayload{
+		Action: "get-pdf",
+		Search: models.SearchQuery{
+			Keyword: chi.URLParam(r, "pmcid"),
+		},
+	}
+
+	dispatch(r.Context(), payload).writeFor(w, r)
+}
+
+// V1ProcessText handles POST /v1/nlp/{process} with the text to process in the body.
+// It's the same as the process-text action
+func V1ProcessText(w http.ResponseWriter, r *http.Request) {
+	request := new(nlpTextRequest)
+
+	err := envelope.ReadJSON(w, r, request)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	payload := &models.RequestPayload{
+		Action: "process-text",
+		NLP: models.NLPRequest{
+			Process: chi.URLParam(r, "process"),
+			Text:    request.Text,
+		},
+	}
+
+	dispatch(r.Context(), payload).writeFor(w, r)
+}
@@ -0,0 +1,37 @@
+package deadline
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// Header is the header that carries the deadline of a request from service to service,
+// as an RFC 3339 timestamp. Every service stops working on a request once its deadline passes
+const Header = "X-Request-Deadline"
+
+// Set sets the deadline of ctx, if it has one, in the headers of an outgoing request
+func Set(header http.Header, ctx context.Context) {
+	if deadline, ok := ctx.Deadline(); ok {
+		header.Set(Header, deadline.UTC().Format(time.RFC3339Nano))
+	}
+}
+
+// Middleware applies the deadline sent by the caller in the X-Request-Deadline header
+// to the request context, so the work of a request stops once the caller stops waiting for it.
+// Headers that can't be parsed are ignored, and the context still gets cancelled
+// when the caller disconnects, as with every request
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deadline, err := time.Parse(time.RFC3339Nano, r.Header.Get(Header))
+		if err != nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithDeadline(r.Context(), deadline)
+		defer cancel()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,133 @@
+package envelope
+
+import (
+	"common/errcode"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// maxBytes is the largest request body that gets read, one megabyte
+const maxBytes int64 = 1048576
+
+// Response is the json envelope of every response of the services.
+// Code is only set on errors, see errcode
+type Response struct {
+	Error   bool         `json:"error"`
+	Code    errcode.Code `json:"code,omitempty"`
+	Message string       `json:"message"`
+	Data    any          `json:"data,omitempty"`
+}
+
+// Validator is implemented by the requests that check their own fields once they are read, see ReadJSON
+type Validator interface {
+	Validate() []errcode.FieldError
+}
+
+// ReadJSON reads the json body of a request into data. The body must hold a single json value
+// of at most one megabyte, and data gets validated if it's a Validator.
+// The errors returned are *errcode.Error, ready to be written with ErrorJSON
+func ReadJSON(w http.ResponseWriter, r *http.Request, data any) error {
+	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+
+	dec := json.NewDecoder(r.Body)
+	err := dec.Decode(data)
+	if err != nil {
+		return decodeError(err)
+	}
+
+	err = dec.Decode(&struct{}{})
+	if err != io.EOF {
+		return errcode.New(errcode.InvalidJSON, "body must have only a single json value")
+	}
+
+	if v, ok := data.(Validator); ok {
+		if fields := v.Validate(); len(fields) > 0 {
+			return errcode.Invalid(fields...)
+		}
+	}
+
+	return nil
+}
+
+// decodeError returns the *errcode.Error of an error of decoding a request body
+func decodeError(err error) error {
+	var (
+		syntaxError   *json.SyntaxError
+		typeError     *json.UnmarshalTypeError
+		maxBytesError *http.MaxBytesError
+	)
+
+	switch {
+	case errors.As(err, &maxBytesError):
+		return errcode.New(errcode.PayloadTooLarge, fmt.Sprintf("body must not be larger than %d bytes", maxBytesError.Limit))
+	case errors.As(err, &syntaxError):
+		return errcode.New(errcode.InvalidJSON, fmt.Sprintf("body has badly-formed json (at character %d)", syntaxError.Offset))
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return errcode.New(errcode.InvalidJSON, "body has badly-formed json")
+	case errors.Is(err, io.EOF):
+		return errcode.New(errcode.InvalidJSON, "body must not be empty")
+	case errors.As(err, &typeError) && typeError.Field != "":
+		return errcode.Invalid(errcode.FieldError{Field: typeError.Field, Message: "must be of type " + typeError.Type.String()})
+	}
+
+	return errcode.New(errcode.InvalidJSON, err.Error())
+}
+
+// WriteJSON takes a response status code and arbitrary data and writes a json response to the client
+func WriteJSON(w http.ResponseWriter, status int, data any, headers ...http.Header) error {
+	out, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	if len(headers) > 0 {
+		for key, value := range headers[0] {
+			w.Header()[key] = value
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(out)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ErrorJSON takes an error, and optionally a response status code, and writes a json error response.
+// The status code defaults to the one of the error's code, or to 400 Bad Request for errors without one,
+// which get the generic code of the status. The invalid fields of validation errors are sent as data
+func ErrorJSON(w http.ResponseWriter, err error, status ...int) error {
+	code := errcode.Of(err)
+
+	statusCode := http.StatusBadRequest
+
+	switch {
+	case len(status) > 0:
+		statusCode = status[0]
+	case code != "":
+		statusCode = code.Status()
+	}
+
+	if code == "" {
+		code = errcode.ForStatus(statusCode)
+	}
+
+	payload := Response{
+		Error:   true,
+		Code:    code,
+		Message: err.Error(),
+	}
+
+	var e *errcode.Error
+	if errors.As(err, &e) && len(e.Fields) > 0 {
+		payload.Data = e.Fields
+	}
+
+	return WriteJSON(w, statusCode, payload)
+}
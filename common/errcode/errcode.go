@@ -0,0 +1,223 @@
+package errcode
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"sort"
+)
+
+// Code is a stable, machine-readable error code sent along with the message of every error response,
+// so that clients don't have to read the English message to tell errors apart.
+// Codes are never renamed or removed once released, see Catalogue
+type Code string
+
+// The generic codes, which errors without a code of their own get according to their status, see ForStatus
+const (
+	BadRequest       Code = "BAD_REQUEST"
+	InvalidJSON      Code = "INVALID_JSON"
+	ValidationFailed Code = "VALIDATION_FAILED"
+	PayloadTooLarge  Code = "PAYLOAD_TOO_LARGE"
+	Unauthorized     Code = "UNAUTHORIZED"
+	Forbidden        Code = "FORBIDDEN"
+	NotFound         Code = "NOT_FOUND"
+	Conflict         Code = "CONFLICT"
+	RateLimited      Code = "RATE_LIMITED"
+	Internal         Code = "INTERNAL"
+	NotImplemented   Code = "NOT_IMPLEMENTED"
+	Unavailable      Code = "UNAVAILABLE"
+	Timeout          Code = "TIMEOUT"
+)
+
+// The codes of the errors of the services
+const (
+	UnknownAction       Code = "UNKNOWN_ACTION"
+	EmptyKeyword        Code = "EMPTY_KEYWORD"
+	InvalidSite         Code = "INVALID_SITE"
+	NoSites             Code = "NO_SITES"
+	InvalidProcess      Code = "INVALID_PROCESS"
+	InvalidEmail        Code = "INVALID_EMAIL"
+	InvalidPassword     Code = "INVALID_PASSWORD"
+	EmailTaken          Code = "EMAIL_TAKEN"
+	InvalidCredentials  Code = "INVALID_CREDENTIALS"
+	JobNotFound         Code = "JOB_NOT_FOUND"
+	SavedSearchNotFound Code = "SAVED_SEARCH_NOT_FOUND"
+	BookmarkNotFound    Code = "BOOKMARK_NOT_FOUND"
+	ArticleNotFound     Code = "ARTICLE_NOT_FOUND"
+	PDFNotOpenAccess    Code = "PDF_NOT_OPEN_ACCESS"
+	PDFNotFound         Code = "PDF_NOT_FOUND"
+	PDFConversionFailed Code = "PDF_CONVERSION_FAILED"
+	NLPFailed           Code = "NLP_FAILED"
+	ScrapeFailed        Code = "SCRAPE_FAILED"
+	WebhooksDisabled    Code = "WEBHOOKS_DISABLED"
+	UpstreamFailed      Code = "UPSTREAM_FAILED"
+	UpstreamUnavailable Code = "UPSTREAM_UNAVAILABLE"
+	UpstreamTimeout     Code = "UPSTREAM_TIMEOUT"
+)
+
+// Entry describes a code of the catalogue: the status code of its responses,
+// whether the request may succeed if it's sent again later and what went wrong
+type Entry struct {
+	Code        Code   `json:"code"`
+	Status      int    `json:"status"`
+	Retryable   bool   `json:"retryable"`
+	Description string `json:"description"`
+}
+
+// catalogue holds every code there is
+var catalogue = map[Code]Entry{
+	BadRequest:       {Status: http.StatusBadRequest, Description: "The request can not be handled"},
+	InvalidJSON:      {Status: http.StatusBadRequest, Description: "The body is not a single, well-formed json value"},
+	ValidationFailed: {Status: http.StatusBadRequest, Description: "Fields of the request are invalid, data lists them with their own codes"},
+	PayloadTooLarge:  {Status: http.StatusRequestEntityTooLarge, Description: "The body is too large"},
+	Unauthorized:     {Status: http.StatusUnauthorized, Description: "No valid API key or token was given"},
+	Forbidden:        {Status: http.StatusForbidden, Description: "The client is not allowed to perform the request"},
+	NotFound:         {Status: http.StatusNotFound, Description: "What the request refers to does not exist"},
+	Conflict:         {Status: http.StatusConflict, Description: "The request conflicts with existing data"},
+	RateLimited:      {Status: http.StatusTooManyRequests, Retryable: true, Description: "The client exceeded its rate limit or quota, see the Retry-After header"},
+	Internal:         {Status: http.StatusInternalServerError, Description: "The service failed unexpectedly"},
+	NotImplemented:   {Status: http.StatusNotImplemented, Description: "The feature is not enabled on this deployment"},
+	Unavailable:      {Status: http.StatusServiceUnavailable, Retryable: true, Description: "The service can not handle requests right now"},
+	Timeout:          {Status: http.StatusGatewayTimeout, Retryable: true, Description: "The deadline of the request passed before it was done"},
+
+	UnknownAction:       {Status: http.StatusBadRequest, Description: "The action of the payload does not exist"},
+	EmptyKeyword:        {Status: http.StatusBadRequest, Description: "No keyword was given to search for"},
+	InvalidSite:         {Status: http.StatusBadRequest, Description: "A site to search is not one of the supported sites"},
+	NoSites:             {Status: http.StatusBadRequest, Description: "No sites to search were given"},
+	InvalidProcess:      {Status: http.StatusBadRequest, Description: "The nlp process is missing or unknown"},
+	InvalidEmail:        {Status: http.StatusBadRequest, Description: "The email is not a valid email address"},
+	InvalidPassword:     {Status: http.StatusBadRequest, Description: "The password is too short or too long"},
+	EmailTaken:          {Status: http.StatusConflict, Description: "An account with the email already exists"},
+	InvalidCredentials:  {Status: http.StatusUnauthorized, Description: "The email or the password is wrong"},
+	JobNotFound:         {Status: http.StatusNotFound, Description: "The job does not exist or belongs to another client"},
+	SavedSearchNotFound: {Status: http.StatusNotFound, Description: "The saved search does not exist"},
+	BookmarkNotFound:    {Status: http.StatusNotFound, Description: "The article is not in the reading list"},
+	ArticleNotFound:     {Status: http.StatusNotFound, Description: "No article was found for the keyword"},
+	PDFNotOpenAccess:    {Status: http.StatusNotFound, Description: "The article has no free pdf in the PMC open access subset"},
+	PDFNotFound:         {Status: http.StatusNotFound, Description: "The archive of the article holds no pdf"},
+	PDFConversionFailed: {Status: http.StatusInternalServerError, Description: "The pdf could not be converted to text"},
+	NLPFailed:           {Status: http.StatusInternalServerError, Description: "The nlp process failed on the text"},
+	ScrapeFailed:        {Status: http.StatusBadGateway, Retryable: true, Description: "The site could not be scraped"},
+	WebhooksDisabled:    {Status: http.StatusNotImplemented, Description: "Webhooks are not enabled, so no callback url can be given"},
+	UpstreamFailed:      {Status: http.StatusBadGateway, Retryable: true, Description: "A service or site behind the one called failed or sent back an invalid response"},
+	UpstreamUnavailable: {Status: http.StatusServiceUnavailable, Retryable: true, Description: "A service or site behind the one called is unreachable or paused by its circuit breaker"},
+	UpstreamTimeout:     {Status: http.StatusGatewayTimeout, Retryable: true, Description: "A service or site behind the one called did not respond in time"},
+}
+
+// Catalogue returns every code there is, sorted by code
+func Catalogue() []Entry {
+	entries := make([]Entry, 0, len(catalogue))
+	for code, entry := range catalogue {
+		entry.Code = code
+		entries = append(entries, entry)
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Code < entries[j].Code })
+
+	return entries
+}
+
+// Known reports whether the code is in the catalogue
+func (c Code) Known() bool {
+	_, ok := catalogue[c]
+
+	return ok
+}
+
+// Status returns the response status code of code, 400 Bad Request for the codes it doesn't know
+func (c Code) Status() int {
+	if entry, ok := catalogue[c]; ok {
+		return entry.Status
+	}
+
+	return http.StatusBadRequest
+}
+
+// Retryable reports whether a request that failed with code may succeed if it's sent again later
+func (c Code) Retryable() bool {
+	return catalogue[c].Retryable
+}
+
+// ForStatus returns the generic code of a response status code, for the errors that don't have a code of their own
+func ForStatus(status int) Code {
+	switch status {
+	case http.StatusRequestEntityTooLarge:
+		return PayloadTooLarge
+	case http.StatusUnauthorized:
+		return Unauthorized
+	case http.StatusForbidden:
+		return Forbidden
+	case http.StatusNotFound:
+		return NotFound
+	case http.StatusConflict:
+		return Conflict
+	case http.StatusTooManyRequests:
+		return RateLimited
+	case http.StatusNotImplemented:
+		return NotImplemented
+	case http.StatusBadGateway:
+		return UpstreamFailed
+	case http.StatusServiceUnavailable:
+		return Unavailable
+	case http.StatusGatewayTimeout:
+		return Timeout
+	}
+
+	if status >= http.StatusInternalServerError {
+		return Internal
+	}
+
+	return BadRequest
+}
+
+// FieldError describes why the value of a field of a request is invalid.
+// Code is set for the fields whose errors have a code of their own, e.g. EMPTY_KEYWORD
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    Code   `json:"code,omitempty"`
+	Message string `json:"message"`
+}
+
+// Error is an error with a code. Its message is the one sent to the client
+type Error struct {
+	Code    Code
+	Message string
+	Fields  []FieldError
+}
+
+// New returns an error with the provided code and message
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Invalid returns a ValidationFailed error for the provided invalid fields
+func Invalid(fields ...FieldError) *Error {
+	return &Error{Code: ValidationFailed, Message: "The request is invalid", Fields: fields}
+}
+
+// Upstream returns the error of a call to the named service or site that failed without a response:
+// UpstreamTimeout if it didn't respond in time, UpstreamUnavailable otherwise
+func Upstream(name string, err error) *Error {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) && netErr.Timeout() {
+		return New(UpstreamTimeout, name+" did not respond in time")
+	}
+
+	return New(UpstreamUnavailable, name+" is unreachable")
+}
+
+// Error returns the message of the error
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Of returns the code of err, or an empty code if err has none
+func Of(err error) Code {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+
+	return ""
+}
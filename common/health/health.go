@@ -0,0 +1,204 @@
+// Package health serves the liveness and readiness endpoints of the services: /healthz reports that
+// the service is up, while /readyz checks the dependencies it needs to handle requests and reports the status of each
+package health
+
+import (
+	"common/envelope"
+	"common/errcode"
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// LivenessPath is the path of the liveness endpoint, which never checks any dependency,
+	// so that a service doesn't get restarted over a dependency that is down
+	LivenessPath = "/healthz"
+	// ReadinessPath is the path of the readiness endpoint, which fails while any dependency is unusable,
+	// or only once none is usable for a Checker that needs any of them
+	ReadinessPath = "/readyz"
+)
+
+// The statuses of a dependency
+const (
+	StatusOK     = "ok"
+	StatusFailed = "failed"
+)
+
+// Check returns the error that makes a dependency unusable, or nil if it can be used.
+// It must return once ctx ends
+type Check func(ctx context.Context) error
+
+// Status is the result of the check of a dependency
+type Status struct {
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	LatencyMS int64  `json:"latency_ms"`
+}
+
+// Report is the data of the readiness endpoint, the status of every dependency by name
+type Report struct {
+	Ready        bool              `json:"ready"`
+	Dependencies map[string]Status `json:"dependencies"`
+}
+
+// Checker checks the dependencies of a service
+type Checker struct {
+	timeout time.Duration
+	names   []string
+	checks  map[string]Check
+
+	// needsAny makes the service ready while any of its dependencies is usable, rather than all of them
+	needsAny bool
+
+	// cacheTTL is how long a report is served before the dependencies get checked again, 0 disables caching.
+	// mu is held while checking, so that the probes that come in meanwhile wait for the same report
+	cacheTTL  time.Duration
+	mu        sync.Mutex
+	cached    Report
+	checkedAt time.Time
+}
+
+// New returns a Checker without any dependencies, whose checks fail if they take longer than timeout
+func New(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout, checks: make(map[string]Check)}
+}
+
+// Add adds a dependency to check, replacing the check of a dependency with the same name
+func (c *Checker) Add(name string, check Check) {
+	if _, ok := c.checks[name]; !ok {
+		c.names = append(c.names, name)
+		sort.Strings(c.names)
+	}
+
+	c.checks[name] = check
+}
+
+// NeedsAny makes the service ready while any of its dependencies can be used, e.g. for a service that
+// routes to others, which can still handle the requests of the ones that are up
+func (c *Checker) NeedsAny() {
+	c.needsAny = true
+}
+
+// CacheFor makes the readiness endpoint serve the last report for ttl before checking the dependencies again,
+// so that frequent probes don't turn into as many checks of every dependency
+func (c *Checker) CacheFor(ttl time.Duration) {
+	c.cacheTTL = ttl
+}
+
+// Check runs the checks of all dependencies at the same time and returns their report
+func (c *Checker) Check(ctx context.Context) Report {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	report := Report{Ready: !c.needsAny || len(c.names) == 0, Dependencies: make(map[string]Status, len(c.names))}
+
+	var (
+		mu sync.Mutex
+		wg sync.WaitGroup
+	)
+
+	for _, name := range c.names {
+		wg.Add(1)
+
+		go func(name string, check Check) {
+			defer wg.Done()
+
+			start := time.Now()
+
+			err := check(ctx)
+
+			status := Status{Status: StatusOK, LatencyMS: time.Since(start).Milliseconds()}
+			if err != nil {
+				status.Status = StatusFailed
+				status.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			report.Dependencies[name] = status
+			if c.needsAny {
+				report.Ready = report.Ready || err == nil
+			} else if err != nil {
+				report.Ready = false
+			}
+		}(name, c.checks[name])
+	}
+
+	wg.Wait()
+
+	return report
+}
+
+// Middleware serves the liveness and readiness endpoints. Like chi's Heartbeat it answers them ahead of the
+// middleware that follows, so the probes of the endpoints don't get logged, traced or counted as requests
+func (c *Checker) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		switch r.URL.Path {
+		case LivenessPath:
+			envelope.WriteJSON(w, http.StatusOK, envelope.Response{Error: false, Message: "Service is alive"})
+		case ReadinessPath:
+			c.serveReadiness(w, r)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// report returns the report of the dependencies, checking them again once the cached one is older than cacheTTL
+func (c *Checker) report(ctx context.Context) Report {
+	if c.cacheTTL <= 0 {
+		return c.Check(ctx)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.checkedAt.IsZero() && time.Since(c.checkedAt) < c.cacheTTL {
+		return c.cached
+	}
+
+	// the report is shared by the probes that follow, so it must not fail because this one went away
+	c.cached = c.Check(context.WithoutCancel(ctx))
+	c.checkedAt = time.Now()
+
+	return c.cached
+}
+
+// serveReadiness writes the report of the dependencies, with 503 Service Unavailable if the service isn't ready
+func (c *Checker) serveReadiness(w http.ResponseWriter, r *http.Request) {
+	report := c.report(r.Context())
+
+	var failed []string
+	for _, name := range c.names {
+		if report.Dependencies[name].Status != StatusOK {
+			failed = append(failed, name)
+		}
+	}
+
+	if report.Ready {
+		message := "Service is ready"
+		if len(failed) > 0 {
+			message += ", failed dependencies: " + strings.Join(failed, ", ")
+		}
+
+		envelope.WriteJSON(w, http.StatusOK, envelope.Response{Error: false, Message: message, Data: report})
+		return
+	}
+
+	envelope.WriteJSON(w, errcode.Unavailable.Status(), envelope.Response{
+		Error:   true,
+		Code:    errcode.Unavailable,
+		Message: "Service is not ready, failed dependencies: " + strings.Join(failed, ", "),
+		Data:    report,
+	})
+}
@@ -0,0 +1,91 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+var errDown = errors.New("down")
+
+func up(context.Context) error   { return nil }
+func down(context.Context) error { return errDown }
+
+func TestCheckReadiness(t *testing.T) {
+	tests := []struct {
+		name     string
+		needsAny bool
+		checks   map[string]Check
+		ready    bool
+	}{
+		{name: "no dependencies", ready: true},
+		{name: "every dependency up", checks: map[string]Check{"a": up, "b": up}, ready: true},
+		{name: "a dependency down", checks: map[string]Check{"a": up, "b": down}, ready: false},
+		{name: "needs any, one down", needsAny: true, checks: map[string]Check{"a": up, "b": down}, ready: true},
+		{name: "needs any, every one down", needsAny: true, checks: map[string]Check{"a": down, "b": down}, ready: false},
+		{name: "needs any without dependencies", needsAny: true, ready: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New(time.Second)
+			if tt.needsAny {
+				c.NeedsAny()
+			}
+
+			for name, check := range tt.checks {
+				c.Add(name, check)
+			}
+
+			recorder := httptest.NewRecorder()
+			c.Middleware(http.NotFoundHandler()).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, ReadinessPath, nil))
+
+			wantStatus := http.StatusServiceUnavailable
+			if tt.ready {
+				wantStatus = http.StatusOK
+			}
+
+			if recorder.Code != wantStatus {
+				t.Fatalf("got status %d, want %d", recorder.Code, wantStatus)
+			}
+
+			report := c.Check(context.Background())
+			if report.Ready != tt.ready || len(report.Dependencies) != len(tt.checks) {
+				t.Errorf("got report %+v, want ready %t with every dependency", report, tt.ready)
+			}
+		})
+	}
+}
+
+func TestReadinessIsCached(t *testing.T) {
+	var checks atomic.Int32
+
+	c := New(time.Second)
+	c.CacheFor(50 * time.Millisecond)
+	c.Add("a", func(context.Context) error {
+		checks.Add(1)
+		return nil
+	})
+
+	probe := func() {
+		c.Middleware(http.NotFoundHandler()).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, ReadinessPath, nil))
+	}
+
+	probe()
+	probe()
+
+	if n := checks.Load(); n != 1 {
+		t.Fatalf("got %d checks within the ttl, want 1", n)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	probe()
+
+	if n := checks.Load(); n != 2 {
+		t.Errorf("got %d checks after the ttl, want 2", n)
+	}
+}
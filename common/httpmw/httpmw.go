@@ -0,0 +1,61 @@
+package httpmw
+
+import (
+	"common/envelope"
+	"common/errcode"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/go-chi/chi/middleware"
+)
+
+// Recover is the middleware that turns a panic of a handler into a 500 Internal Server Error response,
+// logging the panic with its stack, so that a single bad request doesn't take the whole service down.
+// http.ErrAbortHandler is let through, since it's how handlers abort a response on purpose
+func Recover(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+
+			if rec == http.ErrAbortHandler {
+				panic(rec)
+			}
+
+			slog.ErrorContext(r.Context(), "Handler panicked",
+				"method", r.Method,
+				"path", r.URL.Path,
+				"panic", fmt.Sprint(rec),
+				"stack", string(debug.Stack()),
+			)
+
+			envelope.ErrorJSON(w, errcode.New(errcode.Internal, "internal error"))
+		}()
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// LogRequests is the middleware that logs every request with its status code and latency
+func LogRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+
+		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		next.ServeHTTP(ww, r)
+
+		slog.InfoContext(r.Context(), "Request handled",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", ww.Status(),
+			"bytes", ww.BytesWritten(),
+			"latency_ms", time.Since(start).Milliseconds(),
+		)
+	})
+}
@@ -0,0 +1,29 @@
+// Package logging sets up the structured logging that every service shares
+package logging
+
+import (
+	"common/requestid"
+	"log/slog"
+	"os"
+)
+
+// New returns the structured json logger of the service, logging at the level
+// set in the LOG_LEVEL environment variable (debug, info, warn or error, info by default).
+// Every record carries the name of the service and the request id of its context
+func New(service string) *slog.Logger {
+	level := new(slog.LevelVar)
+
+	if err := level.UnmarshalText([]byte(os.Getenv("LOG_LEVEL"))); err != nil {
+		level.Set(slog.LevelInfo)
+	}
+
+	handler := slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level})
+
+	return slog.New(requestid.LogHandler{Handler: handler}).With("service", service)
+}
+
+// Fatal logs the error that stops the service and exits
+func Fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
+}
@@ -0,0 +1,141 @@
+// Package memnet connects services that run in the same process over in-memory connections,
+// so that they keep calling each other over HTTP by their host names, e.g. http://search-service/...,
+// without any sockets or DNS
+package memnet
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// Network holds the in-memory listeners of the services, by host name
+type Network struct {
+	mu        sync.RWMutex
+	listeners map[string]*Listener
+	dialer    net.Dialer
+}
+
+// New returns a Network without any listeners
+func New() *Network {
+	return &Network{listeners: make(map[string]*Listener)}
+}
+
+// Listen returns a new listener for the host, which the connections to the host reach whatever their port.
+// It replaces the listener the host had
+func (n *Network) Listen(host string) *Listener {
+	l := &Listener{host: host, conns: make(chan net.Conn), done: make(chan struct{})}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.listeners[host] = l
+
+	return l
+}
+
+// Has reports whether the host has a listener in the network
+func (n *Network) Has(host string) bool {
+	return n.listener(host) != nil
+}
+
+// DialContext connects to the listener of the host of addr,
+// or over the real network to the hosts that have no listener in the network
+func (n *Network) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+
+	if l := n.listener(host); l != nil {
+		return l.dial(ctx)
+	}
+
+	return n.dialer.DialContext(ctx, network, addr)
+}
+
+// Transport returns a copy of http.DefaultTransport that dials through the network.
+// The requests to the hosts of the network never go through a proxy
+func (n *Network) Transport() *http.Transport {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+
+	proxy := t.Proxy
+	t.Proxy = func(r *http.Request) (*url.URL, error) {
+		if proxy == nil || n.Has(r.URL.Hostname()) {
+			return nil, nil
+		}
+
+		return proxy(r)
+	}
+
+	t.DialContext = n.DialContext
+
+	return t
+}
+
+func (n *Network) listener(host string) *Listener {
+	n.mu.RLock()
+	defer n.mu.RUnlock()
+
+	return n.listeners[host]
+}
+
+// Listener is a net.Listener whose connections are the in-memory ones dialed to its host
+type Listener struct {
+	host  string
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+// Accept waits for and returns the next connection to the listener
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close closes the listener, the connections it accepted stay open
+func (l *Listener) Close() error {
+	l.once.Do(func() { close(l.done) })
+
+	return nil
+}
+
+// Addr returns the address of the listener, which is its host
+func (l *Listener) Addr() net.Addr {
+	return addr(l.host)
+}
+
+// dial returns the client end of a new connection to the listener, once the listener accepts it
+func (l *Listener) dial(ctx context.Context) (net.Conn, error) {
+	server, client := net.Pipe()
+
+	var err error
+
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		err = &net.OpError{Op: "dial", Net: addr(l.host).Network(), Addr: addr(l.host), Err: errors.New("connection refused")}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	server.Close()
+	client.Close()
+
+	return nil, err
+}
+
+// addr is the net.Addr of the listener of a host
+type addr string
+
+func (a addr) Network() string { return "memnet" }
+func (a addr) String() string  { return string(a) }
@@ -0,0 +1,99 @@
+package requestid
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+)
+
+// Header is the header that carries the request id from service to service
+const Header = "X-Request-ID"
+
+// maxLen is the longest request id accepted from a caller, longer ones get replaced
+const maxLen = 128
+
+type contextKey struct{}
+
+// New generates a new random request id
+func New() string {
+	b := make([]byte, 16)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return "unknown"
+	}
+
+	return hex.EncodeToString(b)
+}
+
+// NewContext returns a copy of ctx that carries the provided request id
+func NewContext(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, contextKey{}, id)
+}
+
+// FromContext returns the request id stored in ctx, or an empty string if there is none
+func FromContext(ctx context.Context) string {
+	id, _ := ctx.Value(contextKey{}).(string)
+
+	return id
+}
+
+// Middleware accepts the request id sent by the caller in the X-Request-ID header,
+// or creates one if there was none, stores it in the request context
+// and sends it back in the response headers
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(Header)
+
+		if id == "" || len(id) > maxLen || !isPrintable(id) {
+			id = New()
+		}
+
+		w.Header().Set(Header, id)
+
+		next.ServeHTTP(w, r.WithContext(NewContext(r.Context(), id)))
+	})
+}
+
+// LogHandler is a slog.Handler that adds the request id of the context to every record
+type LogHandler struct {
+	slog.Handler
+}
+
+// Handle adds the request id of ctx to the record, if there is one, and passes it on
+func (h LogHandler) Handle(ctx context.Context, r slog.Record) error {
+	if id := FromContext(ctx); id != "" {
+		r.AddAttrs(slog.String("request_id", id))
+	}
+
+	return h.Handler.Handle(ctx, r)
+}
+
+// WithAttrs returns a LogHandler whose underlying handler has the provided attributes
+func (h LogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return LogHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+// WithGroup returns a LogHandler whose underlying handler has the provided group
+func (h LogHandler) WithGroup(name string) slog.Handler {
+	return LogHandler{Handler: h.Handler.WithGroup(name)}
+}
+
+// isPrintable reports whether s only has printable ascii characters, so it's safe to log and forward
+func isPrintable(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x21 || s[i] > 0x7e {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Detach returns a background context that only carries the request id of ctx,
+// for work that must not be cut short when the request that started it ends
+func Detach(ctx context.Context) context.Context {
+	return NewContext(context.Background(), FromContext(ctx))
+}
@@ -0,0 +1,5 @@
+// Package integration tests the services end to end. The broker, search-service, med-scraper-service
+// and med-api-service run on httptest servers in front of fake PubMed, NHS, Wikipedia and PMC sites,
+// which serve the recorded pages and api responses of testdata, and search-service keeps its collections in memory,
+// so the whole search flow runs offline with go test ./...
+package integration
@@ -0,0 +1,284 @@
+package integration
+
+import (
+	"broker-service/routing"
+	broker "broker-service/server"
+	"bufio"
+	"bytes"
+	"common/envelope"
+	"context"
+	"encoding/json"
+	medapi "med-api-service/server"
+	scraper "med-scraper-service/server"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	search "search-service/server"
+	"strings"
+	"testing"
+	"time"
+)
+
+// adminKey is the admin key of the broker, used to create the API key of the tests
+const adminKey = "integration-admin-key"
+
+// harness is the broker and the services behind it, each on a httptest server of its own,
+// running in front of the fake sites. The services keep their state in package variables,
+// so only one harness may run at a time and the tests don't run in parallel
+type harness struct {
+	t *testing.T
+
+	Broker    *httptest.Server
+	Search    *httptest.Server
+	Scraper   *httptest.Server
+	MedAPI    *httptest.Server
+	NLP       *httptest.Server
+	Upstreams *upstreams
+
+	// key is an API key of the broker with every scope
+	key string
+}
+
+// event is a Server-Sent Event read from a stream of the broker
+type event struct {
+	Name string
+	Data json.RawMessage
+}
+
+// newHarness starts the fake sites, the services and the broker,
+// which all get closed along with the test
+func newHarness(t *testing.T) *harness {
+	t.Helper()
+
+	h := &harness{t: t, Upstreams: startUpstreams(t)}
+
+	h.Scraper = startServer(t, scraper.New(scraper.Config{
+		PubMedURL: h.Upstreams.PubMed.URL + "/?term=",
+		NHSURL:    h.Upstreams.NHS.URL + "/search/results?q=",
+	}))
+
+	h.MedAPI = startServer(t, medapi.New(medapi.Config{
+		WikipediaURL: h.Upstreams.Wikipedia.URL + "/api/rest_v1/page/summary/",
+		PMCOAURL:     h.Upstreams.PMCOA.URL + "/oa.fcgi?id=",
+	}))
+
+	// without a mongo client the collections are kept in memory, and start out empty for every harness
+	searchHandler, err := search.New(context.Background(), search.Config{
+		JobWorkers: 2,
+		JobQueue:   10,
+		JobTimeout: time.Minute,
+		ScraperURL: h.Scraper.URL,
+		MedAPIURL:  h.MedAPI.URL,
+	})
+	if err != nil {
+		t.Fatalf("could not set up search-service: %v", err)
+	}
+
+	h.Search = startServer(t, searchHandler)
+
+	// the nlp service is written in python, so it's stood in for by a server that only answers its pings
+	h.NLP = startServer(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/ping" {
+			http.NotFound(w, r)
+		}
+	}))
+
+	// the response cache is left disabled, so every request of the tests reaches the services
+	brokerHandler, err := broker.New(broker.Config{
+		RoutesPath:       writeRoutes(t, map[string]string{"search-service": h.Search.URL, "nlp-service": h.NLP.URL}),
+		BreakerThreshold: 5,
+		BreakerTimeout:   30 * time.Second,
+		KeyStorePath:     filepath.Join(t.TempDir(), "keys.json"),
+		AdminKey:         adminKey,
+	})
+	if err != nil {
+		t.Fatalf("could not set up the broker: %v", err)
+	}
+
+	h.Broker = startServer(t, brokerHandler)
+
+	h.key = h.createKey()
+
+	return h
+}
+
+func startServer(t *testing.T, handler http.Handler) *httptest.Server {
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	return server
+}
+
+// writeRoutes writes a routing config of the broker with the built-in routes,
+// the ones to the provided services pointed at their urls, and returns its path
+func writeRoutes(t *testing.T, services map[string]string) string {
+	t.Helper()
+
+	routes := routing.Default().Routes()
+
+	for _, route := range routes {
+		u, err := url.Parse(route.URL)
+		if err != nil {
+			t.Fatalf("built-in route of %s has an invalid url: %v", route.Action, err)
+		}
+
+		if serviceURL, ok := services[u.Host]; ok {
+			route.URL = serviceURL + u.Path
+		}
+	}
+
+	config, err := json.Marshal(map[string]any{"routes": routes})
+	if err != nil {
+		t.Fatalf("could not encode routes: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "routes.json")
+
+	err = os.WriteFile(path, config, 0o600)
+	if err != nil {
+		t.Fatalf("could not write routes: %v", err)
+	}
+
+	return path
+}
+
+// createKey creates an API key with every scope through the admin api of the broker and returns it
+func (h *harness) createKey() string {
+	h.t.Helper()
+
+	status, resp := h.send(http.MethodPost, "/admin/keys", adminKey, map[string]any{"name": "integration", "scopes": []string{"*"}})
+	if status != http.StatusCreated {
+		h.t.Fatalf("could not create API key: status %d, %s", status, resp.Message)
+	}
+
+	var created struct {
+		Key string `json:"key"`
+	}
+
+	decodeData(h.t, resp, &created)
+
+	return created.Key
+}
+
+// get sends a GET request to the path of the server and returns the status and the envelope of the response
+func (h *harness) get(server *httptest.Server, path string) (int, *envelope.Response) {
+	h.t.Helper()
+
+	response, err := server.Client().Get(server.URL + path)
+	if err != nil {
+		h.t.Fatalf("GET %s failed: %v", path, err)
+	}
+
+	return h.read(response)
+}
+
+// handle sends the payload to /handle of the broker and returns the status and the envelope of the response
+func (h *harness) handle(payload any) (int, *envelope.Response) {
+	h.t.Helper()
+
+	return h.send(http.MethodPost, "/handle", h.key, payload)
+}
+
+// send sends the payload as json to the path of the broker with the provided key
+// and returns the status and the envelope of the response
+func (h *harness) send(method, path, key string, payload any) (int, *envelope.Response) {
+	h.t.Helper()
+
+	return h.read(h.do(method, path, key, payload))
+}
+
+// read returns the status and the envelope of the response, closing its body
+func (h *harness) read(response *http.Response) (int, *envelope.Response) {
+	h.t.Helper()
+
+	defer response.Body.Close()
+
+	resp := new(envelope.Response)
+
+	err := json.NewDecoder(response.Body).Decode(resp)
+	if err != nil {
+		h.t.Fatalf("%s %s sent back a response that could not be read: %v", response.Request.Method, response.Request.URL.Path, err)
+	}
+
+	return response.StatusCode, resp
+}
+
+// stream sends the payload to /handle/stream of the broker and returns the events it streams back
+func (h *harness) stream(payload any) []event {
+	h.t.Helper()
+
+	response := h.do(http.MethodPost, "/handle/stream", h.key, payload)
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		h.t.Fatalf("stream failed with status %d", response.StatusCode)
+	}
+
+	var (
+		events  []event
+		current event
+	)
+
+	scanner := bufio.NewScanner(response.Body)
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			current.Name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			current.Data = json.RawMessage(strings.TrimPrefix(line, "data: "))
+		case line == "" && current.Name != "":
+			events = append(events, current)
+			current = event{}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		h.t.Fatalf("could not read stream: %v", err)
+	}
+
+	return events
+}
+
+func (h *harness) do(method, path, key string, payload any) *http.Response {
+	h.t.Helper()
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		h.t.Fatalf("could not encode payload: %v", err)
+	}
+
+	request, err := http.NewRequest(method, h.Broker.URL+path, bytes.NewReader(body))
+	if err != nil {
+		h.t.Fatalf("could not create request: %v", err)
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("X-API-Key", key)
+
+	response, err := h.Broker.Client().Do(request)
+	if err != nil {
+		h.t.Fatalf("%s %s failed: %v", method, path, err)
+	}
+
+	return response
+}
+
+// decodeData decodes the data of the envelope into v
+func decodeData(t *testing.T, resp *envelope.Response, v any) {
+	t.Helper()
+
+	data, err := json.Marshal(resp.Data)
+	if err != nil {
+		t.Fatalf("could not encode data: %v", err)
+	}
+
+	err = json.Unmarshal(data, v)
+	if err != nil {
+		t.Fatalf("could not decode data %s: %v", data, err)
+	}
+}
@@ -0,0 +1,112 @@
+package integration
+
+import (
+	"common/errcode"
+	"common/health"
+	"net/http"
+	"net/http/httptest"
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// readiness returns the status and the report of the readiness endpoint of the server
+func (h *harness) readiness(server *httptest.Server) (int, health.Report) {
+	h.t.Helper()
+
+	status, resp := h.get(server, health.ReadinessPath)
+
+	var report health.Report
+	decodeData(h.t, resp, &report)
+
+	if status != http.StatusOK && resp.Code != errcode.Unavailable {
+		h.t.Errorf("got code %s for status %d, want %s", resp.Code, status, errcode.Unavailable)
+	}
+
+	return status, report
+}
+
+func TestEveryServiceIsAlive(t *testing.T) {
+	h := newHarness(t)
+
+	for name, server := range map[string]*httptest.Server{
+		"broker":              h.Broker,
+		"search-service":      h.Search,
+		"med-scraper-service": h.Scraper,
+		"med-api-service":     h.MedAPI,
+	} {
+		if status, resp := h.get(server, health.LivenessPath); status != http.StatusOK || resp.Error {
+			t.Errorf("%s is not alive: status %d, %s", name, status, resp.Message)
+		}
+	}
+}
+
+func TestSearchServiceIsReadyWithItsStore(t *testing.T) {
+	h := newHarness(t)
+
+	status, report := h.readiness(h.Search)
+
+	if status != http.StatusOK || !report.Ready || report.Dependencies["mongo"].Status != health.StatusOK {
+		t.Errorf("got status %d and report %+v, want ready with the in-memory store", status, report)
+	}
+}
+
+func TestMedAPIServiceIsReadyWithPDFToText(t *testing.T) {
+	h := newHarness(t)
+
+	_, lookErr := exec.LookPath("pdftotext")
+	installed := lookErr == nil
+
+	status, report := h.readiness(h.MedAPI)
+
+	if report.Dependencies["temp_dir"].Status != health.StatusOK {
+		t.Errorf("got temp dir %+v, want it writable", report.Dependencies["temp_dir"])
+	}
+
+	if available := report.Dependencies["pdftotext"].Status == health.StatusOK; available != installed {
+		t.Errorf("got pdftotext %+v, want ok only when it's installed (installed: %t)", report.Dependencies["pdftotext"], installed)
+	}
+
+	wantStatus := http.StatusServiceUnavailable
+	if installed {
+		wantStatus = http.StatusOK
+	}
+
+	if status != wantStatus || report.Ready != installed {
+		t.Errorf("got status %d and ready %t, want %d", status, report.Ready, wantStatus)
+	}
+}
+
+func TestBrokerIsReadyWhileItsServicesCanBeReached(t *testing.T) {
+	h := newHarness(t)
+
+	status, report := h.readiness(h.Broker)
+
+	if status != http.StatusOK || !report.Ready || len(report.Dependencies) != 2 {
+		t.Fatalf("got status %d and report %+v, want ready with search-service and nlp-service reachable", status, report)
+	}
+
+	h.Search.Close()
+
+	// the report of the broker is cached, so the closed service only shows once the cached one expires
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(250 * time.Millisecond) {
+		status, report = h.readiness(h.Broker)
+
+		if report.Dependencies[h.Search.URL].Status != health.StatusOK || time.Now().After(deadline) {
+			break
+		}
+	}
+
+	// the broker still handles the actions of the nlp-service
+	if status != http.StatusOK || !report.Ready {
+		t.Fatalf("got status %d and report %+v, want ready while the nlp-service can be reached", status, report)
+	}
+
+	if search := report.Dependencies[h.Search.URL]; search.Status != health.StatusFailed || search.Error == "" {
+		t.Errorf("got search-service %+v, want it failed with its error", search)
+	}
+
+	if nlp := report.Dependencies[h.NLP.URL]; nlp.Status != health.StatusOK {
+		t.Errorf("got nlp-service %+v, want it ok", nlp)
+	}
+}
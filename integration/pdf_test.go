@@ -0,0 +1,68 @@
+package integration
+
+import (
+	"common/errcode"
+	"net/http"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func pdfPayload(pmcid string) map[string]any {
+	return map[string]any{
+		"action": "get-pdf",
+		"search": map[string]any{"keyword": pmcid},
+	}
+}
+
+func TestGetPDFConvertsTheOpenAccessPDF(t *testing.T) {
+	if _, err := exec.LookPath("pdftotext"); err != nil {
+		t.Skip("pdftotext is not installed")
+	}
+
+	h := newHarness(t)
+
+	status, resp := h.handle(pdfPayload("PMC6612345"))
+	if status != http.StatusOK {
+		t.Fatalf("get-pdf failed with status %d: %s %s", status, resp.Code, resp.Message)
+	}
+
+	var pdf struct {
+		PMID    string `json:"pmid"`
+		PDFText string `json:"pdf_text"`
+	}
+
+	decodeData(t, resp, &pdf)
+
+	if pdf.PMID != "PMC6612345" || !strings.Contains(pdf.PDFText, "Inhaled corticosteroids") {
+		t.Errorf("got pdf %+v, want the text of the pdf of PMC6612345", pdf)
+	}
+}
+
+func TestGetPDFFailsWhenNotOpenAccess(t *testing.T) {
+	h := newHarness(t)
+
+	status, resp := h.handle(pdfPayload("PMC1111111"))
+
+	if status != http.StatusNotFound || resp.Code != errcode.PDFNotOpenAccess {
+		t.Errorf("got status %d and code %s, want %d and %s", status, resp.Code, http.StatusNotFound, errcode.PDFNotOpenAccess)
+	}
+
+	if hits := h.Upstreams.PMCDownloads.Hits(); hits != 0 {
+		t.Errorf("the download server got %d requests, want none", hits)
+	}
+}
+
+func TestGetPDFFailsWhenTheArchiveHoldsNoPDF(t *testing.T) {
+	h := newHarness(t)
+
+	status, resp := h.handle(pdfPayload("PMC7000001"))
+
+	if status != http.StatusNotFound || resp.Code != errcode.PDFNotFound {
+		t.Errorf("got status %d and code %s, want %d and %s", status, resp.Code, http.StatusNotFound, errcode.PDFNotFound)
+	}
+
+	if hits := h.Upstreams.PMCDownloads.Hits(); hits != 1 {
+		t.Errorf("the download server got %d requests, want the archive downloaded once", hits)
+	}
+}
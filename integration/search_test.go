@@ -0,0 +1,279 @@
+package integration
+
+import (
+	"common/errcode"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+// searchEntry is the entry of one site that a search sends back
+type searchEntry struct {
+	Keyword string    `json:"keyword"`
+	Origin  string    `json:"origin"`
+	Data    []article `json:"data"`
+}
+
+// article holds the fields of the articles of every site
+type article struct {
+	Title    string   `json:"title"`
+	Summary  string   `json:"summary"`
+	Keywords []string `json:"keywords"`
+	PMID     string   `json:"pmid"`
+	PMCID    string   `json:"pmcid"`
+	Link     string   `json:"link"`
+	Abstract string   `json:"abstract"`
+	Authors  []string `json:"authors"`
+	Text     string   `json:"text"`
+	Extract  string   `json:"extract"`
+}
+
+func searchPayload(keyword string, sites ...string) map[string]any {
+	return map[string]any{
+		"action": "search",
+		"search": map[string]any{"keyword": keyword, "sites_to_search": sites},
+	}
+}
+
+// byTitle returns the articles of the entry by title, since the articles of a site get collected concurrently
+func byTitle(t *testing.T, entry searchEntry) map[string]article {
+	t.Helper()
+
+	articles := make(map[string]article, len(entry.Data))
+
+	for _, a := range entry.Data {
+		articles[a.Title] = a
+	}
+
+	if len(articles) != len(entry.Data) {
+		t.Fatalf("%s sent back articles with the same title: %+v", entry.Origin, entry.Data)
+	}
+
+	return articles
+}
+
+func TestSearchCollectsEverySite(t *testing.T) {
+	h := newHarness(t)
+
+	status, resp := h.handle(searchPayload("asthma", "pubmed", "nhs", "wiki"))
+	if status != http.StatusOK {
+		t.Fatalf("search failed with status %d: %s %s", status, resp.Code, resp.Message)
+	}
+
+	var entries []searchEntry
+	decodeData(t, resp, &entries)
+
+	if len(entries) != 3 {
+		t.Fatalf("got %d entries, want one for each of the 3 sites", len(entries))
+	}
+
+	for i, site := range []string{"pubmed", "nhs", "wiki"} {
+		if entries[i].Origin != site || entries[i].Keyword != "asthma" {
+			t.Errorf("entry %d is for %s %q, want %s \"asthma\"", i, entries[i].Origin, entries[i].Keyword, site)
+		}
+	}
+
+	pubmed := byTitle(t, entries[0])
+	if len(pubmed) != 2 {
+		t.Fatalf("got %d pubmed articles, want the 2 of the search page", len(pubmed))
+	}
+
+	corticosteroids, ok := pubmed["Inhaled corticosteroids in the long-term management of asthma in adults"]
+	if !ok {
+		t.Fatalf("pubmed article on inhaled corticosteroids is missing, got %+v", entries[0].Data)
+	}
+
+	if corticosteroids.PMID != "31245678" || corticosteroids.PMCID != "PMC6612345" {
+		t.Errorf("got pmid %q and pmcid %q, want 31245678 and PMC6612345", corticosteroids.PMID, corticosteroids.PMCID)
+	}
+
+	if corticosteroids.Link != "https://www.ncbi.nlm.nih.gov/pmc/articles/PMC6612345/" {
+		t.Errorf("got link %q, want the one of the PMC article", corticosteroids.Link)
+	}
+
+	if len(corticosteroids.Authors) != 3 || corticosteroids.Authors[0] != "James R Harrison" {
+		t.Errorf("got authors %q, want the 3 authors of the article", corticosteroids.Authors)
+	}
+
+	// the keywords listed under the abstract are used instead of extracted ones, and are no part of the abstract
+	if len(corticosteroids.Keywords) != 3 || corticosteroids.Keywords[0] != "adherence" {
+		t.Errorf("got keywords %q, want the 3 listed in the article", corticosteroids.Keywords)
+	}
+
+	if corticosteroids.Abstract == "" || corticosteroids.Summary == "" {
+		t.Errorf("got abstract %q and summary %q, want both", corticosteroids.Abstract, corticosteroids.Summary)
+	}
+
+	bronchoconstriction, ok := pubmed["Exercise-induced bronchoconstriction in children with asthma"]
+	if !ok {
+		t.Fatalf("pubmed article on bronchoconstriction is missing, got %+v", entries[0].Data)
+	}
+
+	if bronchoconstriction.PMCID != "" || len(bronchoconstriction.Keywords) == 0 {
+		t.Errorf("got pmcid %q and keywords %q, want no pmcid and extracted keywords", bronchoconstriction.PMCID, bronchoconstriction.Keywords)
+	}
+
+	// the pagination link of the nhs search page leads back to the search, so it isn't visited
+	nhs := byTitle(t, entries[1])
+	if len(nhs) != 2 {
+		t.Fatalf("got %d nhs articles, want the 2 of the search page", len(nhs))
+	}
+
+	condition, ok := nhs["Asthma"]
+	if !ok {
+		t.Fatalf("nhs article on asthma is missing, got %+v", entries[1].Data)
+	}
+
+	if condition.Link != h.Upstreams.NHS.URL+"/conditions/asthma/" || condition.Text == "" {
+		t.Errorf("got link %q and text %q, want the link and the text of the nhs page", condition.Link, condition.Text)
+	}
+
+	if _, ok = nhs["Salbutamol inhaler"]; !ok {
+		t.Errorf("nhs article on salbutamol is missing, got %+v", entries[1].Data)
+	}
+
+	if len(entries[2].Data) != 1 || entries[2].Data[0].Title != "Asthma" || entries[2].Data[0].Extract == "" {
+		t.Errorf("got wikipedia articles %+v, want the summary of the asthma page", entries[2].Data)
+	}
+}
+
+func TestSearchIsServedFromTheStoreOnceCollected(t *testing.T) {
+	h := newHarness(t)
+
+	for i := 0; i < 2; i++ {
+		status, resp := h.handle(searchPayload("asthma", "pubmed", "wiki"))
+		if status != http.StatusOK {
+			t.Fatalf("search %d failed with status %d: %s %s", i+1, status, resp.Code, resp.Message)
+		}
+	}
+
+	// the search page and the 2 articles were visited for the first search only, since the broker
+	// doesn't cache responses in the harness, the second one was found in the store of search-service
+	if hits := h.Upstreams.PubMed.Hits(); hits != 3 {
+		t.Errorf("pubmed got %d requests, want 3", hits)
+	}
+
+	if hits := h.Upstreams.Wikipedia.Hits(); hits != 1 {
+		t.Errorf("wikipedia got %d requests, want 1", hits)
+	}
+}
+
+func TestSearchFailsWhenNoSiteCouldBeSearched(t *testing.T) {
+	h := newHarness(t)
+
+	// wikipedia has no page for the keyword
+	status, resp := h.handle(searchPayload("zzxq", "wiki"))
+
+	if status != http.StatusNotFound || resp.Code != errcode.ArticleNotFound {
+		t.Errorf("got status %d and code %s, want %d and %s", status, resp.Code, http.StatusNotFound, errcode.ArticleNotFound)
+	}
+}
+
+func TestSearchReportsUnreachableSites(t *testing.T) {
+	h := newHarness(t)
+
+	h.Upstreams.NHS.Close()
+
+	status, resp := h.handle(searchPayload("asthma", "nhs"))
+
+	if status != http.StatusBadGateway || resp.Code != errcode.ScrapeFailed {
+		t.Errorf("got status %d and code %s, want %d and %s", status, resp.Code, http.StatusBadGateway, errcode.ScrapeFailed)
+	}
+}
+
+func TestSearchRejectsInvalidSites(t *testing.T) {
+	h := newHarness(t)
+
+	status, resp := h.handle(searchPayload("asthma", "pubmed", "bing"))
+
+	if status != http.StatusBadRequest || resp.Code != errcode.ValidationFailed {
+		t.Fatalf("got status %d and code %s, want %d and %s", status, resp.Code, http.StatusBadRequest, errcode.ValidationFailed)
+	}
+
+	var fields []errcode.FieldError
+	decodeData(t, resp, &fields)
+
+	if len(fields) != 1 || fields[0].Code != errcode.InvalidSite {
+		t.Errorf("got invalid fields %+v, want the site with code %s", fields, errcode.InvalidSite)
+	}
+
+	// the broker rejects the search before it reaches any service
+	if hits := h.Upstreams.PubMed.Hits(); hits != 0 {
+		t.Errorf("pubmed got %d requests, want none", hits)
+	}
+}
+
+func TestSearchStreamsEverySite(t *testing.T) {
+	h := newHarness(t)
+
+	events := h.stream(map[string]any{
+		"action": "search-stream",
+		"search": map[string]any{"keyword": "asthma", "sites_to_search": []string{"nhs", "wiki"}},
+	})
+
+	if len(events) != 3 || events[2].Name != "done" {
+		t.Fatalf("got events %+v, want an entry for each of the 2 sites and done", events)
+	}
+
+	streamed := make(map[string]int)
+
+	for _, e := range events[:2] {
+		var entry struct {
+			Site  string      `json:"site"`
+			Entry searchEntry `json:"entry"`
+		}
+
+		if err := json.Unmarshal(e.Data, &entry); err != nil || e.Name != "entry" {
+			t.Fatalf("got event %s %s, want an entry", e.Name, e.Data)
+		}
+
+		streamed[entry.Site] = len(entry.Entry.Data)
+	}
+
+	if streamed["nhs"] != 2 || streamed["wiki"] != 1 {
+		t.Errorf("got articles by site %v, want 2 from nhs and 1 from wiki", streamed)
+	}
+
+	var done struct {
+		Searched int   `json:"searched"`
+		Failed   []any `json:"failed"`
+	}
+
+	if err := json.Unmarshal(events[2].Data, &done); err != nil {
+		t.Fatalf("could not decode done event %s: %v", events[2].Data, err)
+	}
+
+	if done.Searched != 2 || len(done.Failed) != 0 {
+		t.Errorf("got done event %s, want 2 sites searched and none failed", events[2].Data)
+	}
+}
+
+func TestSearchStreamReportsFailedSites(t *testing.T) {
+	h := newHarness(t)
+
+	// pubmed has no results for the keyword and wikipedia has no page for it
+	events := h.stream(map[string]any{
+		"action": "search-stream",
+		"search": map[string]any{"keyword": "zzxq", "sites_to_search": []string{"pubmed", "wiki"}},
+	})
+
+	if len(events) != 2 || events[0].Name != "entry" || events[1].Name != "done" {
+		t.Fatalf("got events %+v, want the entry of pubmed and done", events)
+	}
+
+	var done struct {
+		Searched int `json:"searched"`
+		Failed   []struct {
+			Site string       `json:"site"`
+			Code errcode.Code `json:"code"`
+		} `json:"failed"`
+	}
+
+	if err := json.Unmarshal(events[1].Data, &done); err != nil {
+		t.Fatalf("could not decode done event %s: %v", events[1].Data, err)
+	}
+
+	if done.Searched != 2 || len(done.Failed) != 1 || done.Failed[0].Site != "wiki" || done.Failed[0].Code != errcode.ArticleNotFound {
+		t.Errorf("got done event %s, want wiki failed with %s", events[1].Data, errcode.ArticleNotFound)
+	}
+}
@@ -0,0 +1,117 @@
+package integration
+
+import (
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// upstreams holds the fakes of the sites and apis that the services call
+type upstreams struct {
+	PubMed       *upstream
+	NHS          *upstream
+	Wikipedia    *upstream
+	PMCOA        *upstream
+	PMCDownloads *upstream
+
+	// links points the links found in the fixtures, which are the ones of the real sites, at the fakes
+	links *strings.Replacer
+}
+
+// upstream is a fake site that serves the fixture of every request it gets, and 404 if there's none
+type upstream struct {
+	*httptest.Server
+
+	// origin is the origin of the real site, which the links in the fixtures point to
+	origin string
+	// fixture returns the path of the fixture for the request, relative to testdata
+	fixture func(r *http.Request) string
+	hits    atomic.Int64
+}
+
+// startUpstreams starts the fake sites, which get closed along with the test
+func startUpstreams(t *testing.T) *upstreams {
+	u := new(upstreams)
+
+	u.PubMed = u.start(t, "https://pubmed.ncbi.nlm.nih.gov", func(r *http.Request) string {
+		// the search page is the root, e.g. /?term=asthma, and every article has a page of its own, e.g. /31245678/
+		if r.URL.Path == "/" {
+			return "pubmed/search/" + r.URL.Query().Get("term") + ".html"
+		}
+
+		return "pubmed/" + strings.Trim(r.URL.Path, "/") + ".html"
+	})
+
+	u.NHS = u.start(t, "https://www.nhs.uk", func(r *http.Request) string {
+		if r.URL.Path == "/search/results" {
+			return "nhs/search/" + r.URL.Query().Get("q") + ".html"
+		}
+
+		return "nhs/" + strings.Trim(r.URL.Path, "/") + ".html"
+	})
+
+	u.Wikipedia = u.start(t, "https://en.wikipedia.org", func(r *http.Request) string {
+		return "wikipedia/" + path.Base(r.URL.Path) + ".json"
+	})
+
+	u.PMCOA = u.start(t, "https://www.ncbi.nlm.nih.gov/pmc/utils/oa", func(r *http.Request) string {
+		return "pmc/oa/" + r.URL.Query().Get("id") + ".xml"
+	})
+
+	// the records of the OA api link to the ftp server of PMC
+	u.PMCDownloads = u.start(t, "ftp://ftp.ncbi.nlm.nih.gov", func(r *http.Request) string {
+		return "pmc/downloads" + r.URL.Path
+	})
+
+	u.links = strings.NewReplacer(
+		u.PubMed.origin, u.PubMed.URL,
+		u.NHS.origin, u.NHS.URL,
+		u.Wikipedia.origin, u.Wikipedia.URL,
+		u.PMCOA.origin, u.PMCOA.URL,
+		u.PMCDownloads.origin, u.PMCDownloads.URL,
+	)
+
+	return u
+}
+
+func (u *upstreams) start(t *testing.T, origin string, fixture func(r *http.Request) string) *upstream {
+	site := &upstream{origin: origin, fixture: fixture}
+
+	site.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		site.hits.Add(1)
+		u.serveFixture(w, r, site.fixture(r))
+	}))
+	t.Cleanup(site.Close)
+
+	return site
+}
+
+// serveFixture writes the fixture in the provided path of testdata, with the links of the text ones pointed at the fakes
+func (u *upstreams) serveFixture(w http.ResponseWriter, r *http.Request, name string) {
+	body, err := os.ReadFile(filepath.Join("testdata", filepath.FromSlash(path.Clean("/"+name))))
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	ext := filepath.Ext(name)
+
+	switch ext {
+	case ".html", ".json", ".xml":
+		body = []byte(u.links.Replace(string(body)))
+	}
+
+	w.Header().Set("Content-Type", mime.TypeByExtension(ext))
+	w.Write(body)
+}
+
+// Hits returns the number of requests the site got
+func (s *upstream) Hits() int {
+	return int(s.hits.Load())
+}
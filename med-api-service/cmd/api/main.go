@@ -1,21 +1,46 @@
 package main
 
 import (
-	"log"
+	"common/logging"
+	"common/tracing"
+	"context"
+	"flag"
+	"log/slog"
+	"med-api-service/server"
 	"net/http"
+	"os"
 )
 
-const webPort = ":80"
+const (
+	serviceName = "med-api-service"
+	webPort     = ":80"
+)
 
 func main() {
+	slog.SetDefault(logging.New(serviceName))
+
+	wikipediaURL := flag.String("wikipediaURL", os.Getenv("WIKIPEDIA_URL"), "Url of the wikipedia summary api that the keyword gets appended to (wikipedia's own when empty)")
+	pmcOAURL := flag.String("pmcOAURL", os.Getenv("PMC_OA_URL"), "Url of the PMC OA api that the pmcid gets appended to (the api of PMC when empty)")
+	traceFile := flag.String("traceFile", os.Getenv("TRACE_FILE"), "File that spans get written to when no OTLP endpoint is set (tracing is disabled when neither is given)")
+
+	flag.Parse()
+
+	shutdownTracing, err := tracing.Setup(context.Background(), serviceName, *traceFile)
+	if err != nil {
+		logging.Fatal("Could not set up tracing", err)
+	}
+
 	srv := &http.Server{
-		Handler: routes(),
+		Handler: server.New(server.Config{WikipediaURL: *wikipediaURL, PMCOAURL: *pmcOAURL}),
 		Addr:    webPort,
 	}
 
-	log.Println("Starting medApiService at port:", webPort)
+	slog.Info("Starting medApiService", "port", webPort)
+
+	err = srv.ListenAndServe()
 
-	err := srv.ListenAndServe()
+	// logging.Fatal exits without running deferred calls, so the spans left get flushed first
+	shutdownTracing(context.Background())
 
-	log.Fatal(err)
+	logging.Fatal("medApiService stopped", err)
 }
@@ -2,28 +2,43 @@ package pdfcollector
 
 import (
 	"archive/tar"
+	"common/errcode"
+	"common/requestid"
+	"common/tracing"
 	"compress/gzip"
+	"context"
 	"encoding/xml"
 	"errors"
+	"fmt"
 	"io"
-	"log"
+	"log/slog"
+	"med-api-service/metrics"
 	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// OAURL is the url of the PMC OA api that the pmcid gets appended to,
+// it can be pointed elsewhere, e.g. at a fake api in tests
+var OAURL = "https://www.ncbi.nlm.nih.gov/pmc/utils/oa/oa.fcgi?id="
+
 // GetPDFByPMCID gets the pdf link from the pubmed pdf api and if successful,
 // returns the pdf in string format or an error if the pdf link retrieval was unsuccessful.
-func GetPDFByPMCID(pmcid string) (string, error) {
-	const baseURL = "https://www.ncbi.nlm.nih.gov/pmc/utils/oa/oa.fcgi?id="
+func GetPDFByPMCID(ctx context.Context, pmcid string) (pdf string, err error) {
+	ctx, span := tracing.Start(ctx, "get pdf", trace.WithAttributes(attribute.String("pdf.pmcid", pmcid)))
+	defer func() { tracing.End(span, err) }()
 
-	finalURL := baseURL + pmcid
+	finalURL := OAURL + pmcid
 
-	response, err := http.Get(finalURL)
+	response, err := get(ctx, finalURL)
 	if err != nil {
-		return "", err
+		return "", errcode.Upstream("the PMC OA api", err)
 	}
 	defer response.Body.Close()
 
@@ -31,16 +46,19 @@ func GetPDFByPMCID(pmcid string) (string, error) {
 
 	err = xml.NewDecoder(response.Body).Decode(data)
 	if err != nil {
-		return "", err
+		return "", errcode.New(errcode.UpstreamFailed, "the PMC OA api sent back a record that could not be read")
 	}
 
 	if data.Error != "" {
-		return "", errors.New("free pdf for provided pmcid could not be retrieved with error: " + data.Error)
+		return "", errcode.New(errcode.PDFNotOpenAccess, "free pdf for provided pmcid could not be retrieved with error: "+data.Error)
 	}
 
 	link, fromGzip := getLinkFromRecords(data.RecordList.Records)
+	if link == "" {
+		return "", errcode.New(errcode.PDFNotOpenAccess, "no free pdf for provided pmcid")
+	}
 
-	return getPDF(link, fromGzip)
+	return getPDF(ctx, link, fromGzip)
 }
 
 // getLinkFromRecords gets the link that has the pdf data from the provided records and
@@ -65,28 +83,39 @@ func getLinkFromRecords(records []Record) (string, bool) {
 // fromGzip needs to be provided to specify whether the
 // link is a gzip link (otherwise a pdf link is assumed),
 // in order to retrieve the pdf appropriatly
-func getPDF(link string, fromGzip bool) (string, error) {
+func getPDF(ctx context.Context, link string, fromGzip bool) (pdf string, err error) {
 	httpsLink := strings.Replace(link, "ftp", "https", 1)
 
-	response, err := http.Get(httpsLink)
+	// the download streams into the conversion, so the span covers both
+	ctx, span := tracing.Start(ctx, "download pdf", trace.WithAttributes(
+		attribute.String("url.full", httpsLink),
+		attribute.Bool("pdf.gzip", fromGzip),
+	))
+	defer func() { tracing.End(span, err) }()
+
+	response, err := get(ctx, httpsLink)
 	if err != nil {
-		return "", err
+		return "", errcode.Upstream("the PMC download server", err)
 	}
 	defer response.Body.Close()
 
+	if response.StatusCode != http.StatusOK {
+		return "", errcode.New(errcode.UpstreamFailed, fmt.Sprintf("the PMC download server responded with status %d", response.StatusCode))
+	}
+
 	if fromGzip {
-		return getPdfFromGzip(response.Body)
+		return getPdfFromGzip(ctx, response.Body)
 	}
 
-	return convertPDFToText(response.Body)
+	return convertPDFToText(ctx, response.Body)
 }
 
 // getPdfFromGzip retrieves the pdf inside of a io.Reader that is
 // a compressed .tar.gz file. It returns the pdf as a string or an error
-func getPdfFromGzip(r io.Reader) (string, error) {
+func getPdfFromGzip(ctx context.Context, r io.Reader) (string, error) {
 	gzipReader, err := gzip.NewReader(r)
 	if err != nil {
-		return "", err
+		return "", errcode.New(errcode.UpstreamFailed, "the archive of the article could not be read")
 	}
 	defer gzipReader.Close()
 
@@ -100,40 +129,109 @@ func getPdfFromGzip(r io.Reader) (string, error) {
 				break
 			}
 
-			return "", err
+			return "", errcode.New(errcode.UpstreamFailed, "the archive of the article could not be read")
 		}
 
 		if filepath.Ext(header.Name) == ".pdf" {
-			return convertPDFToText(tarHeader)
+			return convertPDFToText(ctx, tarHeader)
 		}
 	}
 
-	return "", errors.New("no pdf found in tar.gz file")
+	return "", errcode.New(errcode.PDFNotFound, "no pdf found in tar.gz file")
 }
 
 // convertPDFToText converts the pdf file provided as an io.Reader to string
 // utilizing the Linux 'pdftotext' commandline utility. The function returns the
 // pdf as string and potentially an error.
-func convertPDFToText(r io.Reader) (string, error) {
+func convertPDFToText(ctx context.Context, r io.Reader) (string, error) {
 	f, err := os.CreateTemp(os.TempDir(), "med_api_service*")
 	if err != nil {
-		return "", err
+		return "", errcode.New(errcode.Internal, "the pdf could not be stored for its conversion")
 	}
 
 	cleanupTempFile := func() {
 		f.Close()
 		if err = os.Remove(f.Name()); err != nil {
-			log.Fatal("CRITICAL: Cannot remove temporary file from pdftotext conversion with error:", err)
+			slog.ErrorContext(ctx, "CRITICAL: Cannot remove temporary file from pdftotext conversion", "error", err)
+			os.Exit(1)
 		}
 	}
 	defer cleanupTempFile()
 
 	_, err = io.Copy(f, r)
 	if err != nil {
-		return "", err
+		return "", errcode.New(errcode.UpstreamFailed, "the download of the pdf failed")
+	}
+
+	start := time.Now()
+
+	ctx, span := tracing.Start(ctx, "pdftotext")
+
+	// pdftotext gets killed if ctx ends before it's done
+	data, err := exec.CommandContext(ctx, "pdftotext", "-q", "-nopgbrk", "-enc", "UTF-8", "-eol", "unix", f.Name(), "-").Output()
+
+	tracing.End(span, err)
+
+	metrics.ObservePDFToText(time.Since(start), err)
+
+	slog.InfoContext(ctx, "Ran pdftotext", "latency_ms", time.Since(start).Milliseconds(), "ok", err == nil)
+
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return "", errcode.New(errcode.Timeout, "the deadline passed before the pdf was converted to text")
+	}
+
+	if err != nil {
+		return "", errcode.New(errcode.PDFConversionFailed, "the pdf could not be converted to text")
+	}
+
+	return string(data), nil
+}
+
+// CheckConverter checks that the 'pdftotext' commandline utility that converts the pdfs is installed
+func CheckConverter(ctx context.Context) error {
+	_, err := exec.LookPath("pdftotext")
+
+	return err
+}
+
+// CheckTempDir checks that the pdfs can be stored in the temp dir for their conversion
+func CheckTempDir(ctx context.Context) error {
+	f, err := os.CreateTemp(os.TempDir(), "med_api_service_check*")
+	if err != nil {
+		return err
+	}
+
+	_, err = f.WriteString("ok")
+
+	f.Close()
+
+	if removeErr := os.Remove(f.Name()); err == nil {
+		err = removeErr
+	}
+
+	return err
+}
+
+// get sends a GET request to the provided url, passing on the request id of ctx.
+// The request gets cancelled along with ctx. It's traced until the response headers arrive,
+// the trace context isn't passed on since the pubmed apis are not ours
+func get(ctx context.Context, url string) (*http.Response, error) {
+	ctx, span := tracing.Start(ctx, http.MethodGet+" "+url, trace.WithSpanKind(trace.SpanKindClient))
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		tracing.End(span, err)
+		return nil, err
+	}
+
+	request.Header.Set(requestid.Header, requestid.FromContext(ctx))
+
+	response, err := http.DefaultClient.Do(request)
+	if err == nil {
+		span.SetAttributes(attribute.Int("http.response.status_code", response.StatusCode))
 	}
 
-	data, err := exec.Command("pdftotext", "-q", "-nopgbrk", "-enc", "UTF-8", "-eol", "unix", f.Name(), "-").Output()
+	tracing.End(span, err)
 
-	return string(data), err
+	return response, err
 }
@@ -1,12 +1,19 @@
 package wikicollector
 
 import (
+	"common/errcode"
+	"common/requestid"
+	"context"
 	"encoding/json"
-	"errors"
+	"fmt"
 	"net/http"
 	"net/url"
 )
 
+// SummaryURL is the url of the wikipedia summary api that the keyword gets appended to,
+// it can be pointed elsewhere, e.g. at a fake api in tests
+var SummaryURL = "https://en.wikipedia.org/api/rest_v1/page/summary/"
+
 // WikiData is the struct object that gets returned by GetWikiData
 type WikiData struct {
 	Title   string `json:"title"`
@@ -14,25 +21,37 @@ type WikiData struct {
 }
 
 // GetWikiData returns a WikiData struct if the response from the wiki api with the provided keyword
-// was successful, otherwise returns an error
-func GetWikiData(keyword string) (*WikiData, error) {
-	const wikiSummaryExtractURL = "https://en.wikipedia.org/api/rest_v1/page/summary/"
-
-	finalURL := wikiSummaryExtractURL + url.PathEscape(keyword)
+// was successful, otherwise returns an error. The request gets cancelled along with ctx
+func GetWikiData(ctx context.Context, keyword string) (*WikiData, error) {
+	finalURL := SummaryURL + url.PathEscape(keyword)
 
-	response, err := http.Get(finalURL)
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, finalURL, nil)
 	if err != nil {
 		return nil, err
 	}
+
+	request.Header.Set(requestid.Header, requestid.FromContext(ctx))
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return nil, errcode.Upstream("wikipedia", err)
+	}
 	defer response.Body.Close()
 
+	if response.StatusCode == http.StatusNotFound {
+		return nil, errcode.New(errcode.ArticleNotFound, "no wikipedia article found for "+keyword)
+	}
+
 	if response.StatusCode != http.StatusOK {
-		return nil, errors.New("status not accepted")
+		return nil, errcode.New(errcode.UpstreamFailed, fmt.Sprintf("wikipedia responded with status %d", response.StatusCode))
 	}
 
 	data := new(WikiData)
 
 	err = json.NewDecoder(response.Body).Decode(data)
+	if err != nil {
+		return nil, errcode.New(errcode.UpstreamFailed, "wikipedia sent back a summary that could not be read")
+	}
 
-	return data, err
+	return data, nil
 }
@@ -0,0 +1,44 @@
+package metrics
+
+import (
+	"common/httpmetrics"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// namespace prefixes the names of the metrics of the med-api-service
+const namespace = "medapi"
+
+var (
+	requests = httpmetrics.New(namespace)
+
+	pdftotextDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "pdftotext_duration_seconds",
+		Help:      "Duration of the pdftotext conversions of pdfs, failed ones included.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	})
+
+	pdftotextFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "pdftotext_failures_total",
+		Help:      "pdftotext conversions that failed or were killed.",
+	})
+)
+
+// Middleware is the middleware that counts every request of the service and observes its latency
+func Middleware(next http.Handler) http.Handler {
+	return requests.Middleware(next)
+}
+
+// ObservePDFToText records a pdftotext conversion that took d and failed if err isn't nil
+func ObservePDFToText(d time.Duration, err error) {
+	pdftotextDuration.Observe(d.Seconds())
+
+	if err != nil {
+		pdftotextFailures.Inc()
+	}
+}
@@ -1,9 +1,12 @@
-package main
+package server
 
 import (
+	"common/envelope"
+	"common/errcode"
 	"med-api-service/collectors/pdfcollector"
 	"med-api-service/collectors/wikicollector"
 	"net/http"
+	"strings"
 )
 
 // SearchRequestData is one of the types of payloads that the med-api-service receives.
@@ -12,51 +15,60 @@ type SearchRequestData struct {
 	Keyword string `json:"keyword"`
 }
 
+// Validate checks that the search has a keyword, the title of the article or the PMCID of the pdf
+func (s *SearchRequestData) Validate() []errcode.FieldError {
+	if strings.TrimSpace(s.Keyword) == "" {
+		return []errcode.FieldError{{Field: "keyword", Code: errcode.EmptyKeyword, Message: "must not be empty"}}
+	}
+
+	return nil
+}
+
 // WikiSummary writes to the http.ResponseWriter the requested wiki summary
-// received from the WikiPedia api or writes an errorJSON if a problem was encountered
+// received from the WikiPedia api or writes an error response if a problem was encountered
 func WikiSummary(w http.ResponseWriter, r *http.Request) {
 	var dataSend [1]*wikicollector.WikiData
 
 	search := new(SearchRequestData)
 
-	err := readJSON(w, r, search)
+	err := envelope.ReadJSON(w, r, search)
 	if err != nil {
-		errorJSON(w, err)
+		envelope.ErrorJSON(w, err)
 		return
 	}
 
-	dataSend[0], err = wikicollector.GetWikiData(search.Keyword)
+	dataSend[0], err = wikicollector.GetWikiData(r.Context(), search.Keyword)
 	if err != nil {
-		errorJSON(w, err)
+		envelope.ErrorJSON(w, err)
 		return
 	}
 
 	// Sending an array because search-service expects an array of json objects
-	writeJSON(w, http.StatusAccepted, dataSend)
+	envelope.WriteJSON(w, http.StatusOK, dataSend)
 }
 
 // CollectPDF gets the pdf according to the provided PMCID from the SearchRequestData payload
-// and writes the found pdf as a string to the htpp.ResponseWriter or an errorJSON if an error was encountered
+// and writes the found pdf as a string to the htpp.ResponseWriter or an error response if an error was encountered
 func CollectPDF(w http.ResponseWriter, r *http.Request) {
 	pmid := new(SearchRequestData)
 
-	err := readJSON(w, r, pmid)
+	err := envelope.ReadJSON(w, r, pmid)
 	if err != nil {
-		errorJSON(w, err)
+		envelope.ErrorJSON(w, err)
 		return
 	}
 
-	pdf, err := pdfcollector.GetPDFByPMCID(pmid.Keyword)
+	pdf, err := pdfcollector.GetPDFByPMCID(r.Context(), pmid.Keyword)
 	if err != nil {
-		errorJSON(w, err)
+		envelope.ErrorJSON(w, err)
 		return
 	}
 
-	data := jsonResponse{
+	data := envelope.Response{
 		Error:   false,
 		Message: "PDF retrieval was successful",
 		Data:    pdf,
 	}
 
-	writeJSON(w, http.StatusAccepted, data)
+	envelope.WriteJSON(w, http.StatusOK, data)
 }
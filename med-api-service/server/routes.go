@@ -0,0 +1,66 @@
+package server
+
+import (
+	"common/deadline"
+	"common/health"
+	"common/httpmetrics"
+	"common/httpmw"
+	"common/requestid"
+	"common/tracing"
+	"med-api-service/collectors/pdfcollector"
+	"med-api-service/collectors/wikicollector"
+	"med-api-service/metrics"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+)
+
+// readyTimeout is how long the readiness checks of the dependencies may take
+const readyTimeout = 2 * time.Second
+
+// Config is the configuration of the service
+type Config struct {
+	// WikipediaURL is the url of the summary api that the keyword gets appended to, wikipedia's own is used when it's empty
+	WikipediaURL string
+	// PMCOAURL is the url of the PMC OA api that the pmcid gets appended to, the api of PMC is used when it's empty
+	PMCOAURL string
+}
+
+// New points the collectors at the apis of the config and returns the handler of the service
+func New(config Config) http.Handler {
+	if config.WikipediaURL != "" {
+		wikicollector.SummaryURL = config.WikipediaURL
+	}
+
+	if config.PMCOAURL != "" {
+		pdfcollector.OAURL = config.PMCOAURL
+	}
+
+	return routes()
+}
+
+func routes() http.Handler {
+	mux := chi.NewRouter()
+
+	checker := health.New(readyTimeout)
+	checker.Add("pdftotext", pdfcollector.CheckConverter)
+	checker.Add("temp_dir", pdfcollector.CheckTempDir)
+
+	mux.Use(middleware.Heartbeat("/ping"))
+	mux.Use(checker.Middleware)
+	mux.Use(requestid.Middleware)
+	mux.Use(deadline.Middleware)
+	mux.Use(tracing.Middleware)
+	mux.Use(metrics.Middleware)
+	mux.Use(httpmw.LogRequests)
+	mux.Use(httpmw.Recover)
+
+	mux.Method(http.MethodGet, "/metrics", httpmetrics.Handler())
+
+	mux.Post("/wiki-summary", WikiSummary)
+	mux.Post("/collect-pdf", CollectPDF)
+
+	return mux
+}
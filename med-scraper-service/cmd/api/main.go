@@ -1,23 +1,46 @@
 package main
 
 import (
-	"log"
+	"common/logging"
+	"common/tracing"
+	"context"
+	"flag"
+	"log/slog"
+	"med-scraper-service/server"
 	"net/http"
+	"os"
 )
 
 const (
-	webPort = ":80"
+	serviceName = "med-scraper-service"
+	webPort     = ":80"
 )
 
 func main() {
+	slog.SetDefault(logging.New(serviceName))
+
+	pubMedURL := flag.String("pubmedURL", os.Getenv("PUBMED_URL"), "Search url of pubmed that the keyword gets appended to (pubmed itself when empty)")
+	nhsURL := flag.String("nhsURL", os.Getenv("NHS_URL"), "Search url of the nhs site that the keyword gets appended to (the nhs site itself when empty)")
+	traceFile := flag.String("traceFile", os.Getenv("TRACE_FILE"), "File that spans get written to when no OTLP endpoint is set (tracing is disabled when neither is given)")
+
+	flag.Parse()
+
+	shutdownTracing, err := tracing.Setup(context.Background(), serviceName, *traceFile)
+	if err != nil {
+		logging.Fatal("Could not set up tracing", err)
+	}
+
 	srv := &http.Server{
 		Addr:    webPort,
-		Handler: routes(),
+		Handler: server.New(server.Config{PubMedURL: *pubMedURL, NHSURL: *nhsURL}),
 	}
 
-	log.Println("Starting medScraperService at port", webPort)
+	slog.Info("Starting medScraperService", "port", webPort)
+
+	err = srv.ListenAndServe()
 
-	err := srv.ListenAndServe()
+	// logging.Fatal exits without running deferred calls, so the spans left get flushed first
+	shutdownTracing(context.Background())
 
-	log.Fatal(err)
+	logging.Fatal("medScraperService stopped", err)
 }
@@ -1,18 +0,0 @@
-package main
-
-import (
-	"net/http"
-
-	"github.com/go-chi/chi"
-	"github.com/go-chi/chi/middleware"
-)
-
-func routes() http.Handler {
-	mux := chi.NewRouter()
-
-	mux.Use(middleware.Heartbeat("/ping"))
-
-	mux.Post("/scrape", Scrape)
-
-	return mux
-}
@@ -0,0 +1,48 @@
+package metrics
+
+import (
+	"common/httpmetrics"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// namespace prefixes the names of the metrics of the med-scraper-service
+const namespace = "scraper"
+
+var (
+	requests = httpmetrics.New(namespace)
+
+	scrapeDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "scrape_duration_seconds",
+		Help:      "Duration of the scrapes of a keyword, by site and outcome (ok or error).",
+		Buckets:   []float64{.25, .5, 1, 2.5, 5, 10, 20, 30, 60, 120},
+	}, []string{"site", "outcome"})
+
+	articles = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "scrape_articles",
+		Help:      "Articles collected by the successful scrapes of a keyword, by site.",
+		Buckets:   []float64{0, 1, 2, 5, 10, 20, 50},
+	}, []string{"site"})
+)
+
+// Middleware is the middleware that counts every request of the service and observes its latency
+func Middleware(next http.Handler) http.Handler {
+	return requests.Middleware(next)
+}
+
+// ObserveScrape records a scrape of the site that took d and collected the provided number of articles,
+// or failed with err
+func ObserveScrape(site string, d time.Duration, collected int, err error) {
+	if err != nil {
+		scrapeDuration.WithLabelValues(site, "error").Observe(d.Seconds())
+		return
+	}
+
+	scrapeDuration.WithLabelValues(site, "ok").Observe(d.Seconds())
+	articles.WithLabelValues(site).Observe(float64(collected))
+}
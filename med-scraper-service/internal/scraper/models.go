@@ -3,6 +3,7 @@ package scraper
 // NHSArticle holds the nhs article data
 type NHSArticle struct {
 	StandardArticleInfo
+	Link string `json:"link,omitempty"`
 	Text string `json:"text"`
 }
 
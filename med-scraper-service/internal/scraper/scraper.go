@@ -1,40 +1,60 @@
 package scraper
 
 import (
+	"common/errcode"
+	"common/requestid"
+	"common/tracing"
+	"context"
 	"errors"
-	"log"
+	"log/slog"
+	"med-scraper-service/internal/metrics"
 	"med-scraper-service/internal/nlp"
 	"med-scraper-service/internal/sanitizer"
 	"med-scraper-service/internal/sites"
+	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gocolly/colly"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
 	UserAgent = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/110.0.0.0 Safari/537.36"
 
+	StandardKeywordLen = 5
+)
+
+// The search urls of the sites that the keyword gets appended to,
+// they can be pointed elsewhere, e.g. at a mirror or at a fake site in tests
+var (
 	PubURL = "https://pubmed.ncbi.nlm.nih.gov/?term="
 	NhsURL = "https://www.nhs.uk/search/results?q="
-
-	StandardKeywordLen = 5
 )
 
 type scraper struct {
+	ctx          context.Context
+	site         string
 	url          string
 	searchColly  *colly.Collector
 	articleColly *colly.Collector
-	articles     []any
+	// mu guards articles, which the async article collectors append to
+	mu       sync.Mutex
+	articles []any
 }
 
-// New returns a scraper and initializes it according to the site provided
-func New(keyword, site string) (*scraper, error) {
+// New returns a scraper and initializes it according to the site provided.
+// The request id of ctx gets logged and passed on with every visit of the scraper
+func New(ctx context.Context, keyword, site string) (*scraper, error) {
 	const articlesPerPage = 10
 
 	var finalURL string
 
-	s := new(scraper)
+	s := &scraper{ctx: ctx, site: site}
 
 	switch site {
 	case sites.PubMed:
@@ -44,7 +64,7 @@ func New(keyword, site string) (*scraper, error) {
 		finalURL = NhsURL
 		s.initNhsScrapers()
 	default:
-		return nil, errors.New("site provided not valid")
+		return nil, errcode.New(errcode.InvalidSite, "site provided not valid")
 	}
 
 	s.url = finalURL + url.QueryEscape(keyword)
@@ -55,19 +75,102 @@ func New(keyword, site string) (*scraper, error) {
 }
 
 // GetData starts the scraper with the keyword and url
-// stores the data collected in articles
+// stores the data collected in articles.
+// It fails if ctx ends before the scraping is done
 func (s *scraper) GetData() ([]any, error) {
+	start := time.Now()
+
+	// the visits of the collectors are traced as children of the scrape's span
+	ctx, span := tracing.Start(s.ctx, "scrape "+s.site, trace.WithAttributes(attribute.String("scraper.site", s.site)))
+	s.ctx = ctx
+
+	articles, err := s.scrape()
+
+	span.SetAttributes(attribute.Int("scraper.articles", len(articles)))
+	tracing.End(span, err)
+
+	metrics.ObserveScrape(s.site, time.Since(start), len(articles), err)
+
+	return articles, err
+}
+
+// scrape visits the search url and waits for the collectors to be done, see GetData
+func (s *scraper) scrape() ([]any, error) {
 	err := s.searchColly.Visit(s.url)
 	if err != nil {
-		return nil, err
+		return nil, errcode.New(errcode.ScrapeFailed, "could not scrape "+s.site+": "+err.Error())
 	}
 
 	s.searchColly.Wait()
 	s.articleColly.Wait()
 
+	if errors.Is(s.ctx.Err(), context.DeadlineExceeded) {
+		return nil, errcode.New(errcode.Timeout, "the deadline passed before "+s.site+" was scraped")
+	}
+
+	if err = s.ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	return s.articles, nil
 }
 
+// addArticle adds an article collected by one of the article collectors
+func (s *scraper) addArticle(article any) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.articles = append(s.articles, article)
+}
+
+// bindContext ties the visits of a collector to the scraper's context: visits in flight get
+// cancelled along with it, and visits that were queued or found afterwards don't start.
+// Every visit gets traced in a span with the given name
+func (s *scraper) bindContext(c *colly.Collector, visit string) {
+	c.WithTransport(&contextTransport{scraper: s, visit: visit, base: http.DefaultTransport})
+
+	c.OnRequest(func(r *colly.Request) {
+		if s.ctx.Err() != nil {
+			r.Abort()
+		}
+	})
+}
+
+// contextTransport is a http.RoundTripper that sends every request with the context of its scraper,
+// since colly doesn't take a context of its own
+type contextTransport struct {
+	scraper *scraper
+	visit   string
+	base    http.RoundTripper
+}
+
+// RoundTrip sends the request with the context of the scraper, in a span of its own.
+// The trace context isn't passed on, since the visited sites are not ours
+func (t *contextTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	ctx, span := tracing.Start(t.scraper.ctx, t.visit, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("url.full", r.URL.String()),
+	))
+
+	response, err := t.base.RoundTrip(r.WithContext(ctx))
+
+	// failed statuses only mark the span, colly handles the response itself
+	spanErr := err
+	if err == nil {
+		span.SetAttributes(attribute.Int("http.response.status_code", response.StatusCode))
+
+		if response.StatusCode >= http.StatusBadRequest {
+			spanErr = errors.New("visit failed with status " + strconv.Itoa(response.StatusCode))
+		}
+	}
+
+	tracing.End(span, spanErr)
+
+	return response, err
+}
+
 // initNhsScrapers initializes scrapers for nhs
 func (s *scraper) initNhsScrapers() {
 	searchColly := colly.NewCollector(
@@ -75,18 +178,21 @@ func (s *scraper) initNhsScrapers() {
 		colly.UserAgent(UserAgent),
 	)
 
+	s.bindContext(searchColly, "visit search page")
+
 	articleColly := s.newNhsArticleScraper()
 
 	searchColly.OnRequest(func(r *colly.Request) {
-		log.Println("Visiting url for search:", r.URL.String())
+		r.Headers.Set(requestid.Header, requestid.FromContext(s.ctx))
+		slog.InfoContext(s.ctx, "Visiting url for search", "url", r.URL.String())
 	})
 
 	searchColly.OnError(func(r *colly.Response, err error) {
-		log.Printf("Error: %s with status code: %d\n", err.Error(), r.StatusCode)
+		slog.WarnContext(s.ctx, "Visit failed", "url", r.Request.URL.String(), "status", r.StatusCode, "error", err)
 	})
 
 	searchColly.OnResponse(func(r *colly.Response) {
-		log.Printf("Connection to %s successful with status code: %d\n", r.Request.AbsoluteURL(r.Request.URL.Path), r.StatusCode)
+		slog.DebugContext(s.ctx, "Visit successful", "url", r.Request.AbsoluteURL(r.Request.URL.Path), "status", r.StatusCode)
 	})
 
 	searchColly.OnHTML(".nhsuk-list", func(h *colly.HTMLElement) {
@@ -101,7 +207,7 @@ func (s *scraper) initNhsScrapers() {
 
 			err := articleColly.Visit(link)
 			if err != nil {
-				log.Printf("can't visit link: %s with error %s\n", link, err.Error())
+				slog.WarnContext(s.ctx, "Can't visit link", "url", link, "error", err)
 			}
 		})
 
@@ -119,16 +225,19 @@ func (s *scraper) newNhsArticleScraper() *colly.Collector {
 		colly.UserAgent(UserAgent),
 	)
 
+	s.bindContext(articleColly, "visit article")
+
 	articleColly.OnRequest(func(r *colly.Request) {
-		log.Println("Visiting url for article collection:", r.URL.String())
+		r.Headers.Set(requestid.Header, requestid.FromContext(s.ctx))
+		slog.InfoContext(s.ctx, "Visiting url for article collection", "url", r.URL.String())
 	})
 
 	articleColly.OnError(func(r *colly.Response, err error) {
-		log.Printf("Error: %s with status code: %d\n", err.Error(), r.StatusCode)
+		slog.WarnContext(s.ctx, "Visit failed", "url", r.Request.URL.String(), "status", r.StatusCode, "error", err)
 	})
 
 	articleColly.OnResponse(func(r *colly.Response) {
-		log.Printf("Connection to %s successful with status code: %d\n", r.Request.AbsoluteURL(r.Request.URL.Path), r.StatusCode)
+		slog.DebugContext(s.ctx, "Visit successful", "url", r.Request.AbsoluteURL(r.Request.URL.Path), "status", r.StatusCode)
 	})
 
 	articleColly.OnHTML("body", func(h *colly.HTMLElement) {
@@ -152,10 +261,11 @@ func (s *scraper) newNhsArticleScraper() *colly.Collector {
 				Summary:  summary,
 				Keywords: keywords,
 			},
+			Link: h.Request.URL.String(),
 			Text: text,
 		}
 
-		s.articles = append(s.articles, article)
+		s.addArticle(article)
 	})
 
 	return articleColly
@@ -168,18 +278,21 @@ func (s *scraper) initPubMedScrapers() {
 		colly.UserAgent(UserAgent),
 	)
 
+	s.bindContext(searchColly, "visit search page")
+
 	articleColly := s.newPubArticleCollector()
 
 	searchColly.OnRequest(func(r *colly.Request) {
-		log.Println("Visiting url for search:", r.URL.String())
+		r.Headers.Set(requestid.Header, requestid.FromContext(s.ctx))
+		slog.InfoContext(s.ctx, "Visiting url for search", "url", r.URL.String())
 	})
 
 	searchColly.OnError(func(r *colly.Response, err error) {
-		log.Printf("Error: %s with status code: %d\n", err.Error(), r.StatusCode)
+		slog.WarnContext(s.ctx, "Visit failed", "url", r.Request.URL.String(), "status", r.StatusCode, "error", err)
 	})
 
 	searchColly.OnResponse(func(r *colly.Response) {
-		log.Printf("Connection to %s successful with status code: %d\n", r.Request.AbsoluteURL(r.Request.URL.Path), r.StatusCode)
+		slog.DebugContext(s.ctx, "Visit successful", "url", r.Request.AbsoluteURL(r.Request.URL.Path), "status", r.StatusCode)
 	})
 
 	searchColly.OnHTML(".docsum-title", func(h *colly.HTMLElement) {
@@ -188,7 +301,7 @@ func (s *scraper) initPubMedScrapers() {
 
 		err := articleColly.Visit(link)
 		if err != nil {
-			log.Printf("can't visit link: %s with error %s\n", link, err.Error())
+			slog.WarnContext(s.ctx, "Can't visit link", "url", link, "error", err)
 		}
 	})
 
@@ -205,16 +318,19 @@ func (s *scraper) newPubArticleCollector() *colly.Collector {
 		colly.UserAgent(UserAgent),
 	)
 
+	s.bindContext(articleColly, "visit article")
+
 	articleColly.OnRequest(func(r *colly.Request) {
-		log.Println("Visiting url to find data:", r.URL.String())
+		r.Headers.Set(requestid.Header, requestid.FromContext(s.ctx))
+		slog.InfoContext(s.ctx, "Visiting url to find data", "url", r.URL.String())
 	})
 
 	articleColly.OnError(func(r *colly.Response, err error) {
-		log.Printf("Error: %s with status code: %d\n", err.Error(), r.StatusCode)
+		slog.WarnContext(s.ctx, "Visit failed", "url", r.Request.URL.String(), "status", r.StatusCode, "error", err)
 	})
 
 	articleColly.OnResponse(func(r *colly.Response) {
-		log.Printf("Connection to %s successful with status code: %d\n", r.Request.AbsoluteURL(r.Request.URL.Path), r.StatusCode)
+		slog.DebugContext(s.ctx, "Visit successful", "url", r.Request.AbsoluteURL(r.Request.URL.Path), "status", r.StatusCode)
 	})
 
 	articleColly.OnHTML(".article-details", func(h *colly.HTMLElement) {
@@ -267,7 +383,7 @@ func (s *scraper) newPubArticleCollector() *colly.Collector {
 			Authors:  authors,
 		}
 
-		s.articles = append(s.articles, article)
+		s.addArticle(article)
 	})
 
 	return articleColly
@@ -0,0 +1,68 @@
+package server
+
+import (
+	"common/envelope"
+	"common/errcode"
+	"log/slog"
+	"med-scraper-service/internal/scraper"
+	"med-scraper-service/internal/sites"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// SearchRequest specifies the search that gets requested
+type SearchRequest struct {
+	Keyword string `json:"keyword"`
+	Site    string `json:"site"`
+}
+
+// Validate checks that the search has a keyword and a site that can be scraped
+func (s *SearchRequest) Validate() []errcode.FieldError {
+	var fields []errcode.FieldError
+
+	if strings.TrimSpace(s.Keyword) == "" {
+		fields = append(fields, errcode.FieldError{Field: "keyword", Code: errcode.EmptyKeyword, Message: "must not be empty"})
+	}
+
+	if s.Site != sites.PubMed && s.Site != sites.NHS {
+		fields = append(fields, errcode.FieldError{Field: "site", Code: errcode.InvalidSite, Message: "must be one of " + sites.PubMed + ", " + sites.NHS})
+	}
+
+	return fields
+}
+
+// Scrape scrapes provided site for provided keyword and responds with the collected data or an error
+func Scrape(w http.ResponseWriter, r *http.Request) {
+	searchRequest := new(SearchRequest)
+
+	err := envelope.ReadJSON(w, r, searchRequest)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	start := time.Now()
+
+	scraper, err := scraper.New(r.Context(), searchRequest.Keyword, searchRequest.Site)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	data, err := scraper.GetData()
+	if err != nil {
+		slog.WarnContext(r.Context(), "Scrape failed", "site", searchRequest.Site, "keyword", searchRequest.Keyword, "error", err)
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	slog.InfoContext(r.Context(), "Site scraped",
+		"site", searchRequest.Site,
+		"keyword", searchRequest.Keyword,
+		"articles", len(data),
+		"latency_ms", time.Since(start).Milliseconds(),
+	)
+
+	envelope.WriteJSON(w, http.StatusOK, data)
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"common/deadline"
+	"common/health"
+	"common/httpmetrics"
+	"common/httpmw"
+	"common/requestid"
+	"common/tracing"
+	"med-scraper-service/internal/metrics"
+	"med-scraper-service/internal/scraper"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+)
+
+// readyTimeout is how long the readiness checks of the dependencies may take
+const readyTimeout = 2 * time.Second
+
+// Config is the configuration of the service
+type Config struct {
+	// PubMedURL is the search url of pubmed that the keyword gets appended to, pubmed itself is scraped when it's empty
+	PubMedURL string
+	// NHSURL is the search url of the nhs site that the keyword gets appended to, the nhs site itself is scraped when it's empty
+	NHSURL string
+}
+
+// New points the scrapers at the sites of the config and returns the handler of the service
+func New(config Config) http.Handler {
+	if config.PubMedURL != "" {
+		scraper.PubURL = config.PubMedURL
+	}
+
+	if config.NHSURL != "" {
+		scraper.NhsURL = config.NHSURL
+	}
+
+	return routes()
+}
+
+func routes() http.Handler {
+	mux := chi.NewRouter()
+
+	// the service has no dependencies to check, the sites it scrapes are only known to be up once they're scraped
+	checker := health.New(readyTimeout)
+
+	mux.Use(middleware.Heartbeat("/ping"))
+	mux.Use(checker.Middleware)
+	mux.Use(requestid.Middleware)
+	mux.Use(deadline.Middleware)
+	mux.Use(tracing.Middleware)
+	mux.Use(metrics.Middleware)
+	mux.Use(httpmw.LogRequests)
+	mux.Use(httpmw.Recover)
+
+	mux.Method(http.MethodGet, "/metrics", httpmetrics.Handler())
+
+	mux.Post("/scrape", Scrape)
+
+	return mux
+}
@@ -1,8 +1,9 @@
 from flask import jsonify, Response
 
-def error_json(message: str) -> Response:
+def error_json(message: str, code: str = 'BAD_REQUEST') -> Response:
     err =  {
         'error': True,
+        'code': code,
         'message': message
     }
 
@@ -15,7 +15,7 @@ def process_request():
     try:
         nlp_proccess = data['process']
     except:
-        return error_json('Cannot retrieve parameter proccess from json')
+        return error_json('Cannot retrieve parameter proccess from json', 'INVALID_PROCESS')
 
 
     match nlp_proccess:
@@ -24,10 +24,19 @@ def process_request():
         case 'simplify':
             process = simplifier.simplify
         case _:
-            return error_json(f'Unrecognized proccess: {nlp_proccess}')
+            return error_json(f'Unrecognized proccess: {nlp_proccess}', 'INVALID_PROCESS')
 
+    text = ''
     try:
-        desired_text = process(data['text'])
+        text = data['text']
+    except:
+        return error_json('Cannot retrieve parameter text from json', 'BAD_REQUEST')
+
+    if not isinstance(text, str):
+        return error_json('Parameter text must be a string', 'BAD_REQUEST')
+
+    try:
+        desired_text = process(text)
 
         response_data = {
             'error': False,
@@ -37,9 +46,25 @@ def process_request():
         return jsonify(response_data)
         
     except Exception as e:
-        return error_json(str(e))
+        return error_json(str(e), 'NLP_FAILED')
     
 
 
+@app.get('/ping')
+def ping():
+    return '.'
+
+
+@app.get('/healthz')
+def healthz():
+    return jsonify({'error': False, 'message': 'Service is alive'})
+
+
+@app.get('/readyz')
+def readyz():
+    # the models get loaded before the service starts listening, so it's ready as soon as it answers
+    return jsonify({'error': False, 'message': 'Service is ready', 'data': {'ready': True, 'dependencies': {}}})
+
+
 if __name__ == '__main__':
     app.run(debug=True, host='0.0.0.0', port=80)
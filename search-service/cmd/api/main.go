@@ -1,11 +1,17 @@
 package main
 
 import (
+	"common/logging"
+	"common/tracing"
 	"context"
+	"errors"
 	"flag"
-	"log"
+	"log/slog"
 	"net/http"
-	"search-service/internal/data"
+	"os"
+	"os/signal"
+	"search-service/server"
+	"syscall"
 	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
@@ -13,9 +19,10 @@ import (
 )
 
 const (
-	webPort    = ":80"
-	mongoURL   = "mongodb://mongo:27017"
-	ctxTimeOut = 15 * time.Second
+	serviceName = "search-service"
+	webPort     = ":80"
+	mongoURL    = "mongodb://mongo:27017"
+	ctxTimeOut  = 15 * time.Second
 )
 
 var client *mongo.Client
@@ -23,47 +30,114 @@ var client *mongo.Client
 func main() {
 	var err error
 
+	slog.SetDefault(logging.New(serviceName))
+
 	// Service flags
 	mongoUsername := flag.String("mongoUsername", "", "MongoDB user")
 	mongoPassword := flag.String("mongoPassword", "", "MongoDB user password")
+	jobWorkers := flag.Int("jobWorkers", 4, "Number of jobs that run at the same time")
+	jobQueue := flag.Int("jobQueue", 100, "Number of jobs that can wait for a worker before new ones get rejected")
+	jobTimeout := flag.Duration("jobTimeout", 5*time.Minute, "How long a job may run before it fails")
+	webhookSecret := flag.String("webhookSecret", os.Getenv("WEBHOOK_SECRET"), "Secret that signs webhook notifications (webhooks are disabled when empty)")
+	webhookAttempts := flag.Int("webhookAttempts", 5, "Delivery attempts of a webhook notification before it becomes a dead letter")
+	webhookRetryDelay := flag.Duration("webhookRetryDelay", 2*time.Second, "Delay before the first retry of a webhook delivery, doubled for every next one")
+	webhookAllowPrivate := flag.Bool("webhookAllowPrivate", false, "Let callback urls reach loopback and private addresses (only for local setups)")
+	scraperURL := flag.String("scraperURL", os.Getenv("SCRAPER_URL"), "Base url of med-scraper-service (http://med-scraper-service when empty)")
+	medAPIURL := flag.String("medAPIURL", os.Getenv("MED_API_URL"), "Base url of med-api-service (http://med-api-service when empty)")
+	traceFile := flag.String("traceFile", os.Getenv("TRACE_FILE"), "File that spans get written to when no OTLP endpoint is set (tracing is disabled when neither is given)")
 
 	flag.Parse()
 
 	if *mongoUsername == "" || *mongoPassword == "" {
-		log.Fatal("MongoDB username or password cannot be empty. Exiting...")
+		logging.Fatal("Could not start", errors.New("MongoDB username or password cannot be empty"))
+	}
+
+	shutdownTracing, err := tracing.Setup(context.Background(), serviceName, *traceFile)
+	if err != nil {
+		logging.Fatal("Could not set up tracing", err)
 	}
 
 	// connecting to mongo
 	client, err = connectToMongo(*mongoUsername, *mongoPassword)
 	if err != nil {
-		log.Fatal(err)
+		logging.Fatal("Could not connect to mongo", err)
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeOut)
 	defer cancel()
 
 	closeMongoDBConn := func() {
+		// the context of the setup may have run out by the time the service stops
+		ctx, cancel := context.WithTimeout(context.Background(), ctxTimeOut)
+		defer cancel()
+
 		if err = client.Disconnect(ctx); err != nil {
-			log.Fatal("Error disconnecting from mongo:", err)
+			logging.Fatal("Error disconnecting from mongo", err)
 		}
 	}
 
 	defer closeMongoDBConn()
 
-	// Giving the mongodb connection to the data package
-	data.NewConn(client)
+	handler, err := server.New(ctx, server.Config{
+		Mongo:               client,
+		JobWorkers:          *jobWorkers,
+		JobQueue:            *jobQueue,
+		JobTimeout:          *jobTimeout,
+		WebhookSecret:       *webhookSecret,
+		WebhookAttempts:     *webhookAttempts,
+		WebhookRetryDelay:   *webhookRetryDelay,
+		WebhookAllowPrivate: *webhookAllowPrivate,
+		ScraperURL:          *scraperURL,
+		MedAPIURL:           *medAPIURL,
+	})
+	if err != nil {
+		logging.Fatal("Could not set up the service", err)
+	}
 
 	// starting web server
 	srv := &http.Server{
 		Addr:    webPort,
-		Handler: routes(),
+		Handler: handler,
 	}
 
-	log.Println("Starting SearchService on port", webPort)
+	// on SIGINT or SIGTERM the service stops taking requests and lets the ones in flight finish
+	stopped := make(chan struct{})
+
+	go func() {
+		defer close(stopped)
+
+		signals, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		<-signals.Done()
+
+		ctx, cancel := context.WithTimeout(context.Background(), ctxTimeOut)
+		defer cancel()
+
+		if err := srv.Shutdown(ctx); err != nil {
+			slog.Error("Could not shut down gracefully", "error", err)
+		}
+	}()
+
+	slog.Info("Starting SearchService", "port", webPort)
 
 	err = srv.ListenAndServe()
+	if errors.Is(err, http.ErrServerClosed) {
+		<-stopped
+	}
+
+	// the webhook notifications left undelivered are stored as dead letters while mongo is still connected
+	server.Close()
+
+	// logging.Fatal exits without running deferred calls, so the spans left get flushed first
+	shutdownTracing(context.Background())
+
+	if errors.Is(err, http.ErrServerClosed) {
+		slog.Info("SearchService stopped")
+		return
+	}
 
-	log.Fatal(err)
+	logging.Fatal("SearchService stopped", err)
 }
 
 // connectToMongo establishes a mongodb connvetion
@@ -79,7 +153,7 @@ func connectToMongo(username, password string) (*mongo.Client, error) {
 	// connect
 	conn, err := mongo.Connect(context.TODO(), clientOptions)
 	if err != nil {
-		log.Println("Error connecting to mongo:", err)
+		slog.Error("Error connecting to mongo", "error", err)
 		return nil, err
 	}
 
@@ -2,11 +2,27 @@ package caller
 
 import (
 	"bytes"
+	"common/deadline"
+	"common/envelope"
+	"common/errcode"
+	"common/requestid"
+	"common/tracing"
+	"context"
 	"encoding/json"
-	"errors"
+	"fmt"
 	"net/http"
 	"search-service/internal/models"
 	"search-service/internal/sites"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// The base urls of the services that search-service calls,
+// they can be pointed elsewhere, e.g. at services started by tests
+var (
+	ScraperURL = "http://med-scraper-service"
+	MedAPIURL  = "http://med-api-service"
 )
 
 // searchRequest is the object sent to the microservices when requesting a search
@@ -18,7 +34,13 @@ type searchRequest struct {
 
 // RequestSearchEntry requests a SearchEntry data from the given service url
 // and returns a SearchEntry or potentially an error
-func RequestSearchEntry(keyword, site string) (*models.SearchEntry, error) {
+func RequestSearchEntry(ctx context.Context, keyword, site string) (result *models.SearchEntry, err error) {
+	ctx, span := tracing.Start(ctx, "request search entry", trace.WithAttributes(
+		attribute.String("search.site", site),
+		attribute.String("search.keyword", keyword),
+	))
+	defer func() { tracing.End(span, err) }()
+
 	searchURL, err := getUrlForSite(site)
 	if err != nil {
 		return nil, err
@@ -31,30 +53,36 @@ func RequestSearchEntry(keyword, site string) (*models.SearchEntry, error) {
 
 	bodyBytes, _ := json.Marshal(body)
 
-	response, err := http.Post(searchURL, "application/json", bytes.NewBuffer(bodyBytes))
+	response, err := post(ctx, searchURL, bodyBytes)
 	if err != nil {
 		return nil, err
 	}
 	defer response.Body.Close()
 
-	if response.StatusCode != http.StatusAccepted {
-		return nil, errors.New("status not accepted calling service")
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		return nil, serviceError(response)
 	}
 
-	result := &models.SearchEntry{
+	result = &models.SearchEntry{
 		Keyword: keyword,
 		Origin:  site,
 	}
 
 	err = json.NewDecoder(response.Body).Decode(&result.Data)
+	if err != nil {
+		return nil, errcode.New(errcode.UpstreamFailed, "service "+response.Request.URL.Host+" sent back articles that could not be read")
+	}
 
-	return result, err
+	span.SetAttributes(attribute.Int("search.articles", len(result.Data)))
+
+	return result, nil
 }
 
 // RequestPDFEntry requests a pdf from the pdf service and
 // returns a PDFEntry or potentially an error
-func RequestPDFEntry(pmid string) (*models.PDFEntry, error) {
-	const pdfCollectURL = "http://med-api-service/collect-pdf"
+func RequestPDFEntry(ctx context.Context, pmid string) (result *models.PDFEntry, err error) {
+	ctx, span := tracing.Start(ctx, "request pdf entry", trace.WithAttributes(attribute.String("search.pmcid", pmid)))
+	defer func() { tracing.End(span, err) }()
 
 	body := searchRequest{
 		Keyword: pmid,
@@ -62,29 +90,29 @@ func RequestPDFEntry(pmid string) (*models.PDFEntry, error) {
 
 	bodyBytes, _ := json.Marshal(body)
 
-	response, err := http.Post(pdfCollectURL, "application/json", bytes.NewBuffer(bodyBytes))
+	response, err := post(ctx, MedAPIURL+"/collect-pdf", bodyBytes)
 	if err != nil {
 		return nil, err
 	}
 	defer response.Body.Close()
 
-	if response.StatusCode != http.StatusAccepted {
-		return nil, errors.New("status not accepted calling service")
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		return nil, serviceError(response)
 	}
 
-	data := new(models.JsonResponse)
+	data := new(envelope.Response)
 
 	err = json.NewDecoder(response.Body).Decode(data)
 	if err != nil {
-		return nil, err
+		return nil, errcode.New(errcode.UpstreamFailed, "service "+response.Request.URL.Host+" sent back a response that could not be read")
 	}
 
 	pdf, ok := data.Data.(string)
 	if !ok {
-		return nil, errors.New("can't convert pdf to text from json response")
+		return nil, errcode.New(errcode.UpstreamFailed, "can't convert pdf to text from json response")
 	}
 
-	result := &models.PDFEntry{
+	result = &models.PDFEntry{
 		PMID:    pmid,
 		PDFText: pdf,
 	}
@@ -92,19 +120,67 @@ func RequestPDFEntry(pmid string) (*models.PDFEntry, error) {
 	return result, nil
 }
 
+// post sends the json body to the provided url, passing on the request id, the deadline and the trace of ctx.
+// The request gets cancelled along with ctx
+func post(ctx context.Context, url string, body []byte) (*http.Response, error) {
+	ctx, span := tracing.Start(ctx, http.MethodPost+" "+url, trace.WithSpanKind(trace.SpanKindClient))
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewBuffer(body))
+	if err != nil {
+		tracing.End(span, err)
+		return nil, err
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(requestid.Header, requestid.FromContext(ctx))
+	deadline.Set(request.Header, ctx)
+	tracing.Inject(request.Header, ctx)
+
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		tracing.End(span, err)
+		return nil, errcode.Upstream("service "+request.URL.Host, err)
+	}
+
+	span.SetAttributes(attribute.Int("http.response.status_code", response.StatusCode))
+	tracing.End(span, nil)
+
+	return response, nil
+}
+
+// serviceError returns the error of a failed response of a service. The code of its error envelope
+// is kept, so the client gets to know why the service failed, e.g. PDF_NOT_OPEN_ACCESS
+func serviceError(response *http.Response) error {
+	service := response.Request.URL.Host
+
+	failure := new(envelope.Response)
+
+	err := json.NewDecoder(response.Body).Decode(failure)
+	if err != nil || !failure.Error || failure.Message == "" {
+		return errcode.New(errcode.UpstreamFailed, fmt.Sprintf("service %s responded with status %d", service, response.StatusCode))
+	}
+
+	code := failure.Code
+	if !code.Known() {
+		code = errcode.ForStatus(response.StatusCode)
+	}
+
+	// the services behind search-service failing is an upstream failure to its callers
+	if code == errcode.Internal {
+		code = errcode.UpstreamFailed
+	}
+
+	return errcode.New(code, failure.Message)
+}
+
 // getUrlForSite gets the appropriate microservice url for the provided site
 func getUrlForSite(site string) (string, error) {
-	const (
-		wikiURL    = "http://med-api-service/wiki-summary"
-		scraperURL = "http://med-scraper-service/scrape"
-	)
-
 	switch site {
 	case sites.PubMed, sites.NHS:
-		return scraperURL, nil
+		return ScraperURL + "/scrape", nil
 	case sites.Wikipedia:
-		return wikiURL, nil
+		return MedAPIURL + "/wiki-summary", nil
 	}
 
-	return "", errors.New("not a valid site entry")
+	return "", errcode.New(errcode.InvalidSite, "not a valid site entry")
 }
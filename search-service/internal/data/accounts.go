@@ -0,0 +1,171 @@
+package data
+
+import (
+	"common/errcode"
+	"context"
+	"fmt"
+	"net/mail"
+	"search-service/internal/models"
+	"strings"
+	"sync"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	accountsCollection = "accounts"
+
+	// minPasswordLength is the length a password needs at least
+	minPasswordLength = 8
+	// maxPasswordLength is the longest password bcrypt can hash
+	maxPasswordLength = 72
+)
+
+var (
+	// ErrEmailTaken is returned when registering an email that already has an account
+	ErrEmailTaken = errcode.New(errcode.EmailTaken, "an account with this email already exists")
+	// ErrInvalidCredentials is returned when logging in with an unknown email or a wrong password
+	ErrInvalidCredentials = errcode.New(errcode.InvalidCredentials, "invalid email or password")
+)
+
+// dummyHash is compared with the password of logins to unknown emails, so that they take as long
+// as the ones to existing accounts and the response time doesn't tell which emails have an account
+var dummyHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("not the password of any account"), bcrypt.DefaultCost)
+	return hash
+})
+
+// EnsureIndexes creates the indexes the accounts and the libraries of the users rely on,
+// making emails unique and saved searches and bookmarks unique per user, and indexing alerts by user.
+// Alerts are unique per user, saved search and article, so that concurrent refreshes of an entry can't
+// alert an article twice. Webhook subscriptions get removed once they haven't been renewed for the subscriptionTTL
+func EnsureIndexes(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeOut)
+	defer cancel()
+
+	indexes := []struct {
+		collection string
+		model      mongo.IndexModel
+	}{
+		{accountsCollection, mongo.IndexModel{
+			Keys:    bson.D{{Key: "email", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}},
+		{savedSearchesCollection, mongo.IndexModel{
+			Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "keyword", Value: 1}},
+			Options: options.Index().SetUnique(true),
+		}},
+		{bookmarksCollection, mongo.IndexModel{
+			Keys: bson.D{
+				{Key: "user_id", Value: 1},
+				{Key: "list", Value: 1},
+				{Key: "article.origin", Value: 1},
+				{Key: "article.article_id", Value: 1},
+			},
+			Options: options.Index().SetUnique(true),
+		}},
+		{alertsCollection, mongo.IndexModel{
+			Keys: bson.D{{Key: "user_id", Value: 1}, {Key: "read", Value: 1}},
+		}},
+		{alertsCollection, mongo.IndexModel{
+			Keys: bson.D{
+				{Key: "user_id", Value: 1},
+				{Key: "keyword", Value: 1},
+				{Key: "origin", Value: 1},
+				{Key: "article_key", Value: 1},
+			},
+			// alerts recorded before they had an article key are left out, since they'd all share the missing key
+			Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"article_key": bson.M{"$type": "string"}}),
+		}},
+		{subscriptionsCollection, mongo.IndexModel{
+			Keys:    bson.D{{Key: "times.updated_at", Value: 1}},
+			Options: options.Index().SetExpireAfterSeconds(int32(subscriptionTTL.Seconds())),
+		}},
+	}
+
+	for _, index := range indexes {
+		err := store.CreateIndex(ctx, index.collection, index.model)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Register creates an account with the email, the name and a bcrypt hash of the password of the request
+func Register(ctx context.Context, request *models.AccountRequest) (*models.Account, error) {
+	email, err := normalizeEmail(request.Email)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(request.Password) < minPasswordLength || len(request.Password) > maxPasswordLength {
+		return nil, errcode.New(errcode.InvalidPassword, fmt.Sprintf("the password must be %d to %d characters long", minPasswordLength, maxPasswordLength))
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+
+	account := &models.Account{
+		Email:        email,
+		Name:         strings.TrimSpace(request.Name),
+		PasswordHash: string(hash),
+	}
+
+	id, err := InsertInto(accountsCollection, account)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, ErrEmailTaken
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	account.ID = id
+
+	return account, nil
+}
+
+// Login returns the account of the request's email if the password matches its hash
+func Login(ctx context.Context, request *models.AccountRequest) (*models.Account, error) {
+	email, err := normalizeEmail(request.Email)
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeOut)
+	defer cancel()
+
+	account := new(models.Account)
+
+	err = store.Collection(accountsCollection).FindOne(ctx, bson.M{"email": email}).Decode(account)
+	if err == mongo.ErrNoDocuments {
+		_ = bcrypt.CompareHashAndPassword(dummyHash(), []byte(request.Password))
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(account.PasswordHash), []byte(request.Password))
+	if err != nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	return account, nil
+}
+
+// normalizeEmail checks that the email is a bare address and lower cases it, so that it's unique regardless of its case
+func normalizeEmail(email string) (string, error) {
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != strings.TrimSpace(email) {
+		return "", errcode.New(errcode.InvalidEmail, fmt.Sprintf("%q is not a valid email", email))
+	}
+
+	return strings.ToLower(address.Address), nil
+}
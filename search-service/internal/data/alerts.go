@@ -0,0 +1,259 @@
+package data
+
+import (
+	"context"
+	"errors"
+	"search-service/internal/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const alertsCollection = "alerts"
+
+// articleIDFields are the fields that identify an article of each origin,
+// the ones after the first being fallbacks for entries stored before the first one existed
+var articleIDFields = map[string][]string{
+	"pubmed": {"pmid", "link", "title"},
+	"nhs":    {"link", "title"},
+	"wiki":   {"title"},
+}
+
+// NewArticles returns the articles of the fresh entry that the stored entry didn't have,
+// telling them apart by their PMID, url or title depending on their origin
+func NewArticles(stored, fresh *models.SearchEntry) []map[string]any {
+	known := make(map[string]bool, len(stored.Data))
+	for _, article := range stored.Data {
+		for _, key := range articleKeys(stored.Origin, article) {
+			known[key] = true
+		}
+	}
+
+	var found []map[string]any
+
+	for _, article := range fresh.Data {
+		keys := articleKeys(fresh.Origin, article)
+		if len(keys) == 0 {
+			continue
+		}
+
+		isNew := true
+		for _, key := range keys {
+			if known[key] {
+				isNew = false
+				break
+			}
+		}
+
+		if isNew {
+			found = append(found, article)
+		}
+	}
+
+	return found
+}
+
+// articleKeys returns the values that identify an article, prefixed with their field
+func articleKeys(origin string, article map[string]any) []string {
+	fields, ok := articleIDFields[origin]
+	if !ok {
+		fields = []string{"link", "title"}
+	}
+
+	var keys []string
+
+	for _, field := range fields {
+		if value, ok := article[field].(string); ok && value != "" {
+			keys = append(keys, field+":"+value)
+		}
+	}
+
+	return keys
+}
+
+// RecordAlerts records an alert for every new article of the entry, for every user
+// that saved a search of the entry's keyword in the entry's site. It returns the number of alerts recorded,
+// leaving out the ones that already exist, e.g. recorded by a concurrent refresh of the same entry
+func RecordAlerts(ctx context.Context, entry *models.SearchEntry, articles []map[string]any) (int, error) {
+	if len(articles) == 0 {
+		return 0, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeOut)
+	defer cancel()
+
+	cursor, err := store.Collection(savedSearchesCollection).Find(
+		ctx,
+		bson.M{"keyword": entry.Keyword, "sites_to_search": entry.Origin},
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	var searches []*models.SavedSearch
+
+	err = cursor.All(ctx, &searches)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(searches) == 0 {
+		return 0, nil
+	}
+
+	now := time.Now()
+	alerts := make([]any, 0, len(searches)*len(articles))
+
+	for _, search := range searches {
+		for _, article := range articles {
+			keys := articleKeys(entry.Origin, article)
+			if len(keys) == 0 {
+				continue
+			}
+
+			alerts = append(alerts, &models.Alert{
+				UserID:     search.UserID,
+				Keyword:    entry.Keyword,
+				Origin:     entry.Origin,
+				Article:    article,
+				ArticleKey: keys[0],
+				Times:      models.Times{CreatedAt: now, UpdatedAt: now},
+			})
+		}
+	}
+
+	if len(alerts) == 0 {
+		return 0, nil
+	}
+
+	// unordered, so that the alerts that already exist don't keep the others from being recorded
+	_, err = store.Collection(alertsCollection).InsertMany(ctx, alerts, options.InsertMany().SetOrdered(false))
+
+	duplicates, err := duplicateKeys(err)
+	if err != nil {
+		return 0, err
+	}
+
+	return len(alerts) - duplicates, nil
+}
+
+// duplicateKeys returns the number of writes of a bulk write that broke a unique index,
+// or the error of the bulk write if it failed for any other reason
+func duplicateKeys(err error) (int, error) {
+	if err == nil {
+		return 0, nil
+	}
+
+	var bulkErr mongo.BulkWriteException
+	if !errors.As(err, &bulkErr) || bulkErr.WriteConcernError != nil || len(bulkErr.WriteErrors) == 0 {
+		return 0, err
+	}
+
+	for _, writeErr := range bulkErr.WriteErrors {
+		if !mongo.IsDuplicateKeyError(writeErr.WriteError) {
+			return 0, err
+		}
+	}
+
+	return len(bulkErr.WriteErrors), nil
+}
+
+// ListAlerts returns the requested page of the alerts of a user, newest first
+func ListAlerts(ctx context.Context, query *models.AlertQuery) (*models.AlertPage, error) {
+	if query.UserID == "" {
+		return nil, errors.New("no user given to list the alerts of")
+	}
+
+	page, perPage := pageBounds(query.Page, query.PerPage)
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeOut)
+	defer cancel()
+
+	collection := store.Collection(alertsCollection)
+
+	unreadFilter := bson.M{"user_id": query.UserID, "read": false}
+
+	filter := bson.M{"user_id": query.UserID}
+	if query.Unread {
+		filter = unreadFilter
+	}
+
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	unread, err := collection.CountDocuments(ctx, unreadFilter)
+	if err != nil {
+		return nil, err
+	}
+
+	findOptions := options.Find().
+		SetSort(bson.M{"_id": -1}).
+		SetSkip(int64((page - 1) * perPage)).
+		SetLimit(int64(perPage))
+
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*models.Alert, 0, perPage)
+
+	err = cursor.All(ctx, &entries)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &models.AlertPage{
+		Entries: entries,
+		Page:    page,
+		PerPage: perPage,
+		Total:   total,
+		Unread:  unread,
+	}
+
+	return result, nil
+}
+
+// MarkAlertsRead marks the alerts of the query's ids as read, or every alert of the user if no ids are given,
+// and returns the number of alerts that were unread
+func MarkAlertsRead(ctx context.Context, query *models.AlertQuery) (int64, error) {
+	if query.UserID == "" {
+		return 0, errors.New("no user given to mark the alerts of")
+	}
+
+	filter := bson.M{"user_id": query.UserID, "read": false}
+
+	if len(query.IDs) > 0 {
+		ids := make([]primitive.ObjectID, 0, len(query.IDs))
+
+		for _, id := range query.IDs {
+			docID, err := primitive.ObjectIDFromHex(id)
+			if err != nil {
+				return 0, errors.New("invalid alert id " + id)
+			}
+
+			ids = append(ids, docID)
+		}
+
+		filter["_id"] = bson.M{"$in": ids}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeOut)
+	defer cancel()
+
+	result, err := store.Collection(alertsCollection).UpdateMany(
+		ctx,
+		filter,
+		bson.M{"$set": bson.M{"read": true, "times.updated_at": time.Now()}},
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.ModifiedCount, nil
+}
@@ -1,11 +1,14 @@
 package data
 
 import (
+	"common/errcode"
+	"common/requestid"
+	"common/tracing"
 	"context"
-	"errors"
 	"fmt"
-	"log"
+	"log/slog"
 	"search-service/internal/caller"
+	"search-service/internal/metrics"
 	"search-service/internal/models"
 	"sync"
 	"time"
@@ -13,28 +16,23 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // ctxTimeout is the set timeout for every mongodb operation
 const ctxTimeOut = 15 * time.Second
 
-var client *mongo.Client
-
-// NewConn gets the db connection from the main function
-func NewConn(mongo *mongo.Client) {
-	client = mongo
-}
-
 // InsertInto inserts a  models.DataEntry item into the appropriate collection
 // and returns the hex id and potentially an error
 func InsertInto(collName string, entry models.DataEntry) (string, error) {
-	collection := client.Database("search").Collection(collName)
+	collection := store.Collection(collName)
 
 	entry.AddDefaultData()
 
 	res, err := collection.InsertOne(context.Background(), entry)
 	if err != nil {
-		log.Println("Error inserting into searches:", err)
+		slog.Error("Error inserting into collection", "collection", collName, "error", err)
 		return "", err
 	}
 
@@ -46,24 +44,81 @@ func InsertInto(collName string, entry models.DataEntry) (string, error) {
 	return id.Hex(), nil
 }
 
+// withDeadline returns ctx with a timeout of ctxTimeOut, unless ctx already has a deadline of its own,
+// such as the one passed on by the broker or the one of a job, which then applies instead
+func withDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithTimeout(ctx, ctxTimeOut)
+}
+
+// SiteResult is the result of searching one of the SitesToSearch of a query,
+// Index being the position of the site in SitesToSearch
+type SiteResult struct {
+	Index int
+	Site  string
+	Entry *models.SearchEntry
+	Err   error
+}
+
 // SearchEntriesByKeyword queries the mongodb with the provided query keyword and the SitesToSearch,
 // if it doesn't find a suitable entries, it calles the appropriate scraper to collect it
 // and returns a slice of SearchEntries and potentially an error
-func SearchEntriesByKeyword(query *models.SearchQuery) ([]*models.SearchEntry, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeOut)
-	defer cancel()
+func SearchEntriesByKeyword(ctx context.Context, query *models.SearchQuery) ([]*models.SearchEntry, error) {
+	siteResults, err := StreamEntriesByKeyword(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*models.SearchEntry, len(query.SitesToSearch))
+
+	var (
+		failed   int
+		firstErr error
+	)
 
+	// Not using append on results slice so that every entry stays in the position of its site
+	for result := range siteResults {
+		if result.Err == nil {
+			results[result.Index] = result.Entry
+			continue
+		}
+
+		failed++
+		if firstErr == nil {
+			firstErr = result.Err
+		}
+	}
+
+	// the search only fails when no site could be searched, so the client gets to know why
+	if failed == len(results) {
+		return nil, firstErr
+	}
+
+	return results, nil
+}
+
+// StreamEntriesByKeyword searches every one of the SitesToSearch of the query the same way as
+// SearchEntriesByKeyword, but sends the result of each site on the returned channel as soon as it's ready.
+// The channel gets closed after all sites have been searched
+func StreamEntriesByKeyword(ctx context.Context, query *models.SearchQuery) (<-chan SiteResult, error) {
 	sitesLen := len(query.SitesToSearch)
 
 	if sitesLen < 1 {
-		return nil, errors.New("no sites to search given, search cancelled")
+		return nil, errcode.New(errcode.NoSites, "no sites to search given, search cancelled")
 	}
 
 	if query.Keyword == "" {
-		return nil, errors.New("no keywords to perform search")
+		return nil, errcode.New(errcode.EmptyKeyword, "no keywords to perform search")
 	}
 
-	results := make([]*models.SearchEntry, sitesLen)
+	// the search stops along with the request, so a client that left or ran out of time
+	// doesn't keep the scrapers and collectors busy
+	ctx, cancel := withDeadline(ctx)
+
+	results := make(chan SiteResult, sitesLen)
 
 	wg := new(sync.WaitGroup)
 
@@ -76,39 +131,51 @@ func SearchEntriesByKeyword(query *models.SearchQuery) ([]*models.SearchEntry, e
 	// but so far I haven't found a good way of doing that.
 	/********************************************************************************************/
 	// Note: Capturing i and site in closure because their values change through each iteration.
-	// Not using append on results slice as to avoid data races and use of sync.Mutex
+	// The results channel is buffered for every site, so no goroutine blocks on a slow reader
 	populateResultsFor := func(i int, site string) {
 		defer wg.Done()
 
+		ctx, span := tracing.Start(ctx, "search site", trace.WithAttributes(
+			attribute.String("search.site", site),
+			attribute.String("search.keyword", query.Keyword),
+		))
+
 		result, err := searchForKeyword(ctx, query.Keyword, site)
+
+		tracing.End(span, err)
+
+		results <- SiteResult{Index: i, Site: site, Entry: result, Err: err}
+
 		if err != nil {
-			log.Printf("Failed to fetch result for site %s with error: %s\n", site, err)
+			slog.WarnContext(ctx, "Failed to fetch result for site", "site", site, "error", err)
 			return
 		}
 
-		results[i] = result
-
 		// Doing this in the background since it doesn't affect the final results,
 		// nor is there a returned value or error to be handled
-		go checkForUpdate(result, site)
+		go checkForUpdate(requestid.Detach(ctx), result, site)
 	}
 
 	for i, site := range query.SitesToSearch {
 		go populateResultsFor(i, site)
 	}
 
-	wg.Wait()
+	go func() {
+		wg.Wait()
+		cancel()
+		close(results)
+	}()
 
 	return results, nil
 }
 
 // SearchForPDF queries mongdb for the requested pdf based on the keyword(PMID)
 // and returns a models.SearchEntry and potentially and error
-func SearchForPDF(query *models.SearchQuery) (*models.PDFEntry, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeOut)
+func SearchForPDF(ctx context.Context, query *models.SearchQuery) (*models.PDFEntry, error) {
+	ctx, cancel := withDeadline(ctx)
 	defer cancel()
 
-	collection := client.Database("search").Collection("pdf_logs")
+	collection := store.Collection("pdf_logs")
 
 	result := new(models.PDFEntry)
 
@@ -117,18 +184,18 @@ func SearchForPDF(query *models.SearchQuery) (*models.PDFEntry, error) {
 	if err != nil {
 		if err != mongo.ErrNoDocuments {
 			err = fmt.Errorf("could not decode pdf entry result for PMCID %s with error: %s", query.Keyword, err.Error())
-			log.Println(err)
+			slog.ErrorContext(ctx, err.Error())
 			return nil, err
 		}
 
-		result, err = caller.RequestPDFEntry(query.Keyword)
+		result, err = caller.RequestPDFEntry(ctx, query.Keyword)
 		if err != nil {
 			return nil, err
 		}
 
 		result.ID, err = InsertInto("pdf_logs", result)
 		if err != nil {
-			log.Printf("Could not insert pdf entry result with PMCID: %s and error: %s\n", query.Keyword, err.Error())
+			slog.ErrorContext(ctx, "Could not insert pdf entry result", "pmcid", query.Keyword, "error", err)
 		}
 	}
 
@@ -141,7 +208,7 @@ func GetOneSearchEntryByID(id, site string) (*models.SearchEntry, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeOut)
 	defer cancel()
 
-	collection := client.Database("search").Collection("search_logs")
+	collection := store.Collection("search_logs")
 
 	docID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -163,7 +230,7 @@ func DropCollection(collectionName string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeOut)
 	defer cancel()
 
-	collection := client.Database("search").Collection(collectionName)
+	collection := store.Collection(collectionName)
 
 	err := collection.Drop(ctx)
 
@@ -176,7 +243,7 @@ func UpdateSearchEntry(s *models.SearchEntry) error {
 	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeOut)
 	defer cancel()
 
-	collection := client.Database("search").Collection("search_logs")
+	collection := store.Collection("search_logs")
 
 	docID, err := primitive.ObjectIDFromHex(s.ID)
 	if err != nil {
@@ -187,9 +254,10 @@ func UpdateSearchEntry(s *models.SearchEntry) error {
 		ctx,
 		bson.M{"_id": docID},
 		bson.D{
-			{"$set", bson.D{
-				{"data", s.Data},
-				{"updated_at", time.Now()},
+			{Key: "$set", Value: bson.D{
+				{Key: "data", Value: s.Data},
+				// Times is stored as a subdocument, since it's embedded without the inline tag
+				{Key: "times.updated_at", Value: time.Now()},
 			}},
 		},
 	)
@@ -202,7 +270,7 @@ func DeleteByIDIn(collectionName, id string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), ctxTimeOut)
 	defer cancel()
 
-	collection := client.Database("search").Collection(collectionName)
+	collection := store.Collection(collectionName)
 
 	docID, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
@@ -219,36 +287,49 @@ func DeleteByIDIn(collectionName, id string) error {
 // which means no documents were found, so it requests new data to insert to the collection and
 // return from the appropriate scraper
 func searchForKeyword(ctx context.Context, keyword, site string) (*models.SearchEntry, error) {
-	collection := client.Database("search").Collection("search_logs")
+	start := time.Now()
+
+	collection := store.Collection("search_logs")
 
 	result := new(models.SearchEntry)
 
 	err := collection.FindOne(ctx, bson.M{"keyword": keyword, "origin": site}).Decode(result)
 
-	if err != nil {
-		if err != mongo.ErrNoDocuments {
-			err = fmt.Errorf("could not decode search result for %s with error: %s", keyword, err.Error())
-			log.Println(err)
-			return nil, err
-		}
+	trace.SpanFromContext(ctx).SetAttributes(attribute.Bool("search.mongo_hit", err == nil))
 
-		result, err = caller.RequestSearchEntry(keyword, site)
-		if err != nil {
-			return nil, err
-		}
-		result.ID, err = InsertInto("search_logs", result)
-		if err != nil {
-			log.Printf("Could not insert search result with keyword: %s and error: %s\n", keyword, err.Error())
-		}
+	if err == nil {
+		metrics.MongoLookup(site, true)
+		slog.InfoContext(ctx, "Search entry found in cache", "site", site, "keyword", keyword, "latency_ms", time.Since(start).Milliseconds())
+		return result, nil
+	}
+
+	if err != mongo.ErrNoDocuments {
+		err = fmt.Errorf("could not decode search result for %s with error: %s", keyword, err.Error())
+		slog.ErrorContext(ctx, err.Error(), "site", site)
+		return nil, err
+	}
 
+	metrics.MongoLookup(site, false)
+
+	result, err = caller.RequestSearchEntry(ctx, keyword, site)
+	if err != nil {
+		return nil, err
+	}
+
+	result.ID, err = InsertInto("search_logs", result)
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not insert search result", "site", site, "keyword", keyword, "error", err)
 	}
 
+	slog.InfoContext(ctx, "Search entry collected", "site", site, "keyword", keyword, "articles", len(result.Data), "latency_ms", time.Since(start).Milliseconds())
+
 	return result, nil
 }
 
 // checkForUpdate checks if the entry needs to be updated
-// and updates it if nessecary
-func checkForUpdate(entry *models.SearchEntry, site string) {
+// and updates it if nessecary, recording alerts for the articles it didn't have
+// for the users that saved a search of it
+func checkForUpdate(ctx context.Context, entry *models.SearchEntry, site string) {
 	const (
 		maxDays    = 7
 		hoursInDay = 24
@@ -262,18 +343,37 @@ func checkForUpdate(entry *models.SearchEntry, site string) {
 		return
 	}
 
-	entry, err := caller.RequestSearchEntry(entry.Keyword, entry.Origin)
+	// the update is detached from the request, so it gets a deadline of its own
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeOut)
+	defer cancel()
+
+	fresh, err := caller.RequestSearchEntry(ctx, entry.Keyword, entry.Origin)
 	if err != nil {
-		log.Println("Could not get new entry for update from scraper with error:", err.Error())
+		slog.WarnContext(ctx, "Could not get new entry for update from scraper", "site", site, "error", err)
 		return
 	}
 
-	err = UpdateSearchEntry(entry)
+	// the collected entry has no id yet, it replaces the data of the stored one
+	fresh.ID = entry.ID
+
+	err = UpdateSearchEntry(fresh)
 	if err != nil {
-		log.Println("Entry update failed due to error:", err)
+		slog.WarnContext(ctx, "Entry update failed", "site", site, "error", err)
 		err = DeleteByIDIn("search_logs", entry.ID)
 		if err != nil {
-			log.Println("Entry delete failed due to error:", err)
+			slog.ErrorContext(ctx, "Entry delete failed", "site", site, "error", err)
 		}
+		return
+	}
+
+	alerts, err := RecordAlerts(ctx, fresh, NewArticles(entry, fresh))
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not record alerts of updated entry", "site", site, "keyword", entry.Keyword, "error", err)
+	} else if alerts > 0 {
+		slog.InfoContext(ctx, "Alerts recorded for new articles", "site", site, "keyword", entry.Keyword, "alerts", alerts)
+	}
+
+	if OnUpdate != nil {
+		OnUpdate(ctx, fresh)
 	}
 }
@@ -0,0 +1,171 @@
+package data
+
+import (
+	"common/errcode"
+	"context"
+	"errors"
+	"math"
+	"search-service/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	historyCollection = "search_history"
+
+	// HistoryEventSearch is recorded when a user searches a keyword
+	HistoryEventSearch = "search"
+	// HistoryEventOpen is recorded when a user opens an article of a search
+	HistoryEventOpen = "open"
+
+	defaultPerPage = 20
+	maxPerPage     = 100
+	// maxPage is the last page that can be asked for, so that the number of entries skipped to reach it can't overflow
+	maxPage = math.MaxInt32 / maxPerPage
+)
+
+// RecordHistory records the search of the HistoryRecord in the history of its user.
+// If the record's entry holds articles, every article is recorded as opened instead, all of them
+// at once, and the number of recorded history entries is returned
+func RecordHistory(ctx context.Context, record *models.HistoryRecord) (int, error) {
+	if record.UserID == "" {
+		return 0, errors.New("no user given to record history for")
+	}
+
+	if record.Entry.Keyword == "" {
+		return 0, errcode.New(errcode.EmptyKeyword, "no keyword given to record in history")
+	}
+
+	entries := make([]*models.HistoryEntry, 0, len(record.Entry.Data))
+
+	for _, article := range record.Entry.Data {
+		entries = append(entries, &models.HistoryEntry{
+			UserID:  record.UserID,
+			Event:   HistoryEventOpen,
+			Keyword: record.Entry.Keyword,
+			Origin:  record.Entry.Origin,
+			Article: article,
+		})
+	}
+
+	if len(entries) == 0 {
+		entries = append(entries, &models.HistoryEntry{
+			UserID:  record.UserID,
+			Event:   HistoryEventSearch,
+			Keyword: record.Entry.Keyword,
+			Origin:  record.Entry.Origin,
+		})
+	}
+
+	documents := make([]any, len(entries))
+	for i, entry := range entries {
+		entry.AddDefaultData()
+		documents[i] = entry
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeOut)
+	defer cancel()
+
+	result, err := store.Collection(historyCollection).InsertMany(ctx, documents)
+	if err != nil {
+		inserted := 0
+		if result != nil {
+			inserted = len(result.InsertedIDs)
+		}
+
+		return inserted, err
+	}
+
+	return len(entries), nil
+}
+
+// ListHistory returns the requested page of the history of the query's user, newest first
+func ListHistory(ctx context.Context, query *models.HistoryQuery) (*models.HistoryPage, error) {
+	if query.UserID == "" {
+		return nil, errors.New("no user given to list history for")
+	}
+
+	page, perPage := pageBounds(query.Page, query.PerPage)
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeOut)
+	defer cancel()
+
+	collection := store.Collection(historyCollection)
+
+	filter := bson.M{"user_id": query.UserID}
+
+	total, err := collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	// object ids grow with their creation time, so sorting by them puts the newest first
+	findOptions := options.Find().
+		SetSort(bson.M{"_id": -1}).
+		SetSkip(int64((page - 1) * perPage)).
+		SetLimit(int64(perPage))
+
+	cursor, err := collection.Find(ctx, filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*models.HistoryEntry, 0, perPage)
+
+	err = cursor.All(ctx, &entries)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &models.HistoryPage{
+		Entries: entries,
+		Page:    page,
+		PerPage: perPage,
+		Total:   total,
+	}
+
+	return result, nil
+}
+
+// ClearHistory deletes the whole history of the query's user
+// and returns the number of deleted entries
+func ClearHistory(ctx context.Context, query *models.HistoryQuery) (int64, error) {
+	if query.UserID == "" {
+		return 0, errors.New("no user given to clear history for")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeOut)
+	defer cancel()
+
+	collection := store.Collection(historyCollection)
+
+	result, err := collection.DeleteMany(ctx, bson.M{"user_id": query.UserID})
+	if err != nil {
+		return 0, err
+	}
+
+	return result.DeletedCount, nil
+}
+
+// pageBounds returns the page and the entries per page to list, starting from page 1 up to maxPage
+// and with defaultPerPage entries per page unless asked for, up to maxPerPage
+func pageBounds(page, perPage int) (int, int) {
+	if page < 1 {
+		page = 1
+	}
+
+	if page > maxPage {
+		page = maxPage
+	}
+
+	if perPage < 1 {
+		perPage = defaultPerPage
+	}
+
+	if perPage > maxPerPage {
+		perPage = maxPerPage
+	}
+
+	return page, perPage
+}
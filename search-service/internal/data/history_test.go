@@ -0,0 +1,34 @@
+package data
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPageBounds(t *testing.T) {
+	tests := []struct {
+		name            string
+		page, perPage   int
+		wantPage, wantN int
+	}{
+		{name: "defaults", wantPage: 1, wantN: defaultPerPage},
+		{name: "as asked", page: 3, perPage: 10, wantPage: 3, wantN: 10},
+		{name: "negative", page: -2, perPage: -5, wantPage: 1, wantN: defaultPerPage},
+		{name: "too many per page", page: 1, perPage: 1000, wantPage: 1, wantN: maxPerPage},
+		{name: "last page", page: maxPage, perPage: maxPerPage, wantPage: maxPage, wantN: maxPerPage},
+		{name: "past the last page", page: math.MaxInt, perPage: maxPerPage, wantPage: maxPage, wantN: maxPerPage},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, perPage := pageBounds(tt.page, tt.perPage)
+			if page != tt.wantPage || perPage != tt.wantN {
+				t.Fatalf("got page %d of %d, want page %d of %d", page, perPage, tt.wantPage, tt.wantN)
+			}
+
+			if skip := int64((page - 1) * perPage); skip < 0 || skip > math.MaxInt32 {
+				t.Errorf("got a skip of %d, want it within 0 and %d", skip, math.MaxInt32)
+			}
+		})
+	}
+}
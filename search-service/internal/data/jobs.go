@@ -0,0 +1,134 @@
+package data
+
+import (
+	"common/errcode"
+	"context"
+	"search-service/internal/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	jobsCollection = "jobs"
+
+	// JobKindPDF jobs retrieve the pdf of the query's keyword (PMCID)
+	JobKindPDF = "get-pdf"
+	// JobKindSearch jobs search the query's keyword in its sites
+	JobKindSearch = "search"
+
+	// The statuses of a job, from submitted to finished
+	JobStatusQueued  = "queued"
+	JobStatusRunning = "running"
+	JobStatusDone    = "done"
+	JobStatusFailed  = "failed"
+)
+
+// ErrJobNotFound is returned when a job doesn't exist or belongs to another user
+var ErrJobNotFound = errcode.New(errcode.JobNotFound, "job not found")
+
+// InsertJob stores a new queued job and returns it with its id
+func InsertJob(ctx context.Context, job *models.Job) (*models.Job, error) {
+	job.Status = JobStatusQueued
+	job.Progress = 0
+
+	id, err := InsertInto(jobsCollection, job)
+	if err != nil {
+		return nil, err
+	}
+
+	job.ID = id
+
+	return job, nil
+}
+
+// GetJob returns the job with the provided id. A non empty userID
+// only finds the job if it was submitted by that user
+func GetJob(ctx context.Context, id, userID string) (*models.Job, error) {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeOut)
+	defer cancel()
+
+	docID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrJobNotFound
+	}
+
+	filter := bson.M{"_id": docID}
+	if userID != "" {
+		filter["user_id"] = userID
+	}
+
+	job := new(models.Job)
+
+	err = store.Collection(jobsCollection).FindOne(ctx, filter).Decode(job)
+	if err == mongo.ErrNoDocuments {
+		return nil, ErrJobNotFound
+	}
+
+	return job, err
+}
+
+// UpdateJob sets the provided fields of the job with the provided id
+func UpdateJob(ctx context.Context, id string, fields bson.M) error {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeOut)
+	defer cancel()
+
+	docID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return err
+	}
+
+	fields["times.updated_at"] = time.Now()
+
+	_, err = store.Collection(jobsCollection).UpdateOne(
+		ctx,
+		bson.M{"_id": docID},
+		bson.M{"$set": fields},
+	)
+
+	return err
+}
+
+// UnfinishedJobIDs returns the ids of the jobs that are queued or were left running, oldest first.
+// The jobs that were left running are set back to queued, since their work was lost
+func UnfinishedJobIDs(ctx context.Context) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeOut)
+	defer cancel()
+
+	collection := store.Collection(jobsCollection)
+
+	_, err := collection.UpdateMany(
+		ctx,
+		bson.M{"status": JobStatusRunning},
+		bson.M{"$set": bson.M{"status": JobStatusQueued, "progress": 0}},
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	findOptions := options.Find().
+		SetSort(bson.M{"_id": 1}).
+		SetProjection(bson.M{"_id": 1})
+
+	cursor, err := collection.Find(ctx, bson.M{"status": JobStatusQueued}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []*models.Job
+
+	err = cursor.All(ctx, &jobs)
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, len(jobs))
+	for i, job := range jobs {
+		ids[i] = job.ID
+	}
+
+	return ids, nil
+}
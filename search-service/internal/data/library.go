@@ -0,0 +1,263 @@
+package data
+
+import (
+	"common/errcode"
+	"context"
+	"errors"
+	"fmt"
+	"search-service/internal/models"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	savedSearchesCollection = "saved_searches"
+	bookmarksCollection     = "bookmarks"
+
+	// DefaultReadingList is the reading list of the bookmarks saved without one
+	DefaultReadingList = "default"
+)
+
+var (
+	// ErrSavedSearchNotFound is returned when a saved search doesn't exist or belongs to another user
+	ErrSavedSearchNotFound = errcode.New(errcode.SavedSearchNotFound, "saved search not found")
+	// ErrBookmarkNotFound is returned when an article isn't bookmarked in the reading list
+	ErrBookmarkNotFound = errcode.New(errcode.BookmarkNotFound, "bookmark not found")
+)
+
+// articleOrigins are the origins an article can be bookmarked from
+var articleOrigins = map[string]bool{"pubmed": true, "nhs": true, "wiki": true}
+
+// SaveSearch saves the keyword and the sites to search of the request for its user.
+// Saving a keyword again replaces the sites it's saved with
+func SaveSearch(ctx context.Context, request *models.LibraryRequest) (*models.SavedSearch, error) {
+	if request.UserID == "" {
+		return nil, errors.New("no user given to save the search for")
+	}
+
+	if request.Search == nil || strings.TrimSpace(request.Search.Keyword) == "" || len(request.Search.SitesToSearch) == 0 {
+		return nil, errors.New("a saved search needs a keyword and sites to search")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeOut)
+	defer cancel()
+
+	now := time.Now()
+	search := &models.SavedSearch{
+		UserID:        request.UserID,
+		Keyword:       strings.TrimSpace(request.Search.Keyword),
+		SitesToSearch: request.Search.SitesToSearch,
+	}
+
+	updateOptions := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	err := store.Collection(savedSearchesCollection).FindOneAndUpdate(
+		ctx,
+		bson.M{"user_id": search.UserID, "keyword": search.Keyword},
+		bson.M{
+			"$set":         bson.M{"sites_to_search": search.SitesToSearch, "times.updated_at": now},
+			"$setOnInsert": bson.M{"times.created_at": now},
+		},
+		updateOptions,
+	).Decode(search)
+
+	return search, err
+}
+
+// ListSavedSearches returns the saved searches of a user, sorted by keyword
+func ListSavedSearches(ctx context.Context, userID string) ([]*models.SavedSearch, error) {
+	if userID == "" {
+		return nil, errors.New("no user given to list the saved searches of")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeOut)
+	defer cancel()
+
+	cursor, err := store.Collection(savedSearchesCollection).Find(
+		ctx,
+		bson.M{"user_id": userID},
+		options.Find().SetSort(bson.M{"keyword": 1}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	searches := make([]*models.SavedSearch, 0)
+
+	err = cursor.All(ctx, &searches)
+
+	return searches, err
+}
+
+// DeleteSavedSearch deletes the saved search with the request's id, if it belongs to the request's user
+func DeleteSavedSearch(ctx context.Context, request *models.LibraryRequest) error {
+	if request.UserID == "" {
+		return errors.New("no user given to delete the saved search of")
+	}
+
+	docID, err := primitive.ObjectIDFromHex(request.ID)
+	if err != nil {
+		return ErrSavedSearchNotFound
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeOut)
+	defer cancel()
+
+	result, err := store.Collection(savedSearchesCollection).DeleteOne(
+		ctx,
+		bson.M{"_id": docID, "user_id": request.UserID},
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return ErrSavedSearchNotFound
+	}
+
+	return nil
+}
+
+// AddBookmark bookmarks the request's article in the request's reading list of its user,
+// doing nothing but refreshing its title and link if it's already bookmarked there
+func AddBookmark(ctx context.Context, request *models.LibraryRequest) (*models.Bookmark, error) {
+	err := checkBookmark(request)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeOut)
+	defer cancel()
+
+	now := time.Now()
+	bookmark := &models.Bookmark{
+		UserID:  request.UserID,
+		List:    readingList(request.List),
+		Article: *request.Article,
+	}
+
+	updateOptions := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.After)
+
+	err = store.Collection(bookmarksCollection).FindOneAndUpdate(
+		ctx,
+		bookmarkFilter(bookmark.UserID, bookmark.List, &bookmark.Article),
+		bson.M{
+			"$set":         bson.M{"article.title": bookmark.Article.Title, "article.link": bookmark.Article.Link, "times.updated_at": now},
+			"$setOnInsert": bson.M{"times.created_at": now},
+		},
+		updateOptions,
+	).Decode(bookmark)
+
+	return bookmark, err
+}
+
+// RemoveBookmark removes the request's article from the request's reading list of its user
+func RemoveBookmark(ctx context.Context, request *models.LibraryRequest) error {
+	err := checkBookmark(request)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeOut)
+	defer cancel()
+
+	result, err := store.Collection(bookmarksCollection).DeleteOne(
+		ctx,
+		bookmarkFilter(request.UserID, readingList(request.List), request.Article),
+	)
+	if err != nil {
+		return err
+	}
+
+	if result.DeletedCount == 0 {
+		return ErrBookmarkNotFound
+	}
+
+	return nil
+}
+
+// ListReadingLists returns the reading lists of a user with their bookmarks, newest first.
+// A non empty list only returns that reading list
+func ListReadingLists(ctx context.Context, request *models.LibraryRequest) ([]*models.ReadingList, error) {
+	if request.UserID == "" {
+		return nil, errors.New("no user given to list the reading lists of")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeOut)
+	defer cancel()
+
+	filter := bson.M{"user_id": request.UserID}
+	if request.List != "" {
+		filter["list"] = readingList(request.List)
+	}
+
+	cursor, err := store.Collection(bookmarksCollection).Find(
+		ctx,
+		filter,
+		options.Find().SetSort(bson.D{{Key: "list", Value: 1}, {Key: "_id", Value: -1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var bookmarks []*models.Bookmark
+
+	err = cursor.All(ctx, &bookmarks)
+	if err != nil {
+		return nil, err
+	}
+
+	lists := make([]*models.ReadingList, 0)
+
+	for _, bookmark := range bookmarks {
+		if len(lists) == 0 || lists[len(lists)-1].Name != bookmark.List {
+			lists = append(lists, &models.ReadingList{Name: bookmark.List})
+		}
+
+		last := lists[len(lists)-1]
+		last.Bookmarks = append(last.Bookmarks, bookmark)
+	}
+
+	return lists, nil
+}
+
+// checkBookmark checks that a request names a user and an article with an id and a known origin
+func checkBookmark(request *models.LibraryRequest) error {
+	if request.UserID == "" {
+		return errors.New("no user given to bookmark the article for")
+	}
+
+	if request.Article == nil || strings.TrimSpace(request.Article.ID) == "" {
+		return errors.New("no article given to bookmark")
+	}
+
+	if !articleOrigins[request.Article.Origin] {
+		return fmt.Errorf("unknown article origin %q, must be pubmed, nhs or wiki", request.Article.Origin)
+	}
+
+	return nil
+}
+
+// bookmarkFilter returns the filter of the bookmark of an article in a reading list of a user
+func bookmarkFilter(userID, list string, article *models.ArticleRef) bson.M {
+	return bson.M{
+		"user_id":            userID,
+		"list":               list,
+		"article.origin":     article.Origin,
+		"article.article_id": strings.TrimSpace(article.ID),
+	}
+}
+
+// readingList returns the name of a reading list, the default one when it's empty
+func readingList(name string) string {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return DefaultReadingList
+	}
+
+	return name
+}
@@ -0,0 +1,75 @@
+package data
+
+import (
+	"context"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// databaseName is the name of the mongo database that holds the collections of the service
+const databaseName = "search"
+
+// Collection is the part of *mongo.Collection that the package uses,
+// so that the collections can be kept somewhere else than in mongo, see memstore
+type Collection interface {
+	InsertOne(ctx context.Context, document any, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error)
+	InsertMany(ctx context.Context, documents []any, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error)
+	FindOne(ctx context.Context, filter any, opts ...*options.FindOneOptions) *mongo.SingleResult
+	Find(ctx context.Context, filter any, opts ...*options.FindOptions) (*mongo.Cursor, error)
+	CountDocuments(ctx context.Context, filter any, opts ...*options.CountOptions) (int64, error)
+	UpdateOne(ctx context.Context, filter any, update any, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+	UpdateMany(ctx context.Context, filter any, update any, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error)
+	FindOneAndUpdate(ctx context.Context, filter any, update any, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult
+	DeleteOne(ctx context.Context, filter any, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
+	DeleteMany(ctx context.Context, filter any, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error)
+	Drop(ctx context.Context) error
+}
+
+// Store holds the collections of the service
+type Store interface {
+	// Collection returns the collection with the provided name, creating it if it doesn't exist
+	Collection(name string) Collection
+	// CreateIndex creates the index in the collection with the provided name
+	CreateIndex(ctx context.Context, collection string, index mongo.IndexModel) error
+	// Ping checks that the store can be reached
+	Ping(ctx context.Context) error
+}
+
+// store holds the collections of the package, set by NewConn or UseStore
+var store Store
+
+// NewConn gets the db connection from the main function
+func NewConn(client *mongo.Client) {
+	store = &mongoStore{database: client.Database(databaseName)}
+}
+
+// UseStore makes the package keep its collections in the provided store instead of mongo
+func UseStore(s Store) {
+	store = s
+}
+
+// Ping checks that the store of the package can be reached, e.g. that mongo is up
+func Ping(ctx context.Context) error {
+	return store.Ping(ctx)
+}
+
+// mongoStore is the Store of a mongo database
+type mongoStore struct {
+	database *mongo.Database
+}
+
+func (s *mongoStore) Collection(name string) Collection {
+	return s.database.Collection(name)
+}
+
+func (s *mongoStore) CreateIndex(ctx context.Context, collection string, index mongo.IndexModel) error {
+	_, err := s.database.Collection(collection).Indexes().CreateOne(ctx, index)
+
+	return err
+}
+
+func (s *mongoStore) Ping(ctx context.Context) error {
+	return s.database.Client().Ping(ctx, readpref.Primary())
+}
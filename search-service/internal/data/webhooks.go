@@ -0,0 +1,120 @@
+package data
+
+import (
+	"context"
+	"search-service/internal/models"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	subscriptionsCollection = "webhook_subscriptions"
+	deadLettersCollection   = "webhook_dead_letters"
+
+	// subscriptionTTL is how long a subscription lasts after the last search that subscribed its url
+	subscriptionTTL = 30 * 24 * time.Hour
+)
+
+// OnUpdate, when set, gets called with every search entry that checkForUpdate refreshed
+var OnUpdate func(ctx context.Context, entry *models.SearchEntry)
+
+// Subscribe subscribes the url to the refreshes of the keyword's search entries for the subscriptionTTL,
+// renewing the subscription if it's already subscribed
+func Subscribe(ctx context.Context, keyword, url string) error {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeOut)
+	defer cancel()
+
+	now := time.Now()
+
+	_, err := store.Collection(subscriptionsCollection).UpdateOne(
+		ctx,
+		bson.M{"keyword": keyword, "url": url},
+		bson.M{
+			"$set":         bson.M{"times.updated_at": now},
+			"$setOnInsert": bson.M{"times.created_at": now},
+		},
+		options.Update().SetUpsert(true),
+	)
+
+	return err
+}
+
+// SubscriptionsFor returns the subscriptions to the refreshes of the keyword's search entries that haven't expired.
+// The TTL index of mongo removes expired subscriptions only once a minute, so they are skipped here as well
+func SubscriptionsFor(ctx context.Context, keyword string) ([]*models.Subscription, error) {
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeOut)
+	defer cancel()
+
+	cursor, err := store.Collection(subscriptionsCollection).Find(ctx, bson.M{"keyword": keyword})
+	if err != nil {
+		return nil, err
+	}
+
+	var subscriptions []*models.Subscription
+
+	err = cursor.All(ctx, &subscriptions)
+	if err != nil {
+		return nil, err
+	}
+
+	expired := time.Now().Add(-subscriptionTTL)
+
+	live := subscriptions[:0]
+	for _, subscription := range subscriptions {
+		if subscription.UpdatedAt.After(expired) {
+			live = append(live, subscription)
+		}
+	}
+
+	return live, nil
+}
+
+// InsertDeadLetter stores a webhook notification that could not be delivered
+func InsertDeadLetter(deadLetter *models.DeadLetter) error {
+	_, err := InsertInto(deadLettersCollection, deadLetter)
+
+	return err
+}
+
+// ListDeadLetters returns the requested page of the dead letters, newest first
+func ListDeadLetters(ctx context.Context, query *models.DeadLetterQuery) (*models.DeadLetterPage, error) {
+	page, perPage := pageBounds(query.Page, query.PerPage)
+
+	ctx, cancel := context.WithTimeout(ctx, ctxTimeOut)
+	defer cancel()
+
+	collection := store.Collection(deadLettersCollection)
+
+	total, err := collection.CountDocuments(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+
+	findOptions := options.Find().
+		SetSort(bson.M{"_id": -1}).
+		SetSkip(int64((page - 1) * perPage)).
+		SetLimit(int64(perPage))
+
+	cursor, err := collection.Find(ctx, bson.M{}, findOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]*models.DeadLetter, 0, perPage)
+
+	err = cursor.All(ctx, &entries)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &models.DeadLetterPage{
+		Entries: entries,
+		Page:    page,
+		PerPage: perPage,
+		Total:   total,
+	}
+
+	return result, nil
+}
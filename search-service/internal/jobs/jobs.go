@@ -0,0 +1,287 @@
+package jobs
+
+import (
+	"common/errcode"
+	"common/requestid"
+	"common/tracing"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"search-service/internal/data"
+	"search-service/internal/models"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrQueueFull is returned when a job is submitted while every place in the queue is taken
+var ErrQueueFull = errcode.New(errcode.Unavailable, "too many jobs queued, try again later")
+
+// ErrResuming is returned when a job is submitted while the unfinished jobs are being queued again, see Resume
+var ErrResuming = errcode.New(errcode.Unavailable, "unfinished jobs are being resumed, try again later")
+
+// Pool runs the jobs stored in mongo with a fixed number of workers.
+// Jobs wait in a bounded queue until a worker is free, and each one
+// runs for at most the pool's timeout
+type Pool struct {
+	queue    chan string
+	timeout  time.Duration
+	resuming atomic.Bool
+	// OnFinish, when set, gets called with every job that is done or failed
+	OnFinish func(ctx context.Context, job *models.Job)
+}
+
+// NewPool starts a pool of the provided number of workers, with a queue of queueSize jobs
+func NewPool(workers, queueSize int, timeout time.Duration) *Pool {
+	p := &Pool{
+		queue:   make(chan string, queueSize),
+		timeout: timeout,
+	}
+
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+
+	return p
+}
+
+// Submit stores the requested job and queues it, returning the job with its id right away.
+// If the queue is full, the job is stored as failed and ErrQueueFull is returned,
+// and while the pool is resuming jobs, none get stored and ErrResuming is returned
+func (p *Pool) Submit(ctx context.Context, request *models.JobRequest) (*models.Job, error) {
+	if request.UserID == "" {
+		return nil, errors.New("no user given to submit the job for")
+	}
+
+	switch request.Kind {
+	case data.JobKindPDF:
+		if request.Search.Keyword == "" {
+			return nil, errors.New("no pmcid given to retrieve the pdf of")
+		}
+	case data.JobKindSearch:
+		if request.Search.Keyword == "" || len(request.Search.SitesToSearch) == 0 {
+			return nil, errors.New("a search job needs a keyword and sites to search")
+		}
+	default:
+		return nil, fmt.Errorf("unknown job kind %q", request.Kind)
+	}
+
+	if p.resuming.Load() {
+		return nil, ErrResuming
+	}
+
+	job, err := data.InsertJob(ctx, &models.Job{
+		UserID:    request.UserID,
+		Kind:      request.Kind,
+		Query:     request.Search,
+		RequestID: requestid.FromContext(ctx),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case p.queue <- job.ID:
+		slog.InfoContext(ctx, "Job queued", "job", job.ID, "kind", job.Kind)
+		return job, nil
+	default:
+		p.finish(ctx, job.ID, bson.M{"status": data.JobStatusFailed, "error": ErrQueueFull.Error(), "error_code": ErrQueueFull.Code})
+		return nil, ErrQueueFull
+	}
+}
+
+// Resume queues the jobs that were queued or running when the service last stopped, so that a restart
+// doesn't lose them. It runs in the background, and until it's done Submit rejects new jobs with ErrResuming,
+// so that they don't compete with the resumed ones for the places in the queue. While there are more jobs left
+// than places, it waits for the workers to free them for up to the timeout of a job, by which every worker
+// has freed one, and the jobs that couldn't be queued by then fail with ErrQueueFull.
+// The returned channel is closed once it's done
+func (p *Pool) Resume(ctx context.Context) <-chan struct{} {
+	p.resuming.Store(true)
+
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		defer p.resuming.Store(false)
+
+		ctx, cancel := context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+
+		resumed, err := p.resume(ctx)
+		if err != nil {
+			slog.Error("Could not resume unfinished jobs", "error", err)
+		} else if resumed > 0 {
+			slog.Info("Resumed unfinished jobs", "jobs", resumed)
+		}
+	}()
+
+	return done
+}
+
+// Ready returns ErrResuming while the pool is resuming jobs, so that the service isn't reported ready
+// before it takes new ones. It is a health.Check
+func (p *Pool) Ready(context.Context) error {
+	if p.resuming.Load() {
+		return ErrResuming
+	}
+
+	return nil
+}
+
+// resume queues the unfinished jobs until ctx ends, failing the ones that couldn't be queued by then,
+// and returns the number of jobs queued again
+func (p *Pool) resume(ctx context.Context) (int, error) {
+	ids, err := data.UnfinishedJobIDs(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, id := range ids {
+		select {
+		case p.queue <- id:
+		case <-ctx.Done():
+			for _, id := range ids[i:] {
+				p.finish(ctx, id, bson.M{"status": data.JobStatusFailed, "error": ErrQueueFull.Error(), "error_code": ErrQueueFull.Code})
+			}
+
+			slog.Warn("Could not queue every unfinished job again", "queued", i, "failed", len(ids)-i)
+			return i, nil
+		}
+	}
+
+	return len(ids), nil
+}
+
+// work runs the queued jobs one after the other
+func (p *Pool) work() {
+	for id := range p.queue {
+		p.run(id)
+	}
+}
+
+// run runs the job with the provided id and stores its result or the error it ended with
+func (p *Pool) run(id string) {
+	job, err := data.GetJob(context.Background(), id, "")
+	if err != nil {
+		slog.Error("Could not load queued job", "job", id, "error", err)
+		return
+	}
+
+	// the job keeps the request id of its submission, so its logs can be followed back to it
+	ctx, cancel := context.WithTimeout(requestid.NewContext(context.Background(), job.RequestID), p.timeout)
+	defer cancel()
+
+	// jobs outlive the request that submitted them, so each one starts a trace of its own
+	ctx, span := tracing.Start(ctx, "job "+job.Kind, trace.WithAttributes(attribute.String("search.job", id)))
+	defer span.End()
+
+	err = data.UpdateJob(ctx, id, bson.M{"status": data.JobStatusRunning, "progress": 0})
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not start job", "job", id, "error", err)
+		return
+	}
+
+	start := time.Now()
+
+	var result bson.M
+
+	switch job.Kind {
+	case data.JobKindPDF:
+		result, err = p.runPDF(ctx, job)
+	case data.JobKindSearch:
+		result, err = p.runSearch(ctx, job)
+	default:
+		err = fmt.Errorf("unknown job kind %q", job.Kind)
+	}
+
+	if err != nil {
+		slog.WarnContext(ctx, "Job failed", "job", id, "kind", job.Kind, "error", err, "latency_ms", time.Since(start).Milliseconds())
+		code := errcode.Of(err)
+		if code == "" {
+			code = errcode.Internal
+		}
+
+		p.finish(ctx, id, bson.M{"status": data.JobStatusFailed, "error": err.Error(), "error_code": code})
+		return
+	}
+
+	slog.InfoContext(ctx, "Job done", "job", id, "kind", job.Kind, "latency_ms", time.Since(start).Milliseconds())
+
+	result["status"] = data.JobStatusDone
+	result["progress"] = 100
+
+	p.finish(ctx, id, result)
+}
+
+// runPDF retrieves the pdf of the job's query
+func (p *Pool) runPDF(ctx context.Context, job *models.Job) (bson.M, error) {
+	pdf, err := data.SearchForPDF(ctx, &job.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	return bson.M{"pdf": pdf}, nil
+}
+
+// runSearch searches the sites of the job's query, updating the job's progress as every site is searched
+func (p *Pool) runSearch(ctx context.Context, job *models.Job) (bson.M, error) {
+	siteResults, err := data.StreamEntriesByKeyword(ctx, &job.Query)
+	if err != nil {
+		return nil, err
+	}
+
+	sites := len(job.Query.SitesToSearch)
+	entries := make([]*models.SearchEntry, sites)
+	searched := 0
+
+	for result := range siteResults {
+		searched++
+
+		if result.Err == nil {
+			entries[result.Index] = result.Entry
+		}
+
+		// the last site's progress gets written along with the result
+		if searched < sites {
+			err = data.UpdateJob(ctx, job.ID, bson.M{"progress": searched * 100 / sites})
+			if err != nil {
+				slog.WarnContext(ctx, "Could not update job progress", "job", job.ID, "error", err)
+			}
+		}
+	}
+
+	if err = ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return bson.M{"entries": entries}, nil
+}
+
+// finish stores the final fields of a job and passes the finished job to OnFinish.
+// It doesn't use the job's context, which might have ended
+func (p *Pool) finish(ctx context.Context, id string, fields bson.M) {
+	ctx = requestid.Detach(ctx)
+
+	err := data.UpdateJob(ctx, id, fields)
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not store the result of job", "job", id, "error", err)
+		return
+	}
+
+	if p.OnFinish == nil {
+		return
+	}
+
+	job, err := data.GetJob(ctx, id, "")
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not load finished job", "job", id, "error", err)
+		return
+	}
+
+	p.OnFinish(ctx, job)
+}
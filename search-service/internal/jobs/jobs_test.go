@@ -0,0 +1,75 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"search-service/internal/data"
+	"search-service/internal/memstore"
+	"search-service/internal/models"
+	"testing"
+	"time"
+)
+
+func TestResumeQueuesUnfinishedJobsBeforeNewOnes(t *testing.T) {
+	data.UseStore(memstore.New())
+
+	ctx := context.Background()
+
+	var ids []string
+	for i := 0; i < 3; i++ {
+		job, err := data.InsertJob(ctx, &models.Job{UserID: "u1", Kind: data.JobKindPDF, Query: models.SearchQuery{Keyword: "PMC1"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		ids = append(ids, job.ID)
+	}
+
+	// without workers the queue of 2 doesn't empty, so the third job can't be queued before the timeout of a job
+	p := NewPool(0, 2, 100*time.Millisecond)
+
+	done := p.Resume(ctx)
+
+	// until the jobs are resumed, new ones are rejected and the pool isn't ready
+	_, err := p.Submit(ctx, &models.JobRequest{UserID: "u1", Kind: data.JobKindPDF, Search: models.SearchQuery{Keyword: "PMC2"}})
+	if !errors.Is(err, ErrResuming) {
+		t.Errorf("got error %v submitting while resuming, want %v", err, ErrResuming)
+	}
+
+	if err := p.Ready(ctx); !errors.Is(err, ErrResuming) {
+		t.Errorf("got readiness %v while resuming, want %v", err, ErrResuming)
+	}
+
+	<-done
+
+	if err := p.Ready(ctx); err != nil {
+		t.Errorf("got readiness %v once resumed, want ready", err)
+	}
+
+	if len(p.queue) != 2 {
+		t.Fatalf("got %d jobs queued, want 2", len(p.queue))
+	}
+
+	for i, id := range ids[:2] {
+		if queued := <-p.queue; queued != id {
+			t.Errorf("got job %s queued at %d, want %s", queued, i, id)
+		}
+
+		p.queue <- id
+	}
+
+	job, err := data.GetJob(ctx, ids[2], "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if job.Status != data.JobStatusFailed || job.ErrorCode != ErrQueueFull.Code {
+		t.Errorf("got job %+v left over, want it failed with %s", job, ErrQueueFull.Code)
+	}
+
+	// the resumed jobs keep their places, so new ones are rejected rather than taking them
+	_, err = p.Submit(ctx, &models.JobRequest{UserID: "u1", Kind: data.JobKindPDF, Search: models.SearchQuery{Keyword: "PMC2"}})
+	if !errors.Is(err, ErrQueueFull) {
+		t.Errorf("got error %v submitting to the full queue, want %v", err, ErrQueueFull)
+	}
+}
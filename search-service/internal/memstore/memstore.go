@@ -0,0 +1,1013 @@
+// Package memstore keeps the collections of the service in memory, so that it can run without mongo,
+// e.g. in the all-in-one binary and in tests. It understands the part of mongo's query language
+// that the data package uses: filters on the equality of (dotted) fields, which also match arrays
+// holding the value, $in and $type; $set and $setOnInsert updates, along with upserts;
+// sorting, skipping and limiting; unique indexes, partial ones included, and TTL indexes, whose
+// expired documents are removed whenever the collection is used. Projections are ignored, while
+// any other operator is an error rather than a filter or an update that silently does nothing
+package memstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"search-service/internal/data"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// duplicateKeyCode is the code of the error of mongo for writes that break a unique index
+const duplicateKeyCode = 11000
+
+// Store is a data.Store that keeps its collections in memory
+type Store struct {
+	mu          sync.Mutex
+	collections map[string]*Collection
+}
+
+// New returns an empty Store
+func New() *Store {
+	return &Store{collections: make(map[string]*Collection)}
+}
+
+// Collection returns the collection with the provided name, creating it if it doesn't exist
+func (s *Store) Collection(name string) data.Collection {
+	return s.collection(name)
+}
+
+// CreateIndex creates the index in the collection with the provided name. Only unique and TTL indexes
+// have an effect, since the documents are searched one by one anyway. A unique index with a partial
+// filter expression only applies to the documents that match it, and a TTL index needs a single field
+func (s *Store) CreateIndex(ctx context.Context, collection string, index mongo.IndexModel) error {
+	if index.Options == nil {
+		return nil
+	}
+
+	keys, err := toD(index.Keys)
+	if err != nil {
+		return err
+	}
+
+	fields := make([]string, len(keys))
+	for i, key := range keys {
+		fields[i] = key.Key
+	}
+
+	var partial bson.M
+
+	if index.Options.PartialFilterExpression != nil {
+		partial, err = toM(index.Options.PartialFilterExpression)
+		if err != nil {
+			return err
+		}
+
+		if _, err = matches(bson.M{}, partial); err != nil {
+			return fmt.Errorf("memstore: partial filter expression of index %s: %w", strings.Join(fields, "_"), err)
+		}
+	}
+
+	if index.Options.ExpireAfterSeconds != nil && len(fields) != 1 {
+		return fmt.Errorf("memstore: TTL index %s must have a single field", strings.Join(fields, "_"))
+	}
+
+	c := s.collection(collection)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if index.Options.Unique != nil && *index.Options.Unique {
+		c.unique = append(c.unique, uniqueIndex{fields: fields, partial: partial})
+	}
+
+	if index.Options.ExpireAfterSeconds != nil {
+		c.ttl = append(c.ttl, ttlIndex{field: fields[0], expireAfter: time.Duration(*index.Options.ExpireAfterSeconds) * time.Second})
+	}
+
+	return nil
+}
+
+// Ping always succeeds, since the collections are in the memory of the process
+func (s *Store) Ping(ctx context.Context) error {
+	return nil
+}
+
+func (s *Store) collection(name string) *Collection {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c, ok := s.collections[name]
+	if !ok {
+		c = &Collection{mu: &s.mu}
+		s.collections[name] = c
+	}
+
+	return c
+}
+
+// Collection is a data.Collection kept in memory. The documents are stored as bson.M,
+// marshaled and unmarshaled the same way as they would be by mongo
+type Collection struct {
+	// mu is the mutex of the store, shared by all of its collections
+	mu     *sync.Mutex
+	docs   []bson.M
+	unique []uniqueIndex
+	ttl    []ttlIndex
+}
+
+// uniqueIndex is a unique index on the fields, which only applies to the documents
+// that match its partial filter expression when it has one
+type uniqueIndex struct {
+	fields  []string
+	partial bson.M
+}
+
+// ttlIndex removes the documents whose date in the field is older than expireAfter
+type ttlIndex struct {
+	field       string
+	expireAfter time.Duration
+}
+
+func (c *Collection) InsertOne(ctx context.Context, document any, opts ...*options.InsertOneOptions) (*mongo.InsertOneResult, error) {
+	doc, err := toM(document)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id, err := c.insert(doc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &mongo.InsertOneResult{InsertedID: id}, nil
+}
+
+// InsertMany inserts the documents in order, stopping at the first one that can't be inserted unless
+// the insert is unordered. As with mongo, the documents that couldn't be inserted are in a BulkWriteException
+func (c *Collection) InsertMany(ctx context.Context, documents []any, opts ...*options.InsertManyOptions) (*mongo.InsertManyResult, error) {
+	o := options.MergeInsertManyOptions(opts...)
+	ordered := o.Ordered == nil || *o.Ordered
+
+	docs := make([]bson.M, len(documents))
+	for i, document := range documents {
+		doc, err := toM(document)
+		if err != nil {
+			return nil, err
+		}
+
+		docs[i] = doc
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result := &mongo.InsertManyResult{}
+	bulkErr := mongo.BulkWriteException{}
+
+	for i, doc := range docs {
+		id, err := c.insert(doc)
+		if err != nil {
+			writeErr := mongo.WriteError{Index: i, Message: err.Error()}
+
+			var writeException mongo.WriteException
+			if errors.As(err, &writeException) && len(writeException.WriteErrors) > 0 {
+				writeErr = writeException.WriteErrors[0]
+				writeErr.Index = i
+			}
+
+			bulkErr.WriteErrors = append(bulkErr.WriteErrors, mongo.BulkWriteError{WriteError: writeErr})
+
+			if ordered {
+				break
+			}
+			continue
+		}
+
+		result.InsertedIDs = append(result.InsertedIDs, id)
+	}
+
+	if len(bulkErr.WriteErrors) > 0 {
+		return result, bulkErr
+	}
+
+	return result, nil
+}
+
+func (c *Collection) FindOne(ctx context.Context, filter any, opts ...*options.FindOneOptions) *mongo.SingleResult {
+	o := options.MergeFindOneOptions(opts...)
+
+	docs, err := c.find(ctx, filter, o.Sort, o.Skip, nil)
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
+
+	if len(docs) == 0 {
+		return mongo.NewSingleResultFromDocument(bson.D{}, mongo.ErrNoDocuments, nil)
+	}
+
+	return mongo.NewSingleResultFromDocument(docs[0], nil, nil)
+}
+
+func (c *Collection) Find(ctx context.Context, filter any, opts ...*options.FindOptions) (*mongo.Cursor, error) {
+	o := options.MergeFindOptions(opts...)
+
+	docs, err := c.find(ctx, filter, o.Sort, o.Skip, o.Limit)
+	if err != nil {
+		return nil, err
+	}
+
+	documents := make([]any, len(docs))
+	for i, doc := range docs {
+		documents[i] = doc
+	}
+
+	return mongo.NewCursorFromDocuments(documents, nil, nil)
+}
+
+func (c *Collection) CountDocuments(ctx context.Context, filter any, opts ...*options.CountOptions) (int64, error) {
+	docs, err := c.find(ctx, filter, nil, nil, nil)
+
+	return int64(len(docs)), err
+}
+
+func (c *Collection) UpdateOne(ctx context.Context, filter any, update any, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return c.update(ctx, filter, update, options.MergeUpdateOptions(opts...).Upsert, false)
+}
+
+func (c *Collection) UpdateMany(ctx context.Context, filter any, update any, opts ...*options.UpdateOptions) (*mongo.UpdateResult, error) {
+	return c.update(ctx, filter, update, options.MergeUpdateOptions(opts...).Upsert, true)
+}
+
+func (c *Collection) FindOneAndUpdate(ctx context.Context, filter any, update any, opts ...*options.FindOneAndUpdateOptions) *mongo.SingleResult {
+	o := options.MergeFindOneAndUpdateOptions(opts...)
+
+	if err := ctx.Err(); err != nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
+
+	f, u, err := filterAndUpdate(filter, update)
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	after := o.ReturnDocument != nil && *o.ReturnDocument == options.After
+
+	matched, err := c.matching(f)
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
+
+	if len(matched) == 0 {
+		if o.Upsert == nil || !*o.Upsert {
+			return mongo.NewSingleResultFromDocument(bson.D{}, mongo.ErrNoDocuments, nil)
+		}
+
+		doc, err := c.upsert(f, u)
+		if err != nil {
+			return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+		}
+
+		if !after {
+			return mongo.NewSingleResultFromDocument(bson.D{}, mongo.ErrNoDocuments, nil)
+		}
+
+		return mongo.NewSingleResultFromDocument(doc, nil, nil)
+	}
+
+	if err = sortDocs(matched, o.Sort); err != nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
+
+	before := copyM(matched[0])
+
+	_, err = c.apply(matched[0], u)
+	if err != nil {
+		return mongo.NewSingleResultFromDocument(bson.D{}, err, nil)
+	}
+
+	if after {
+		return mongo.NewSingleResultFromDocument(matched[0], nil, nil)
+	}
+
+	return mongo.NewSingleResultFromDocument(before, nil, nil)
+}
+
+func (c *Collection) DeleteOne(ctx context.Context, filter any, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return c.delete(ctx, filter, false)
+}
+
+func (c *Collection) DeleteMany(ctx context.Context, filter any, opts ...*options.DeleteOptions) (*mongo.DeleteResult, error) {
+	return c.delete(ctx, filter, true)
+}
+
+func (c *Collection) Drop(ctx context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.docs = nil
+
+	return nil
+}
+
+// insert stores the document, giving it an id if it has none, and returns its id.
+// The caller must hold the lock
+func (c *Collection) insert(doc bson.M) (any, error) {
+	if _, ok := doc["_id"]; !ok {
+		doc["_id"] = primitive.NewObjectID()
+	}
+
+	// the expired documents are gone before their unique fields are compared
+	c.expire(time.Now())
+
+	if err := c.checkUnique(doc); err != nil {
+		return nil, err
+	}
+
+	c.docs = append(c.docs, doc)
+
+	return doc["_id"], nil
+}
+
+// find returns copies of the documents that match the filter, sorted, skipped and limited
+func (c *Collection) find(ctx context.Context, filter, sortSpec any, skip, limit *int64) ([]bson.M, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f, err := toM(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	matched, err := c.matching(f)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = sortDocs(matched, sortSpec); err != nil {
+		return nil, err
+	}
+
+	if skip != nil {
+		if *skip >= int64(len(matched)) {
+			matched = nil
+		} else if *skip > 0 {
+			matched = matched[*skip:]
+		}
+	}
+
+	if limit != nil && *limit > 0 && *limit < int64(len(matched)) {
+		matched = matched[:*limit]
+	}
+
+	docs := make([]bson.M, len(matched))
+	for i, doc := range matched {
+		docs[i] = copyM(doc)
+	}
+
+	return docs, nil
+}
+
+// update applies the update to the first or all of the documents that match the filter,
+// inserting a document made of the filter and the update if none does and upsert is set
+func (c *Collection) update(ctx context.Context, filter, update any, upsert *bool, many bool) (*mongo.UpdateResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f, u, err := filterAndUpdate(filter, update)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	matched, err := c.matching(f)
+	if err != nil {
+		return nil, err
+	}
+
+	if !many && len(matched) > 1 {
+		matched = matched[:1]
+	}
+
+	result := &mongo.UpdateResult{MatchedCount: int64(len(matched))}
+
+	if len(matched) == 0 && upsert != nil && *upsert {
+		doc, err := c.upsert(f, u)
+		if err != nil {
+			return nil, err
+		}
+
+		result.UpsertedCount = 1
+		result.UpsertedID = doc["_id"]
+
+		return result, nil
+	}
+
+	for _, doc := range matched {
+		modified, err := c.apply(doc, u)
+		if err != nil {
+			return result, err
+		}
+
+		if modified {
+			result.ModifiedCount++
+		}
+	}
+
+	return result, nil
+}
+
+// upsert inserts the document that an upsert creates: the equality conditions of the filter
+// along with the fields of $set and $setOnInsert. The caller must hold the lock
+func (c *Collection) upsert(filter, update bson.M) (bson.M, error) {
+	set, setOnInsert, err := updateFields(update)
+	if err != nil {
+		return nil, err
+	}
+
+	doc := bson.M{}
+
+	for key, value := range filter {
+		if _, isOperator := value.(bson.M); !isOperator {
+			setPath(doc, key, value)
+		}
+	}
+
+	for _, fields := range []bson.M{setOnInsert, set} {
+		for key, value := range fields {
+			setPath(doc, key, value)
+		}
+	}
+
+	if _, err := c.insert(doc); err != nil {
+		return nil, err
+	}
+
+	return doc, nil
+}
+
+// apply sets the fields of the $set of the update in the document and returns whether any of them changed,
+// leaving the document as it was if the update would break a unique index. The caller must hold the lock
+func (c *Collection) apply(doc, update bson.M) (bool, error) {
+	fields, _, err := updateFields(update)
+	if err != nil {
+		return false, err
+	}
+
+	updated := copyM(doc)
+	modified := false
+
+	for key, value := range fields {
+		current, ok := lookup(updated, key)
+		if !ok || !equal(current, value) {
+			modified = true
+		}
+
+		setPath(updated, key, value)
+	}
+
+	if err := c.checkUnique(updated); err != nil {
+		return false, err
+	}
+
+	for key := range doc {
+		delete(doc, key)
+	}
+	for key, value := range updated {
+		doc[key] = value
+	}
+
+	return modified, nil
+}
+
+// updateFields returns the fields of the $set and the $setOnInsert of the update,
+// or an error if it has other operators or they aren't documents
+func updateFields(update bson.M) (set, setOnInsert bson.M, err error) {
+	for operator, value := range update {
+		if operator != "$set" && operator != "$setOnInsert" {
+			return nil, nil, fmt.Errorf("memstore: unsupported update operator %s", operator)
+		}
+
+		fields, ok := value.(bson.M)
+		if !ok {
+			return nil, nil, fmt.Errorf("memstore: %s must be a document, got %T", operator, value)
+		}
+
+		if operator == "$set" {
+			set = fields
+		} else {
+			setOnInsert = fields
+		}
+	}
+
+	return set, setOnInsert, nil
+}
+
+// delete removes the first or all of the documents that match the filter
+func (c *Collection) delete(ctx context.Context, filter any, many bool) (*mongo.DeleteResult, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	f, err := toM(filter)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// matching first, so that no document is deleted if the filter can't be applied,
+	// which also removes the expired documents
+	if _, err = c.matching(f); err != nil {
+		return nil, err
+	}
+
+	result := &mongo.DeleteResult{}
+	kept := c.docs[:0]
+
+	for _, doc := range c.docs {
+		if many || result.DeletedCount == 0 {
+			if matched, _ := matches(doc, f); matched {
+				result.DeletedCount++
+				continue
+			}
+		}
+
+		kept = append(kept, doc)
+	}
+
+	c.docs = kept
+
+	return result, nil
+}
+
+// matching returns the stored documents that match the filter, in the order they were inserted,
+// once the expired ones are removed. The caller must hold the lock
+func (c *Collection) matching(filter bson.M) ([]bson.M, error) {
+	// checking the filter on an empty document, so that it's rejected even if nothing is stored
+	if _, err := matches(bson.M{}, filter); err != nil {
+		return nil, err
+	}
+
+	c.expire(time.Now())
+
+	var matched []bson.M
+
+	for _, doc := range c.docs {
+		if ok, _ := matches(doc, filter); ok {
+			matched = append(matched, doc)
+		}
+	}
+
+	return matched, nil
+}
+
+// expire removes the documents whose date in the field of a TTL index is older than the index's expiry.
+// Mongo removes them in the background, while here they are removed whenever the collection gets used,
+// so that they are never found. The caller must hold the lock
+func (c *Collection) expire(now time.Time) {
+	if len(c.ttl) == 0 {
+		return
+	}
+
+	kept := c.docs[:0]
+
+	for _, doc := range c.docs {
+		if !c.expired(doc, now) {
+			kept = append(kept, doc)
+		}
+	}
+
+	// clearing the tail, so that the removed documents can be collected
+	for i := len(kept); i < len(c.docs); i++ {
+		c.docs[i] = nil
+	}
+
+	c.docs = kept
+}
+
+// expired reports whether the document is expired by a TTL index. As with mongo,
+// documents without a date in the field never expire. The caller must hold the lock
+func (c *Collection) expired(doc bson.M, now time.Time) bool {
+	for _, index := range c.ttl {
+		value, _ := lookup(doc, index.field)
+
+		if date, ok := value.(primitive.DateTime); ok && !date.Time().Add(index.expireAfter).After(now) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkUnique returns a duplicate key error, as mongo would, if another document has
+// the same values as doc for the fields of a unique index. Partial indexes only compare
+// the documents that match their filter. The caller must hold the lock
+func (c *Collection) checkUnique(doc bson.M) error {
+	for _, index := range c.unique {
+		if !index.covers(doc) {
+			continue
+		}
+
+		for _, other := range c.docs {
+			if equal(other["_id"], doc["_id"]) || !index.covers(other) {
+				continue
+			}
+
+			same := true
+			for _, field := range index.fields {
+				a, _ := lookup(doc, field)
+				b, _ := lookup(other, field)
+
+				if !equal(a, b) {
+					same = false
+					break
+				}
+			}
+
+			if same {
+				return mongo.WriteException{WriteErrors: []mongo.WriteError{{
+					Code:    duplicateKeyCode,
+					Message: fmt.Sprintf("E11000 duplicate key error, index: %s", strings.Join(index.fields, "_")),
+				}}}
+			}
+		}
+	}
+
+	return nil
+}
+
+// covers reports whether the document is in the index, which is every document unless the index is partial
+func (index uniqueIndex) covers(doc bson.M) bool {
+	if index.partial == nil {
+		return true
+	}
+
+	// the filter got checked when the index was created
+	matched, _ := matches(doc, index.partial)
+
+	return matched
+}
+
+// matches reports whether the document matches every condition of the filter, or returns an error
+// if the filter uses conditions that aren't supported. Every condition gets checked, even after one
+// doesn't match, so that the error doesn't depend on the document
+func matches(doc, filter bson.M) (bool, error) {
+	matched := true
+
+	for key, condition := range filter {
+		if strings.HasPrefix(key, "$") {
+			return false, fmt.Errorf("memstore: unsupported query operator %s", key)
+		}
+
+		value, _ := lookup(doc, key)
+
+		if operators, ok := condition.(bson.M); ok {
+			isOperators, err := hasOperators(operators)
+			if err != nil {
+				return false, fmt.Errorf("memstore: condition of %s %w", key, err)
+			}
+
+			if isOperators {
+				ok, err := matchesOperators(value, operators)
+				if err != nil {
+					return false, err
+				}
+
+				matched = matched && ok
+				continue
+			}
+		}
+
+		matched = matched && matchesValue(value, condition)
+	}
+
+	return matched, nil
+}
+
+// matchesOperators reports whether a value matches the query operators of a condition.
+// Only $in and $type are supported, any other operator is an error
+func matchesOperators(value any, operators bson.M) (bool, error) {
+	// checking every operator before matching, so that an unsupported one is never skipped
+	for operator, operand := range operators {
+		switch operator {
+		case "$in":
+			if _, ok := operand.(bson.A); !ok {
+				return false, fmt.Errorf("memstore: $in needs an array, got %T", operand)
+			}
+		case "$type":
+			name, ok := operand.(string)
+			if !ok {
+				return false, fmt.Errorf("memstore: $type needs the alias of a type, got %T", operand)
+			}
+
+			if _, ok := typeAliases[name]; !ok {
+				return false, fmt.Errorf("memstore: unsupported $type %s", name)
+			}
+		default:
+			return false, fmt.Errorf("memstore: unsupported query operator %s", operator)
+		}
+	}
+
+	for operator, operand := range operators {
+		switch operator {
+		case "$in":
+			values := operand.(bson.A)
+
+			found := false
+			for _, option := range values {
+				if matchesValue(value, option) {
+					found = true
+					break
+				}
+			}
+
+			if !found {
+				return false, nil
+			}
+		case "$type":
+			if !typeAliases[operand.(string)](value) {
+				return false, nil
+			}
+		}
+	}
+
+	return true, nil
+}
+
+// typeAliases are the aliases of the bson types that $type supports, with the check of a value's type
+var typeAliases = map[string]func(value any) bool{
+	"string":   func(value any) bool { _, ok := value.(string); return ok },
+	"bool":     func(value any) bool { _, ok := value.(bool); return ok },
+	"int":      func(value any) bool { _, ok := value.(int32); return ok },
+	"long":     func(value any) bool { _, ok := value.(int64); return ok },
+	"double":   func(value any) bool { _, ok := value.(float64); return ok },
+	"number":   func(value any) bool { _, ok := number(value); return ok },
+	"date":     func(value any) bool { _, ok := value.(primitive.DateTime); return ok },
+	"objectId": func(value any) bool { _, ok := value.(primitive.ObjectID); return ok },
+	"object":   func(value any) bool { _, ok := value.(bson.M); return ok },
+	"array":    func(value any) bool { _, ok := value.(bson.A); return ok },
+}
+
+// matchesValue reports whether a value equals the one of a condition or, as in mongo, is an array that holds it
+func matchesValue(value, condition any) bool {
+	if equal(value, condition) {
+		return true
+	}
+
+	if array, ok := value.(bson.A); ok {
+		for _, item := range array {
+			if equal(item, condition) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// hasOperators reports whether the keys of a condition are query operators rather than the fields of a subdocument,
+// or returns an error if it mixes both, which mongo rejects too
+func hasOperators(condition bson.M) (bool, error) {
+	operators := 0
+	for key := range condition {
+		if strings.HasPrefix(key, "$") {
+			operators++
+		}
+	}
+
+	if operators > 0 && operators < len(condition) {
+		return false, errors.New("mixes query operators and fields")
+	}
+
+	return operators > 0, nil
+}
+
+// sortDocs sorts the documents by the fields of the sort spec, a bson.D or bson.M of fields and 1 or -1
+func sortDocs(docs []bson.M, sortSpec any) error {
+	if sortSpec == nil {
+		return nil
+	}
+
+	spec, err := toD(sortSpec)
+	if err != nil {
+		return fmt.Errorf("memstore: invalid sort: %w", err)
+	}
+
+	for _, field := range spec {
+		if n, ok := number(field.Value); !ok || (n != 1 && n != -1) {
+			return fmt.Errorf("memstore: the sort order of %s must be 1 or -1, got %v", field.Key, field.Value)
+		}
+	}
+
+	sort.SliceStable(docs, func(i, j int) bool {
+		for _, field := range spec {
+			a, _ := lookup(docs[i], field.Key)
+			b, _ := lookup(docs[j], field.Key)
+
+			cmp := compare(a, b)
+			if cmp == 0 {
+				continue
+			}
+
+			if n, _ := number(field.Value); n < 0 {
+				return cmp > 0
+			}
+
+			return cmp < 0
+		}
+
+		return false
+	})
+
+	return nil
+}
+
+// compare orders two values of the same type, missing values coming first
+func compare(a, b any) int {
+	if an, ok := number(a); ok {
+		if bn, ok := number(b); ok {
+			switch {
+			case an < bn:
+				return -1
+			case an > bn:
+				return 1
+			}
+			return 0
+		}
+	}
+
+	switch a := a.(type) {
+	case nil:
+		if b == nil {
+			return 0
+		}
+		return -1
+	case string:
+		if b, ok := b.(string); ok {
+			return strings.Compare(a, b)
+		}
+	case primitive.ObjectID:
+		if b, ok := b.(primitive.ObjectID); ok {
+			return bytes.Compare(a[:], b[:])
+		}
+	case primitive.DateTime:
+		if b, ok := b.(primitive.DateTime); ok {
+			return compare(int64(a), int64(b))
+		}
+	case bool:
+		if b, ok := b.(bool); ok && a != b {
+			if a {
+				return 1
+			}
+			return -1
+		}
+		return 0
+	}
+
+	if b == nil {
+		return 1
+	}
+
+	return 0
+}
+
+// equal reports whether two values are equal, numbers being equal regardless of their type
+func equal(a, b any) bool {
+	if an, ok := number(a); ok {
+		bn, ok := number(b)
+		return ok && an == bn
+	}
+
+	return reflect.DeepEqual(a, b)
+}
+
+// number returns the value of a number of any of the types that bson decodes numbers into
+func number(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+
+	return 0, false
+}
+
+// lookup returns the value of a dotted field of the document
+func lookup(doc bson.M, path string) (any, bool) {
+	var value any = doc
+
+	for _, key := range strings.Split(path, ".") {
+		sub, ok := value.(bson.M)
+		if !ok {
+			return nil, false
+		}
+
+		value, ok = sub[key]
+		if !ok {
+			return nil, false
+		}
+	}
+
+	return value, true
+}
+
+// setPath sets the value of a dotted field of the document, creating the subdocuments it's in
+func setPath(doc bson.M, path string, value any) {
+	keys := strings.Split(path, ".")
+
+	for _, key := range keys[:len(keys)-1] {
+		sub, ok := doc[key].(bson.M)
+		if !ok {
+			sub = bson.M{}
+			doc[key] = sub
+		}
+
+		doc = sub
+	}
+
+	doc[keys[len(keys)-1]] = value
+}
+
+// filterAndUpdate returns the filter and the update of an update operation as bson.M
+func filterAndUpdate(filter, update any) (bson.M, bson.M, error) {
+	f, err := toM(filter)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	u, err := toM(update)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return f, u, nil
+}
+
+// toM marshals v and unmarshals it into a bson.M, so that it holds the same types as the stored documents
+func toM(v any) (bson.M, error) {
+	if v == nil {
+		return bson.M{}, nil
+	}
+
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	m := bson.M{}
+
+	err = bson.Unmarshal(raw, &m)
+
+	return m, err
+}
+
+// toD marshals v and unmarshals it into a bson.D, keeping the order of its fields
+func toD(v any) (bson.D, error) {
+	raw, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	var d bson.D
+
+	err = bson.Unmarshal(raw, &d)
+
+	return d, err
+}
+
+// copyM returns a deep copy of the document, so that the stored one can't be changed through it
+func copyM(doc bson.M) bson.M {
+	copied, err := toM(doc)
+	if err != nil {
+		return doc
+	}
+
+	return copied
+}
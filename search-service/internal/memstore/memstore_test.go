@@ -0,0 +1,441 @@
+package memstore
+
+import (
+	"context"
+	"errors"
+	"search-service/internal/data"
+	"search-service/internal/models"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// mustM returns v as the bson.M that the store would get for it
+func mustM(t *testing.T, v any) bson.M {
+	t.Helper()
+
+	m, err := toM(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m
+}
+
+func TestMatches(t *testing.T) {
+	doc := bson.M{"user_id": "u1", "count": int32(3), "tags": bson.A{"a", "b"}, "times": bson.M{"read": true}}
+
+	tests := []struct {
+		name    string
+		filter  bson.M
+		matched bool
+		err     bool
+	}{
+		{name: "empty filter", filter: bson.M{}, matched: true},
+		{name: "equal field", filter: bson.M{"user_id": "u1"}, matched: true},
+		{name: "other value", filter: bson.M{"user_id": "u2"}},
+		{name: "numbers of another type", filter: bson.M{"count": int64(3)}, matched: true},
+		{name: "dotted field", filter: bson.M{"times.read": true}, matched: true},
+		{name: "missing field", filter: bson.M{"times.sent": true}},
+		{name: "array holding the value", filter: bson.M{"tags": "b"}, matched: true},
+		{name: "subdocument", filter: bson.M{"times": bson.M{"read": true}}, matched: true},
+		{name: "$in", filter: bson.M{"user_id": bson.M{"$in": bson.A{"u2", "u1"}}}, matched: true},
+		{name: "$in without the value", filter: bson.M{"user_id": bson.M{"$in": bson.A{"u2"}}}},
+		{name: "$type of the value", filter: bson.M{"user_id": bson.M{"$type": "string"}}, matched: true},
+		{name: "$type of another type", filter: bson.M{"count": bson.M{"$type": "string"}}},
+		{name: "$type of a missing field", filter: bson.M{"article_key": bson.M{"$type": "string"}}},
+		{name: "unsupported $type", filter: bson.M{"count": bson.M{"$type": "decimal"}}, err: true},
+		{name: "every condition", filter: bson.M{"user_id": "u1", "count": 4}},
+		{name: "unsupported operator", filter: bson.M{"count": bson.M{"$gt": 1}}, err: true},
+		{name: "unsupported operator after a mismatch", filter: bson.M{"user_id": "u2", "count": bson.M{"$gt": 1}}, err: true},
+		{name: "top level operator", filter: bson.M{"$or": bson.A{bson.M{"user_id": "u1"}}}, err: true},
+		{name: "$in of a value", filter: bson.M{"user_id": bson.M{"$in": "u1"}}, err: true},
+		{name: "operators mixed with fields", filter: bson.M{"times": bson.M{"$in": bson.A{}, "read": true}}, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matched, err := matches(doc, mustM(t, tt.filter))
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v, want an error: %t", err, tt.err)
+			}
+
+			if matched != tt.matched {
+				t.Errorf("got matched %t, want %t", matched, tt.matched)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name     string
+		update   bson.M
+		modified bool
+		want     bson.M
+		err      bool
+	}{
+		{
+			name:     "sets fields",
+			update:   bson.M{"$set": bson.M{"name": "new", "times.read": true}},
+			modified: true,
+			want:     bson.M{"name": "new", "times": bson.M{"read": true}},
+		},
+		{
+			name:   "same values",
+			update: bson.M{"$set": bson.M{"name": "old"}},
+			want:   bson.M{"name": "old"},
+		},
+		{
+			name:   "only $setOnInsert",
+			update: bson.M{"$setOnInsert": bson.M{"name": "new"}},
+			want:   bson.M{"name": "old"},
+		},
+		{name: "unsupported operator", update: bson.M{"$inc": bson.M{"count": 1}}, want: bson.M{"name": "old"}, err: true},
+		{name: "replacement document", update: bson.M{"name": "new"}, want: bson.M{"name": "old"}, err: true},
+		{name: "$set of a value", update: bson.M{"$set": "new"}, want: bson.M{"name": "old"}, err: true},
+		{name: "$set of an array", update: bson.M{"$set": bson.A{bson.M{"name": "new"}}}, want: bson.M{"name": "old"}, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := New().collection("docs")
+
+			doc := bson.M{"_id": "1", "name": "old"}
+			c.docs = []bson.M{doc}
+
+			modified, err := c.apply(doc, mustM(t, tt.update))
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v, want an error: %t", err, tt.err)
+			}
+
+			if modified != tt.modified {
+				t.Errorf("got modified %t, want %t", modified, tt.modified)
+			}
+
+			for key, value := range tt.want {
+				if got, _ := lookup(doc, key); !equal(got, value) {
+					t.Errorf("got %s %v, want %v", key, got, value)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckUnique(t *testing.T) {
+	c := New().collection("alerts")
+	c.unique = []uniqueIndex{{fields: []string{"user_id", "article.id"}}}
+
+	c.docs = []bson.M{
+		{"_id": "1", "user_id": "u1", "article": bson.M{"id": "a"}},
+		{"_id": "2", "user_id": "u1", "article": bson.M{"id": "b"}},
+	}
+
+	tests := []struct {
+		name      string
+		doc       bson.M
+		duplicate bool
+	}{
+		{name: "other user", doc: bson.M{"_id": "3", "user_id": "u2", "article": bson.M{"id": "a"}}},
+		{name: "other article", doc: bson.M{"_id": "3", "user_id": "u1", "article": bson.M{"id": "c"}}},
+		{name: "same fields", doc: bson.M{"_id": "3", "user_id": "u1", "article": bson.M{"id": "a"}}, duplicate: true},
+		{name: "the document itself", doc: bson.M{"_id": "1", "user_id": "u1", "article": bson.M{"id": "a"}}},
+		{name: "the document changed into another", doc: bson.M{"_id": "1", "user_id": "u1", "article": bson.M{"id": "b"}}, duplicate: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := c.checkUnique(tt.doc)
+			if duplicate := mongo.IsDuplicateKeyError(err); duplicate != tt.duplicate {
+				t.Errorf("got error %v, want a duplicate key error: %t", err, tt.duplicate)
+			}
+		})
+	}
+}
+
+func TestPartialUniqueIndexOnlyCoversTheMatchingDocuments(t *testing.T) {
+	ctx := context.Background()
+
+	s := New()
+
+	err := s.CreateIndex(ctx, "alerts", mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}, {Key: "article_key", Value: 1}},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"article_key": bson.M{"$type": "string"}}),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := s.Collection("alerts")
+
+	// the documents without a key are out of the index, so they don't collide on the missing one
+	for i := 0; i < 2; i++ {
+		if _, err := c.InsertOne(ctx, bson.M{"user_id": "u1"}); err != nil {
+			t.Fatalf("got error %v inserting a document out of the index", err)
+		}
+	}
+
+	if _, err := c.InsertOne(ctx, bson.M{"user_id": "u1", "article_key": "a"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.InsertOne(ctx, bson.M{"user_id": "u1", "article_key": "a"}); !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("got error %v inserting the same key, want a duplicate key error", err)
+	}
+
+	err = s.CreateIndex(ctx, "alerts", mongo.IndexModel{
+		Keys:    bson.D{{Key: "user_id", Value: 1}},
+		Options: options.Index().SetUnique(true).SetPartialFilterExpression(bson.M{"read": bson.M{"$exists": true}}),
+	})
+	if err == nil {
+		t.Error("got no error creating an index with an unsupported partial filter")
+	}
+}
+
+func TestTTLIndexExpiresDocuments(t *testing.T) {
+	ctx := context.Background()
+
+	s := New()
+
+	err := s.CreateIndex(ctx, "subscriptions", mongo.IndexModel{
+		Keys:    bson.D{{Key: "times.updated_at", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(60),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := s.Collection("subscriptions")
+
+	now := time.Now()
+
+	docs := []any{
+		bson.M{"_id": "expired", "key": "a", "times": bson.M{"updated_at": now.Add(-2 * time.Minute)}},
+		bson.M{"_id": "fresh", "key": "a", "times": bson.M{"updated_at": now}},
+		bson.M{"_id": "undated", "key": "a", "times": bson.M{"updated_at": "yesterday"}},
+	}
+
+	if _, err := c.InsertMany(ctx, docs); err != nil {
+		t.Fatal(err)
+	}
+
+	cursor, err := c.Find(ctx, bson.M{"key": "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var found []bson.M
+	if err := cursor.All(ctx, &found); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(found) != 2 || found[0]["_id"] != "fresh" || found[1]["_id"] != "undated" {
+		t.Errorf("got documents %v, want the expired one gone", found)
+	}
+
+	err = s.CreateIndex(ctx, "subscriptions", mongo.IndexModel{
+		Keys:    bson.D{{Key: "a", Value: 1}, {Key: "b", Value: 1}},
+		Options: options.Index().SetExpireAfterSeconds(60),
+	})
+	if err == nil {
+		t.Error("got no error creating a TTL index of two fields")
+	}
+}
+
+func TestSortDocs(t *testing.T) {
+	docs := func() []bson.M {
+		return []bson.M{
+			{"_id": "1", "page": int32(2), "name": "b"},
+			{"_id": "2", "page": int32(1), "name": "c"},
+			{"_id": "3", "name": "a"},
+			{"_id": "4", "page": int64(2), "name": "a"},
+		}
+	}
+
+	tests := []struct {
+		name string
+		sort any
+		ids  []string
+		err  bool
+	}{
+		{name: "no sort", ids: []string{"1", "2", "3", "4"}},
+		{name: "ascending with missing values first", sort: bson.D{{Key: "page", Value: 1}}, ids: []string{"3", "2", "1", "4"}},
+		{name: "descending", sort: bson.D{{Key: "page", Value: -1}}, ids: []string{"1", "4", "2", "3"}},
+		{name: "by a second field", sort: bson.D{{Key: "page", Value: -1}, {Key: "name", Value: 1}}, ids: []string{"4", "1", "2", "3"}},
+		{name: "bson.M", sort: bson.M{"name": -1}, ids: []string{"2", "1", "3", "4"}},
+		{name: "invalid order", sort: bson.D{{Key: "page", Value: "desc"}}, err: true},
+		{name: "order of 0", sort: bson.D{{Key: "page", Value: 0}}, err: true},
+		{name: "not a document", sort: "page", err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sorted := docs()
+
+			err := sortDocs(sorted, tt.sort)
+			if (err != nil) != tt.err {
+				t.Fatalf("got error %v, want an error: %t", err, tt.err)
+			}
+
+			if tt.err {
+				return
+			}
+
+			for i, id := range tt.ids {
+				if sorted[i]["_id"] != id {
+					t.Fatalf("got document %v at %d, want %s", sorted[i]["_id"], i, id)
+				}
+			}
+		})
+	}
+}
+
+func TestUnsupportedQueriesFailWithoutChangingTheCollection(t *testing.T) {
+	ctx := context.Background()
+
+	c := New().Collection("docs")
+
+	if _, err := c.InsertOne(ctx, bson.M{"_id": "1", "count": 1}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := c.Find(ctx, bson.M{"count": bson.M{"$gte": 1}}); err == nil {
+		t.Error("got no error for a find with $gte")
+	}
+
+	if _, err := c.Find(ctx, bson.M{}, options.Find().SetSort(bson.D{{Key: "count", Value: "asc"}})); err == nil {
+		t.Error("got no error for a find with an invalid sort")
+	}
+
+	if _, err := c.UpdateOne(ctx, bson.M{"_id": "1"}, bson.M{"$inc": bson.M{"count": 1}}); err == nil {
+		t.Error("got no error for an update with $inc")
+	}
+
+	if _, err := c.UpdateOne(ctx, bson.M{"_id": "2"}, bson.M{"$set": "value"}, options.Update().SetUpsert(true)); err == nil {
+		t.Error("got no error for an upsert with a $set of a value")
+	}
+
+	if _, err := c.DeleteMany(ctx, bson.M{"count": bson.M{"$lt": 5}}); err == nil {
+		t.Error("got no error for a delete with $lt")
+	}
+
+	var doc bson.M
+	if err := c.FindOne(ctx, bson.M{"_id": "1"}).Decode(&doc); err != nil || !equal(doc["count"], 1) {
+		t.Errorf("got document %v and error %v, want it unchanged", doc, err)
+	}
+
+	if n, _ := c.CountDocuments(ctx, bson.M{}); n != 1 {
+		t.Errorf("got %d documents, want 1", n)
+	}
+}
+
+func TestInsertManyReportsTheDuplicates(t *testing.T) {
+	tests := []struct {
+		name     string
+		ordered  bool
+		inserted int
+		indexes  []int
+	}{
+		{name: "ordered stops at the first duplicate", ordered: true, inserted: 1, indexes: []int{1}},
+		{name: "unordered inserts the others", inserted: 2, indexes: []int{1, 3}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+
+			s := New()
+			if err := s.CreateIndex(ctx, "docs", mongo.IndexModel{Keys: bson.D{{Key: "key", Value: 1}}, Options: options.Index().SetUnique(true)}); err != nil {
+				t.Fatal(err)
+			}
+
+			c := s.Collection("docs")
+
+			if _, err := c.InsertOne(ctx, bson.M{"key": "a"}); err != nil {
+				t.Fatal(err)
+			}
+
+			result, err := c.InsertMany(ctx, []any{bson.M{"key": "b"}, bson.M{"key": "a"}, bson.M{"key": "c"}, bson.M{"key": "c"}}, options.InsertMany().SetOrdered(tt.ordered))
+
+			var bulkErr mongo.BulkWriteException
+			if !errors.As(err, &bulkErr) || !mongo.IsDuplicateKeyError(err) {
+				t.Fatalf("got error %v, want a bulk write exception of duplicate keys", err)
+			}
+
+			if len(result.InsertedIDs) != tt.inserted {
+				t.Errorf("got %d documents inserted, want %d", len(result.InsertedIDs), tt.inserted)
+			}
+
+			if len(bulkErr.WriteErrors) != len(tt.indexes) {
+				t.Fatalf("got write errors %+v, want ones for %v", bulkErr.WriteErrors, tt.indexes)
+			}
+
+			for i, writeErr := range bulkErr.WriteErrors {
+				if writeErr.Index != tt.indexes[i] || writeErr.Code != duplicateKeyCode {
+					t.Errorf("got write error %+v, want a duplicate key of document %d", writeErr, tt.indexes[i])
+				}
+			}
+		})
+	}
+}
+
+// the data package runs on the memory store in the all-in-one binary, so its unique indexes must hold there too
+func TestConcurrentRefreshesRecordAlertsOnce(t *testing.T) {
+	ctx := context.Background()
+
+	data.UseStore(New())
+
+	if err := data.EnsureIndexes(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, user := range []string{"u1", "u2"} {
+		if _, err := data.InsertInto("saved_searches", &models.SavedSearch{UserID: user, Keyword: "asthma", SitesToSearch: []string{"pubmed"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entry := &models.SearchEntry{Keyword: "asthma", Origin: "pubmed"}
+	articles := []map[string]any{{"pmid": "1", "title": "a"}, {"pmid": "2", "title": "b"}}
+
+	var (
+		wg       sync.WaitGroup
+		recorded atomic.Int64
+	)
+
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			n, err := data.RecordAlerts(ctx, entry, articles)
+			if err != nil {
+				t.Error(err)
+			}
+
+			recorded.Add(int64(n))
+		}()
+	}
+
+	wg.Wait()
+
+	for _, user := range []string{"u1", "u2"} {
+		page, err := data.ListAlerts(ctx, &models.AlertQuery{UserID: user})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if page.Total != int64(len(articles)) {
+			t.Errorf("got %d alerts of %s, want one per article", page.Total, user)
+		}
+	}
+
+	if recorded.Load() != 4 {
+		t.Errorf("got %d alerts reported as recorded, want 4", recorded.Load())
+	}
+}
@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"common/httpmetrics"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// namespace prefixes the names of the metrics of the search-service
+const namespace = "search"
+
+var (
+	requests = httpmetrics.New(namespace)
+
+	mongoLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "mongo_lookups_total",
+		Help:      "Lookups of search entries in mongo, by site and result (hit, or miss when the scraper gets called).",
+	}, []string{"site", "result"})
+)
+
+// Middleware is the middleware that counts every request of the service and observes its latency
+func Middleware(next http.Handler) http.Handler {
+	return requests.Middleware(next)
+}
+
+// MongoLookup records a lookup of a search entry of the site in mongo
+func MongoLookup(site string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+
+	mongoLookups.WithLabelValues(site, result).Inc()
+}
@@ -1,6 +1,9 @@
 package models
 
-import "time"
+import (
+	"common/errcode"
+	"time"
+)
 
 // DataEntry is the interface that has to be implemented my all
 // structs that are ment to be inserted in mongodb
@@ -26,16 +29,12 @@ type PDFEntry struct {
 }
 
 // SeachQuery holds the keyword to be searched as well as the site preferences
+// CallbackURL, if given, gets notified with a webhook once the result is ready
+// and, for searches, whenever the entries of the keyword get refreshed
 type SearchQuery struct {
-	Keyword       string   `json:"keyword"`
-	SitesToSearch []string `json:"sites_to_search,omitempty"`
-}
-
-// JsonResponse is the standard response object that the service writes to the http.ResponseWriter
-type JsonResponse struct {
-	Error   bool   `json:"error"`
-	Message string `json:"message"`
-	Data    any    `json:"data,omitempty"`
+	Keyword       string   `bson:"keyword" json:"keyword"`
+	SitesToSearch []string `bson:"sites_to_search,omitempty" json:"sites_to_search,omitempty"`
+	CallbackURL   string   `bson:"callback_url,omitempty" json:"callback_url,omitempty"`
 }
 
 // Times holds the standard time data for a mongo entry
@@ -43,3 +42,191 @@ type Times struct {
 	CreatedAt time.Time `bson:"created_at" json:"created_at"`
 	UpdatedAt time.Time `bson:"updated_at" json:"updated_at"`
 }
+
+// HistoryEntry holds one search or one opened article of a user, stored in the 'search_history' collection
+type HistoryEntry struct {
+	ID      string         `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID  string         `bson:"user_id" json:"user_id"`
+	Event   string         `bson:"event" json:"event"`
+	Keyword string         `bson:"keyword" json:"keyword"`
+	Origin  string         `bson:"origin,omitempty" json:"origin,omitempty"`
+	Article map[string]any `bson:"article,omitempty" json:"article,omitempty"`
+	Times
+}
+
+// HistoryRecord is the request to record a search of a user, or the articles the user opened from it
+type HistoryRecord struct {
+	UserID string      `json:"user_id"`
+	Entry  SearchEntry `json:"entry"`
+}
+
+// HistoryQuery is the request to list or clear the history of a user
+type HistoryQuery struct {
+	UserID  string `json:"user_id"`
+	Page    int    `json:"page,omitempty"`
+	PerPage int    `json:"per_page,omitempty"`
+}
+
+// HistoryPage is one page of the history of a user, newest first
+type HistoryPage struct {
+	Entries []*HistoryEntry `json:"entries"`
+	Page    int             `json:"page"`
+	PerPage int             `json:"per_page"`
+	Total   int64           `json:"total"`
+}
+
+// Job is a search or a pdf retrieval that runs in the background, stored in the 'jobs' collection.
+// Progress is the percentage of the work done, and the result is found in Entries for
+// searches or PDF for pdf retrievals once the job is done
+type Job struct {
+	ID        string         `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID    string         `bson:"user_id" json:"user_id"`
+	Kind      string         `bson:"kind" json:"kind"`
+	Query     SearchQuery    `bson:"query" json:"query"`
+	Status    string         `bson:"status" json:"status"`
+	Progress  int            `bson:"progress" json:"progress"`
+	Entries   []*SearchEntry `bson:"entries,omitempty" json:"entries,omitempty"`
+	PDF       *PDFEntry      `bson:"pdf,omitempty" json:"pdf,omitempty"`
+	Error     string         `bson:"error,omitempty" json:"error,omitempty"`
+	ErrorCode errcode.Code   `bson:"error_code,omitempty" json:"error_code,omitempty"`
+	RequestID string         `bson:"request_id,omitempty" json:"-"`
+	Times
+}
+
+// JobRequest is the request to submit a job of a user (with its kind and query)
+// or to get the status of one (with its id)
+type JobRequest struct {
+	UserID string      `json:"user_id"`
+	ID     string      `json:"id,omitempty"`
+	Kind   string      `json:"kind,omitempty"`
+	Search SearchQuery `json:"search,omitempty"`
+}
+
+// Subscription is a callback url that gets notified whenever the search entries of a keyword
+// get refreshed, stored in the 'webhook_subscriptions' collection until it expires
+type Subscription struct {
+	ID      string `bson:"_id,omitempty" json:"id,omitempty"`
+	Keyword string `bson:"keyword" json:"keyword"`
+	URL     string `bson:"url" json:"url"`
+	Times
+}
+
+// DeadLetter is a webhook notification that could not be delivered after all of its attempts,
+// stored in the 'webhook_dead_letters' collection
+type DeadLetter struct {
+	ID        string `bson:"_id,omitempty" json:"id,omitempty"`
+	URL       string `bson:"url" json:"url"`
+	Event     string `bson:"event" json:"event"`
+	Payload   string `bson:"payload" json:"payload"`
+	Attempts  int    `bson:"attempts" json:"attempts"`
+	LastError string `bson:"last_error" json:"last_error"`
+	Times
+}
+
+// DeadLetterQuery is the request to list a page of the dead letters
+type DeadLetterQuery struct {
+	Page    int `json:"page,omitempty"`
+	PerPage int `json:"per_page,omitempty"`
+}
+
+// DeadLetterPage is one page of the dead letters, newest first
+type DeadLetterPage struct {
+	Entries []*DeadLetter `json:"entries"`
+	Page    int           `json:"page"`
+	PerPage int           `json:"per_page"`
+	Total   int64         `json:"total"`
+}
+
+// Account is a user of the app, stored in the 'accounts' collection
+type Account struct {
+	ID           string `bson:"_id,omitempty" json:"id,omitempty"`
+	Email        string `bson:"email" json:"email"`
+	Name         string `bson:"name,omitempty" json:"name,omitempty"`
+	PasswordHash string `bson:"password_hash" json:"-"`
+	Times
+}
+
+// AccountRequest is the request to register an account or to log into one
+type AccountRequest struct {
+	Email    string `json:"email"`
+	Password string `json:"password"`
+	Name     string `json:"name,omitempty"`
+}
+
+// SavedSearch is a keyword a user saved along with the sites to search it in,
+// stored in the 'saved_searches' collection
+type SavedSearch struct {
+	ID            string   `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID        string   `bson:"user_id" json:"user_id"`
+	Keyword       string   `bson:"keyword" json:"keyword"`
+	SitesToSearch []string `bson:"sites_to_search" json:"sites_to_search"`
+	Times
+}
+
+// ArticleRef identifies an article by its origin and its id there:
+// the PMID for pubmed, the url for nhs and the title for wiki
+type ArticleRef struct {
+	ID     string `bson:"article_id" json:"id"`
+	Origin string `bson:"origin" json:"origin"`
+	Title  string `bson:"title,omitempty" json:"title,omitempty"`
+	Link   string `bson:"link,omitempty" json:"link,omitempty"`
+}
+
+// Bookmark is an article a user saved into one of their reading lists, stored in the 'bookmarks' collection
+type Bookmark struct {
+	ID      string     `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID  string     `bson:"user_id" json:"user_id"`
+	List    string     `bson:"list" json:"list"`
+	Article ArticleRef `bson:"article" json:"article"`
+	Times
+}
+
+// ReadingList is a named list of the bookmarks of a user
+type ReadingList struct {
+	Name      string      `json:"name"`
+	Bookmarks []*Bookmark `json:"bookmarks"`
+}
+
+// LibraryRequest is the request to act on the saved searches or the reading lists of a user.
+// Search is the search to save, ID the saved search to delete, and List and Article
+// the reading list and the article to add, remove or list
+type LibraryRequest struct {
+	UserID  string       `json:"user_id"`
+	ID      string       `json:"id,omitempty"`
+	List    string       `json:"list,omitempty"`
+	Article *ArticleRef  `json:"article,omitempty"`
+	Search  *SearchQuery `json:"search,omitempty"`
+}
+
+// Alert is an article that a refresh of a search entry found for a saved search of a user,
+// stored in the 'alerts' collection until the user reads it
+type Alert struct {
+	ID      string         `bson:"_id,omitempty" json:"id,omitempty"`
+	UserID  string         `bson:"user_id" json:"user_id"`
+	Keyword string         `bson:"keyword" json:"keyword"`
+	Origin  string         `bson:"origin" json:"origin"`
+	Article map[string]any `bson:"article" json:"article"`
+	// ArticleKey identifies the article within the keyword and the origin, so that it's only alerted once per user
+	ArticleKey string `bson:"article_key,omitempty" json:"-"`
+	Read       bool   `bson:"read" json:"read"`
+	Times
+}
+
+// AlertQuery is the request to list a page of the alerts of a user (only the unread ones if Unread is set)
+// or to mark the alerts with the provided ids as read (all of them if no ids are given)
+type AlertQuery struct {
+	UserID  string   `json:"user_id"`
+	Unread  bool     `json:"unread,omitempty"`
+	IDs     []string `json:"ids,omitempty"`
+	Page    int      `json:"page,omitempty"`
+	PerPage int      `json:"per_page,omitempty"`
+}
+
+// AlertPage is one page of the alerts of a user, newest first, along with the number of their unread alerts
+type AlertPage struct {
+	Entries []*Alert `json:"entries"`
+	Page    int      `json:"page"`
+	PerPage int      `json:"per_page"`
+	Total   int64    `json:"total"`
+	Unread  int64    `json:"unread"`
+}
@@ -0,0 +1,363 @@
+package webhook
+
+import (
+	"bytes"
+	"common/requestid"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"search-service/internal/data"
+	"search-service/internal/models"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// SignatureHeader carries the signature of a notification: "sha256=" followed by the hex HMAC-SHA256
+	// of the timestamp header, a dot and the body, keyed with the webhook secret
+	SignatureHeader = "X-Webhook-Signature"
+	// TimestampHeader carries the unix time the notification was sent at, so receivers can reject old ones
+	TimestampHeader = "X-Webhook-Timestamp"
+	// EventHeader carries the event of the notification
+	EventHeader = "X-Webhook-Event"
+
+	// deliveryTimeout is how long a single delivery attempt may take
+	deliveryTimeout = 10 * time.Second
+	// deliveryWorkers is the number of notifications that get delivered at the same time
+	deliveryWorkers = 4
+	// deliveryQueue is the number of notifications that can wait for a worker, the ones that
+	// don't fit are stored as dead letters right away
+	deliveryQueue = 100
+)
+
+// ErrClosed is the error of the notifications that could not be delivered before the notifier was closed
+var ErrClosed = errors.New("the notifier was closed before the notification could be delivered")
+
+// The events that get notified
+const (
+	EventSearchCompleted = "search.completed"
+	EventSearchUpdated   = "search.updated"
+	EventPDFCompleted    = "pdf.completed"
+	EventJobFinished     = "job.finished"
+)
+
+// Notification is the body of every webhook delivery
+type Notification struct {
+	ID        string    `json:"id"`
+	Event     string    `json:"event"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
+
+// Notifier delivers signed notifications to callback urls with a fixed number of workers. Failed deliveries
+// are retried with an exponential backoff, and the ones that still fail are stored as dead letters
+type Notifier struct {
+	secret    []byte
+	attempts  int
+	baseDelay time.Duration
+	client    *http.Client
+	queue     chan delivery
+	// ctx ends when the notifier gets closed, which stops the workers and their waits between attempts
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// delivery is a notification waiting to be delivered
+type delivery struct {
+	ctx         context.Context
+	callbackURL string
+	event       string
+	body        []byte
+}
+
+// NewNotifier starts a Notifier that signs with the provided secret and
+// tries every delivery up to attempts times, waiting baseDelay after the first failure
+// and twice as long after every next one. Unless allowPrivate is set, callback urls
+// can only reach public addresses, so that they can't be used to call into the private network
+func NewNotifier(secret string, attempts int, baseDelay time.Duration, allowPrivate bool) *Notifier {
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	dialer := &net.Dialer{Timeout: deliveryTimeout}
+	if !allowPrivate {
+		dialer.Control = rejectPrivate
+	}
+
+	// the notifications don't go through a proxy, which would dial the callback urls in place of the dialer
+	transport := &http.Transport{
+		DialContext:         dialer.DialContext,
+		TLSHandshakeTimeout: deliveryTimeout,
+		MaxIdleConns:        deliveryWorkers,
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	n := &Notifier{
+		secret:    []byte(secret),
+		attempts:  attempts,
+		baseDelay: baseDelay,
+		client:    &http.Client{Timeout: deliveryTimeout, Transport: transport},
+		queue:     make(chan delivery, deliveryQueue),
+		ctx:       ctx,
+		cancel:    cancel,
+	}
+
+	n.wg.Add(deliveryWorkers)
+
+	for i := 0; i < deliveryWorkers; i++ {
+		go n.work()
+	}
+
+	return n
+}
+
+// Close stops the workers, cutting short the deliveries waiting for their next attempt, and stores
+// the notifications left undelivered as dead letters. It returns once the workers have stopped
+func (n *Notifier) Close() {
+	n.cancel()
+	n.wg.Wait()
+
+	for {
+		select {
+		case d := <-n.queue:
+			n.deadLetter(d, 0, ErrClosed)
+		default:
+			return
+		}
+	}
+}
+
+// Sign returns the value of the signature header for a body sent at the provided timestamp
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// CheckURL checks that a callback url can be notified: an absolute http or https url whose host,
+// if it's an ip address, is a public one. The addresses that host names resolve to are checked when dialing them
+func CheckURL(callbackURL string) error {
+	u, err := url.Parse(callbackURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("callback url %q must be an absolute http or https url", callbackURL)
+	}
+
+	if addr, err := netip.ParseAddr(u.Hostname()); err == nil && !isPublic(addr) {
+		return fmt.Errorf("callback url %q must not point to a private address", callbackURL)
+	}
+
+	return nil
+}
+
+// rejectPrivate is the control of the dialer of the notifications, which refuses to connect to
+// addresses that aren't public. It runs after the host name got resolved, so a name can't be
+// pointed at a private address after its callback url got checked
+func rejectPrivate(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return err
+	}
+
+	if !isPublic(addrPort.Addr()) {
+		return fmt.Errorf("callback urls may not connect to the private address %s", addrPort.Addr())
+	}
+
+	return nil
+}
+
+// nonGlobalPrefixes are the special purpose prefixes of the IANA registries that aren't globally reachable
+// and that the checks of netip don't cover, along with the IPv6 ones that embed IPv4 addresses, which could
+// be private ones. Any of them can reach hosts that a callback url must not
+var nonGlobalPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),       // this network
+	netip.MustParsePrefix("100.64.0.0/10"),   // shared address space of carrier grade NAT
+	netip.MustParsePrefix("192.0.0.0/24"),    // IETF protocol assignments
+	netip.MustParsePrefix("192.0.2.0/24"),    // documentation
+	netip.MustParsePrefix("192.88.99.0/24"),  // 6to4 relay anycast
+	netip.MustParsePrefix("198.18.0.0/15"),   // benchmarking
+	netip.MustParsePrefix("198.51.100.0/24"), // documentation
+	netip.MustParsePrefix("203.0.113.0/24"),  // documentation
+	netip.MustParsePrefix("240.0.0.0/4"),     // reserved, with the limited broadcast address
+	netip.MustParsePrefix("::/96"),           // IPv4 compatible
+	netip.MustParsePrefix("64:ff9b::/96"),    // IPv4/IPv6 translation
+	netip.MustParsePrefix("64:ff9b:1::/48"),  // local IPv4/IPv6 translation
+	netip.MustParsePrefix("100::/64"),        // discard only
+	netip.MustParsePrefix("2001::/23"),       // IETF protocol assignments, with Teredo
+	netip.MustParsePrefix("2001:db8::/32"),   // documentation
+	netip.MustParsePrefix("2002::/16"),       // 6to4
+	netip.MustParsePrefix("3fff::/20"),       // documentation
+}
+
+// isPublic reports whether the address is globally reachable: neither loopback, private, link-local,
+// multicast, unspecified nor in one of the nonGlobalPrefixes
+func isPublic(addr netip.Addr) bool {
+	addr = addr.Unmap()
+
+	if addr.IsLoopback() || addr.IsPrivate() || addr.IsLinkLocalUnicast() || addr.IsLinkLocalMulticast() ||
+		addr.IsInterfaceLocalMulticast() || addr.IsMulticast() || addr.IsUnspecified() {
+		return false
+	}
+
+	for _, prefix := range nonGlobalPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Notify queues the event with its data for delivery to the callback url. ctx only passes on
+// its request id, the delivery outlives it. If the queue is full, the notification is stored as a dead letter
+func (n *Notifier) Notify(ctx context.Context, callbackURL, event string, payload any) {
+	notification := Notification{
+		ID:        newID(),
+		Event:     event,
+		CreatedAt: time.Now().UTC(),
+		Data:      payload,
+	}
+
+	body, err := json.Marshal(notification)
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not encode webhook notification", "event", event, "error", err)
+		return
+	}
+
+	d := delivery{ctx: requestid.Detach(ctx), callbackURL: callbackURL, event: event, body: body}
+
+	if n.ctx.Err() != nil {
+		n.deadLetter(d, 0, ErrClosed)
+		return
+	}
+
+	select {
+	case n.queue <- d:
+	default:
+		n.deadLetter(d, 0, errors.New("too many webhook notifications waiting to be delivered"))
+	}
+}
+
+// work delivers the queued notifications one after the other until the notifier gets closed
+func (n *Notifier) work() {
+	defer n.wg.Done()
+
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case d := <-n.queue:
+			n.deliver(d)
+		}
+	}
+}
+
+// deliver tries to deliver the notification until it succeeds, runs out of attempts
+// or the notifier gets closed, in which case it's stored as a dead letter
+func (n *Notifier) deliver(d delivery) {
+	var err error
+
+	attempt := 1
+
+	for ; attempt <= n.attempts; attempt++ {
+		if attempt > 1 {
+			timer := time.NewTimer(n.baseDelay << (attempt - 2))
+
+			select {
+			case <-timer.C:
+			case <-n.ctx.Done():
+				timer.Stop()
+				n.deadLetter(d, attempt-1, ErrClosed)
+				return
+			}
+		}
+
+		err = n.send(d.ctx, d.callbackURL, d.event, d.body)
+		if err == nil {
+			slog.InfoContext(d.ctx, "Webhook delivered", "event", d.event, "url", d.callbackURL, "attempt", attempt)
+			return
+		}
+
+		slog.WarnContext(d.ctx, "Webhook delivery failed", "event", d.event, "url", d.callbackURL, "attempt", attempt, "attempts", n.attempts, "error", err)
+	}
+
+	n.deadLetter(d, n.attempts, err)
+}
+
+// deadLetter stores the notification that could not be delivered after the provided attempts
+func (n *Notifier) deadLetter(d delivery, attempts int, err error) {
+	deadLetter := &models.DeadLetter{
+		URL:       d.callbackURL,
+		Event:     d.event,
+		Payload:   string(d.body),
+		Attempts:  attempts,
+		LastError: err.Error(),
+	}
+
+	if err = data.InsertDeadLetter(deadLetter); err != nil {
+		slog.ErrorContext(d.ctx, "Could not store webhook dead letter", "event", d.event, "url", d.callbackURL, "error", err)
+	}
+}
+
+// send makes a single delivery attempt, which succeeds if the callback url answers with 2xx
+func (n *Notifier) send(ctx context.Context, callbackURL, event string, body []byte) error {
+	// closing the notifier also cuts short the attempt in flight
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	stop := context.AfterFunc(n.ctx, cancel)
+	defer stop()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, callbackURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set(EventHeader, event)
+	request.Header.Set(TimestampHeader, timestamp)
+	request.Header.Set(SignatureHeader, Sign(n.secret, timestamp, body))
+	request.Header.Set(requestid.Header, requestid.FromContext(ctx))
+
+	response, err := n.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		return errors.New("callback url answered with status " + response.Status)
+	}
+
+	return nil
+}
+
+// newID returns a random id for a notification, so receivers can tell retries of the same one apart
+func newID() string {
+	b := make([]byte, 12)
+
+	_, err := rand.Read(b)
+	if err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,71 @@
+package webhook
+
+import "testing"
+
+func TestCheckURL(t *testing.T) {
+	tests := []struct {
+		url   string
+		valid bool
+	}{
+		{url: "https://example.com/hook", valid: true},
+		{url: "http://93.184.216.34:8080/hook", valid: true},
+		{url: "ftp://example.com/hook", valid: false},
+		{url: "/hook", valid: false},
+		{url: "http://127.0.0.1/hook", valid: false},
+		{url: "http://[::1]/hook", valid: false},
+		{url: "http://10.0.0.5/hook", valid: false},
+		{url: "http://192.168.1.1/hook", valid: false},
+		{url: "http://169.254.169.254/latest/meta-data", valid: false},
+		{url: "http://0.0.0.0/hook", valid: false},
+		{url: "http://[::ffff:127.0.0.1]/hook", valid: false},
+		{url: "http://100.64.0.1/hook", valid: false},
+		{url: "http://192.0.0.170/hook", valid: false},
+		{url: "http://198.18.0.1/hook", valid: false},
+		{url: "http://255.255.255.255/hook", valid: false},
+		{url: "http://[64:ff9b::a00:1]/hook", valid: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			err := CheckURL(tt.url)
+			if (err == nil) != tt.valid {
+				t.Errorf("got error %v, want valid %t", err, tt.valid)
+			}
+		})
+	}
+}
+
+func TestRejectPrivate(t *testing.T) {
+	tests := []struct {
+		address string
+		allowed bool
+	}{
+		{address: "93.184.216.34:443", allowed: true},
+		{address: "[2606:2800:220:1:248:1893:25c8:1946]:443", allowed: true},
+		{address: "127.0.0.1:80", allowed: false},
+		{address: "172.16.0.1:80", allowed: false},
+		{address: "169.254.169.254:80", allowed: false},
+		{address: "[fe80::1]:80", allowed: false},
+		{address: "[fd00::1]:80", allowed: false},
+		{address: "[::]:80", allowed: false},
+		{address: "224.0.0.1:80", allowed: false},
+		{address: "100.100.100.200:80", allowed: false},
+		{address: "192.0.0.8:80", allowed: false},
+		{address: "198.19.255.1:80", allowed: false},
+		{address: "203.0.113.7:80", allowed: false},
+		{address: "[64:ff9b::7f00:1]:80", allowed: false},
+		{address: "[2002:a00:1::1]:80", allowed: false},
+		{address: "[2001::1]:80", allowed: false},
+		{address: "[2001:db8::1]:80", allowed: false},
+		{address: "[::10.0.0.1]:80", allowed: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			err := rejectPrivate("tcp", tt.address, nil)
+			if (err == nil) != tt.allowed {
+				t.Errorf("got error %v, want allowed %t", err, tt.allowed)
+			}
+		})
+	}
+}
@@ -0,0 +1,313 @@
+package server
+
+import (
+	"common/envelope"
+	"common/errcode"
+	"errors"
+	"net/http"
+	"search-service/internal/data"
+	"search-service/internal/jobs"
+	"search-service/internal/models"
+	"search-service/internal/webhook"
+)
+
+// LogSearchEntry inserts a SeachEntry into the mongodb
+// and writes an envelope.Response to the http.ResponseWriter
+// that indicates whether the insertion was successful
+func LogSearchEntry(w http.ResponseWriter, r *http.Request) {
+	logPayload := new(models.SearchEntry)
+
+	err := envelope.ReadJSON(w, r, logPayload)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	_, err = data.InsertInto("search_logs", logPayload)
+	if err != nil {
+		envelope.ErrorJSON(w, err, http.StatusBadGateway)
+		return
+	}
+
+	resp := &envelope.Response{
+		Error:   false,
+		Message: "logged",
+	}
+
+	envelope.WriteJSON(w, http.StatusCreated, resp)
+}
+
+// SearchOneEntry searches for one SearchEntry and writes an envelope.Response
+// to the http.ResponseWriter with the retrieved data or the error that occured
+func SearchOneEntry(w http.ResponseWriter, r *http.Request) {
+	searchPayload := new(models.SearchQuery)
+
+	err := envelope.ReadJSON(w, r, searchPayload)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	err = checkCallback(searchPayload)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	entry, err := data.SearchEntriesByKeyword(r.Context(), searchPayload)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	notifyCallback(r.Context(), searchPayload, webhook.EventSearchCompleted, entry)
+
+	resp := &envelope.Response{
+		Error:   false,
+		Message: "Search was successful!",
+		Data:    entry,
+	}
+
+	envelope.WriteJSON(w, http.StatusOK, resp)
+}
+
+// SearchPDF searches for the PDFEntry with the provided SearchQuery
+// and writes an envelope.Response with the retrieved PDFEntry or the error that occured
+func SearchPDF(w http.ResponseWriter, r *http.Request) {
+	searchPayload := new(models.SearchQuery)
+
+	err := envelope.ReadJSON(w, r, searchPayload)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	err = checkCallback(searchPayload)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	pdfEntry, err := data.SearchForPDF(r.Context(), searchPayload)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	notifyCallback(r.Context(), searchPayload, webhook.EventPDFCompleted, pdfEntry)
+
+	resp := &envelope.Response{
+		Error:   false,
+		Message: "PDF retrieved successfully!",
+		Data:    pdfEntry,
+	}
+
+	envelope.WriteJSON(w, http.StatusOK, resp)
+}
+
+// siteEvent is the data of the "entry" event sent for every site searched by StreamSearch
+type siteEvent struct {
+	Site  string              `json:"site"`
+	Entry *models.SearchEntry `json:"entry"`
+}
+
+// siteFailure holds the site that could not be searched and why
+type siteFailure struct {
+	Site  string       `json:"site"`
+	Code  errcode.Code `json:"code,omitempty"`
+	Error string       `json:"error"`
+}
+
+// doneEvent is the data of the "done" event that ends the stream of StreamSearch
+type doneEvent struct {
+	Searched int           `json:"searched"`
+	Failed   []siteFailure `json:"failed"`
+}
+
+// StreamSearch searches the same way as SearchOneEntry, but streams the results as Server-Sent Events:
+// an "entry" event with the SearchEntry of each site as soon as it's ready, and a final "done" event
+// that lists the sites that failed
+func StreamSearch(w http.ResponseWriter, r *http.Request) {
+	searchPayload := new(models.SearchQuery)
+
+	err := envelope.ReadJSON(w, r, searchPayload)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	siteResults, err := data.StreamEntriesByKeyword(r.Context(), searchPayload)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	done := doneEvent{Failed: []siteFailure{}}
+
+	for result := range siteResults {
+		done.Searched++
+
+		if result.Err != nil {
+			done.Failed = append(done.Failed, siteFailure{Site: result.Site, Code: errcode.Of(result.Err), Error: result.Err.Error()})
+			continue
+		}
+
+		// the client might have gone, but the rest of the results are still drained
+		// so that the search goroutines can finish and cache their results
+		writeEvent(w, "entry", siteEvent{Site: result.Site, Entry: result.Entry})
+	}
+
+	writeEvent(w, "done", done)
+}
+
+// RecordHistory records a search, or the articles opened from it, in the history of a user
+func RecordHistory(w http.ResponseWriter, r *http.Request) {
+	record := new(models.HistoryRecord)
+
+	err := envelope.ReadJSON(w, r, record)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	recorded, err := data.RecordHistory(r.Context(), record)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	resp := &envelope.Response{
+		Error:   false,
+		Message: "logged",
+		Data:    map[string]int{"recorded": recorded},
+	}
+
+	envelope.WriteJSON(w, http.StatusCreated, resp)
+}
+
+// ListHistory writes a page of the history of a user, newest first
+func ListHistory(w http.ResponseWriter, r *http.Request) {
+	query := new(models.HistoryQuery)
+
+	err := envelope.ReadJSON(w, r, query)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	page, err := data.ListHistory(r.Context(), query)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	resp := &envelope.Response{
+		Error:   false,
+		Message: "History retrieved successfully!",
+		Data:    page,
+	}
+
+	envelope.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ClearHistory deletes the whole history of a user
+func ClearHistory(w http.ResponseWriter, r *http.Request) {
+	query := new(models.HistoryQuery)
+
+	err := envelope.ReadJSON(w, r, query)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	deleted, err := data.ClearHistory(r.Context(), query)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	resp := &envelope.Response{
+		Error:   false,
+		Message: "History cleared",
+		Data:    map[string]int64{"deleted": deleted},
+	}
+
+	envelope.WriteJSON(w, http.StatusOK, resp)
+}
+
+// SubmitJob stores and queues a search or pdf retrieval job, writing the job with its id
+// right away. The job's status and result can then be polled with JobStatus
+func SubmitJob(w http.ResponseWriter, r *http.Request) {
+	request := new(models.JobRequest)
+
+	err := envelope.ReadJSON(w, r, request)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	err = checkCallback(&request.Search)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	job, err := jobPool.Submit(r.Context(), request)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, jobs.ErrQueueFull) || errors.Is(err, jobs.ErrResuming) {
+			status = http.StatusServiceUnavailable
+		}
+
+		envelope.ErrorJSON(w, err, status)
+		return
+	}
+
+	resp := &envelope.Response{
+		Error:   false,
+		Message: "Job submitted",
+		Data:    job,
+	}
+
+	envelope.WriteJSON(w, http.StatusAccepted, resp)
+}
+
+// JobStatus writes the status, progress and result of a job of the user
+func JobStatus(w http.ResponseWriter, r *http.Request) {
+	request := new(models.JobRequest)
+
+	err := envelope.ReadJSON(w, r, request)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	if request.UserID == "" {
+		envelope.ErrorJSON(w, errors.New("no user given to get the job of"))
+		return
+	}
+
+	job, err := data.GetJob(r.Context(), request.ID, request.UserID)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, data.ErrJobNotFound) {
+			status = http.StatusNotFound
+		}
+
+		envelope.ErrorJSON(w, err, status)
+		return
+	}
+
+	resp := &envelope.Response{
+		Error:   false,
+		Message: "Job retrieved successfully!",
+		Data:    job,
+	}
+
+	envelope.WriteJSON(w, http.StatusOK, resp)
+}
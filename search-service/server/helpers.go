@@ -0,0 +1,27 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// writeEvent writes data as a json Server-Sent Event with the provided event name
+// and flushes it to the client right away
+func writeEvent(w http.ResponseWriter, event string, data any) error {
+	out, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, out)
+	if err != nil {
+		return err
+	}
+
+	if flusher, ok := w.(http.Flusher); ok {
+		flusher.Flush()
+	}
+
+	return nil
+}
@@ -0,0 +1,277 @@
+package server
+
+import (
+	"common/envelope"
+	"errors"
+	"net/http"
+	"search-service/internal/data"
+	"search-service/internal/models"
+)
+
+// Register creates an account, the broker issues its token
+func Register(w http.ResponseWriter, r *http.Request) {
+	request := new(models.AccountRequest)
+
+	err := envelope.ReadJSON(w, r, request)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	account, err := data.Register(r.Context(), request)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, data.ErrEmailTaken) {
+			status = http.StatusConflict
+		}
+
+		envelope.ErrorJSON(w, err, status)
+		return
+	}
+
+	resp := &envelope.Response{
+		Error:   false,
+		Message: "Account registered",
+		Data:    account,
+	}
+
+	envelope.WriteJSON(w, http.StatusCreated, resp)
+}
+
+// Login checks the email and the password of an account and returns the account
+func Login(w http.ResponseWriter, r *http.Request) {
+	request := new(models.AccountRequest)
+
+	err := envelope.ReadJSON(w, r, request)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	account, err := data.Login(r.Context(), request)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, data.ErrInvalidCredentials) {
+			status = http.StatusUnauthorized
+		}
+
+		envelope.ErrorJSON(w, err, status)
+		return
+	}
+
+	resp := &envelope.Response{
+		Error:   false,
+		Message: "Logged in",
+		Data:    account,
+	}
+
+	envelope.WriteJSON(w, http.StatusOK, resp)
+}
+
+// SaveSearch saves a keyword with its sites to search for a user
+func SaveSearch(w http.ResponseWriter, r *http.Request) {
+	request := new(models.LibraryRequest)
+
+	err := envelope.ReadJSON(w, r, request)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	search, err := data.SaveSearch(r.Context(), request)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	resp := &envelope.Response{
+		Error:   false,
+		Message: "Search saved",
+		Data:    search,
+	}
+
+	envelope.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ListSavedSearches lists the saved searches of a user
+func ListSavedSearches(w http.ResponseWriter, r *http.Request) {
+	request := new(models.LibraryRequest)
+
+	err := envelope.ReadJSON(w, r, request)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	searches, err := data.ListSavedSearches(r.Context(), request.UserID)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	resp := &envelope.Response{
+		Error:   false,
+		Message: "Saved searches retrieved successfully!",
+		Data:    searches,
+	}
+
+	envelope.WriteJSON(w, http.StatusOK, resp)
+}
+
+// DeleteSavedSearch deletes a saved search of a user
+func DeleteSavedSearch(w http.ResponseWriter, r *http.Request) {
+	request := new(models.LibraryRequest)
+
+	err := envelope.ReadJSON(w, r, request)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	err = data.DeleteSavedSearch(r.Context(), request)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, data.ErrSavedSearchNotFound) {
+			status = http.StatusNotFound
+		}
+
+		envelope.ErrorJSON(w, err, status)
+		return
+	}
+
+	resp := &envelope.Response{
+		Error:   false,
+		Message: "Saved search deleted",
+	}
+
+	envelope.WriteJSON(w, http.StatusOK, resp)
+}
+
+// AddBookmark bookmarks an article in a reading list of a user
+func AddBookmark(w http.ResponseWriter, r *http.Request) {
+	request := new(models.LibraryRequest)
+
+	err := envelope.ReadJSON(w, r, request)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	bookmark, err := data.AddBookmark(r.Context(), request)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	resp := &envelope.Response{
+		Error:   false,
+		Message: "Article bookmarked",
+		Data:    bookmark,
+	}
+
+	envelope.WriteJSON(w, http.StatusCreated, resp)
+}
+
+// ListReadingLists lists the reading lists of a user, or only the requested one
+func ListReadingLists(w http.ResponseWriter, r *http.Request) {
+	request := new(models.LibraryRequest)
+
+	err := envelope.ReadJSON(w, r, request)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	lists, err := data.ListReadingLists(r.Context(), request)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	resp := &envelope.Response{
+		Error:   false,
+		Message: "Reading lists retrieved successfully!",
+		Data:    lists,
+	}
+
+	envelope.WriteJSON(w, http.StatusOK, resp)
+}
+
+// RemoveBookmark removes an article from a reading list of a user
+func RemoveBookmark(w http.ResponseWriter, r *http.Request) {
+	request := new(models.LibraryRequest)
+
+	err := envelope.ReadJSON(w, r, request)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	err = data.RemoveBookmark(r.Context(), request)
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.Is(err, data.ErrBookmarkNotFound) {
+			status = http.StatusNotFound
+		}
+
+		envelope.ErrorJSON(w, err, status)
+		return
+	}
+
+	resp := &envelope.Response{
+		Error:   false,
+		Message: "Bookmark removed",
+	}
+
+	envelope.WriteJSON(w, http.StatusOK, resp)
+}
+
+// ListAlerts lists a page of the alerts of a user, the articles that refreshes found for their saved searches
+func ListAlerts(w http.ResponseWriter, r *http.Request) {
+	query := new(models.AlertQuery)
+
+	err := envelope.ReadJSON(w, r, query)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	alerts, err := data.ListAlerts(r.Context(), query)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	resp := &envelope.Response{
+		Error:   false,
+		Message: "Alerts retrieved successfully!",
+		Data:    alerts,
+	}
+
+	envelope.WriteJSON(w, http.StatusOK, resp)
+}
+
+// MarkAlertsRead marks alerts of a user as read
+func MarkAlertsRead(w http.ResponseWriter, r *http.Request) {
+	query := new(models.AlertQuery)
+
+	err := envelope.ReadJSON(w, r, query)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	marked, err := data.MarkAlertsRead(r.Context(), query)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	resp := &envelope.Response{
+		Error:   false,
+		Message: "Alerts marked as read",
+		Data:    map[string]int64{"marked": marked},
+	}
+
+	envelope.WriteJSON(w, http.StatusOK, resp)
+}
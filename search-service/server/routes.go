@@ -0,0 +1,69 @@
+package server
+
+import (
+	"common/deadline"
+	"common/health"
+	"common/httpmetrics"
+	"common/httpmw"
+	"common/requestid"
+	"common/tracing"
+	"net/http"
+	"search-service/internal/data"
+	"search-service/internal/metrics"
+	"time"
+
+	"github.com/go-chi/chi"
+	"github.com/go-chi/chi/middleware"
+)
+
+// readyTimeout is how long the readiness checks of the dependencies may take
+const readyTimeout = 2 * time.Second
+
+func routes() http.Handler {
+	mux := chi.NewRouter()
+
+	checker := health.New(readyTimeout)
+	checker.Add("mongo", data.Ping)
+	checker.Add("jobs", jobPool.Ready)
+
+	mux.Use(middleware.Heartbeat("/ping"))
+	mux.Use(checker.Middleware)
+	mux.Use(requestid.Middleware)
+	mux.Use(deadline.Middleware)
+	mux.Use(tracing.Middleware)
+	mux.Use(metrics.Middleware)
+	mux.Use(httpmw.LogRequests)
+	mux.Use(httpmw.Recover)
+
+	mux.Method(http.MethodGet, "/metrics", httpmetrics.Handler())
+
+	mux.Post("/log-entry", LogSearchEntry)
+	mux.Post("/search-entry", SearchOneEntry)
+	mux.Post("/search-entry/stream", StreamSearch)
+	mux.Post("/get-pdf", SearchPDF)
+
+	mux.Post("/history/record", RecordHistory)
+	mux.Post("/history/list", ListHistory)
+	mux.Post("/history/clear", ClearHistory)
+
+	mux.Post("/jobs/submit", SubmitJob)
+	mux.Post("/jobs/status", JobStatus)
+
+	mux.Post("/webhooks/dead-letters", ListDeadLetters)
+
+	mux.Post("/accounts/register", Register)
+	mux.Post("/accounts/login", Login)
+
+	mux.Post("/saved-searches/save", SaveSearch)
+	mux.Post("/saved-searches/list", ListSavedSearches)
+	mux.Post("/saved-searches/delete", DeleteSavedSearch)
+
+	mux.Post("/reading-lists/add", AddBookmark)
+	mux.Post("/reading-lists/list", ListReadingLists)
+	mux.Post("/reading-lists/remove", RemoveBookmark)
+
+	mux.Post("/alerts/list", ListAlerts)
+	mux.Post("/alerts/read", MarkAlertsRead)
+
+	return mux
+}
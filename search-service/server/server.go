@@ -0,0 +1,93 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"search-service/internal/caller"
+	"search-service/internal/data"
+	"search-service/internal/jobs"
+	"search-service/internal/memstore"
+	"search-service/internal/webhook"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	// jobPool runs the jobs submitted to the service in the background
+	jobPool *jobs.Pool
+	// notifier delivers the webhook notifications, it's nil when no webhook secret is given
+	notifier *webhook.Notifier
+)
+
+// Config is the configuration of the service
+type Config struct {
+	// Mongo is the client of the database that holds the collections of the service,
+	// they are kept in memory instead when it's nil
+	Mongo *mongo.Client
+	// JobWorkers is the number of jobs that run at the same time
+	JobWorkers int
+	// JobQueue is the number of jobs that can wait for a worker before new ones get rejected
+	JobQueue int
+	// JobTimeout is how long a job may run before it fails
+	JobTimeout time.Duration
+	// WebhookSecret signs the webhook notifications, webhooks are disabled when it's empty
+	WebhookSecret string
+	// WebhookAttempts is the number of delivery attempts of a webhook notification before it becomes a dead letter
+	WebhookAttempts int
+	// WebhookRetryDelay is the delay before the first retry of a webhook delivery, doubled for every next one
+	WebhookRetryDelay time.Duration
+	// WebhookAllowPrivate lets callback urls reach loopback and private addresses, which only suits local setups
+	WebhookAllowPrivate bool
+	// ScraperURL is the base url of med-scraper-service, http://med-scraper-service when it's empty
+	ScraperURL string
+	// MedAPIURL is the base url of med-api-service, http://med-api-service when it's empty
+	MedAPIURL string
+}
+
+// New sets up the store, the services it calls, the job pool and the webhook notifier of the service,
+// starts resuming the jobs that were left unfinished and returns the handler of the service
+func New(ctx context.Context, config Config) (http.Handler, error) {
+	if config.ScraperURL != "" {
+		caller.ScraperURL = config.ScraperURL
+	}
+
+	if config.MedAPIURL != "" {
+		caller.MedAPIURL = config.MedAPIURL
+	}
+
+	if config.Mongo != nil {
+		data.NewConn(config.Mongo)
+	} else {
+		slog.Warn("No mongo client given, the collections are kept in memory")
+		data.UseStore(memstore.New())
+	}
+
+	err := data.EnsureIndexes(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	jobPool = jobs.NewPool(config.JobWorkers, config.JobQueue, config.JobTimeout)
+
+	if config.WebhookSecret != "" {
+		notifier = webhook.NewNotifier(config.WebhookSecret, config.WebhookAttempts, config.WebhookRetryDelay, config.WebhookAllowPrivate)
+		data.OnUpdate = notifyUpdate
+		jobPool.OnFinish = notifyJob
+	} else {
+		slog.Warn("No webhook secret given, callback urls are not accepted")
+	}
+
+	// the jobs are resumed in the background, and until they are the service rejects new ones and isn't ready
+	jobPool.Resume(context.Background())
+
+	return routes(), nil
+}
+
+// Close stops the webhook notifier, storing the notifications it could not deliver yet as dead letters
+func Close() {
+	if notifier != nil {
+		notifier.Close()
+	}
+}
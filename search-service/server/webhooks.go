@@ -0,0 +1,87 @@
+package server
+
+import (
+	"common/envelope"
+	"common/errcode"
+	"context"
+	"log/slog"
+	"net/http"
+	"search-service/internal/data"
+	"search-service/internal/models"
+	"search-service/internal/webhook"
+)
+
+// checkCallback checks that the callback url of the query, if it has one, can be notified
+func checkCallback(query *models.SearchQuery) error {
+	if query.CallbackURL == "" {
+		return nil
+	}
+
+	if notifier == nil {
+		return errcode.New(errcode.WebhooksDisabled, "webhooks are not enabled, no callback url can be given")
+	}
+
+	return webhook.CheckURL(query.CallbackURL)
+}
+
+// notifyCallback notifies the callback url of the query, if it has one, of the result of its request.
+// The callback urls of searches also get subscribed to the refreshes of the keyword's entries
+func notifyCallback(ctx context.Context, query *models.SearchQuery, event string, result any) {
+	if query.CallbackURL == "" || notifier == nil {
+		return
+	}
+
+	if event == webhook.EventSearchCompleted {
+		err := data.Subscribe(ctx, query.Keyword, query.CallbackURL)
+		if err != nil {
+			slog.WarnContext(ctx, "Could not subscribe callback url to keyword", "keyword", query.Keyword, "error", err)
+		}
+	}
+
+	notifier.Notify(ctx, query.CallbackURL, event, result)
+}
+
+// notifyUpdate notifies the callback urls subscribed to the keyword of a refreshed search entry
+func notifyUpdate(ctx context.Context, entry *models.SearchEntry) {
+	subscriptions, err := data.SubscriptionsFor(ctx, entry.Keyword)
+	if err != nil {
+		slog.WarnContext(ctx, "Could not get subscriptions of refreshed keyword", "keyword", entry.Keyword, "error", err)
+		return
+	}
+
+	for _, subscription := range subscriptions {
+		notifier.Notify(ctx, subscription.URL, webhook.EventSearchUpdated, entry)
+	}
+}
+
+// notifyJob notifies the callback url of a finished job, if it has one
+func notifyJob(ctx context.Context, job *models.Job) {
+	if job.Query.CallbackURL != "" {
+		notifier.Notify(ctx, job.Query.CallbackURL, webhook.EventJobFinished, job)
+	}
+}
+
+// ListDeadLetters writes a page of the webhook notifications that could not be delivered, newest first
+func ListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	query := new(models.DeadLetterQuery)
+
+	err := envelope.ReadJSON(w, r, query)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	page, err := data.ListDeadLetters(r.Context(), query)
+	if err != nil {
+		envelope.ErrorJSON(w, err)
+		return
+	}
+
+	resp := &envelope.Response{
+		Error:   false,
+		Message: "Dead letters retrieved successfully!",
+		Data:    page,
+	}
+
+	envelope.WriteJSON(w, http.StatusOK, resp)
+}